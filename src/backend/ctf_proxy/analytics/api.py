@@ -4,6 +4,7 @@ from fastapi import FastAPI, HTTPException
 from fastapi.middleware.cors import CORSMiddleware
 
 from ctf_proxy.analytics.db import AnalysisDB, make_analysis_db
+from ctf_proxy.analytics.lost_flags import propose_rules
 from ctf_proxy.analytics.preview import HTTP, TCP, PreviewRunner
 from ctf_proxy.analytics.rules_store import DRAFT, ENABLED, RulesStore, RuleValidationError
 from ctf_proxy.analytics.schemas import (
@@ -11,6 +12,9 @@ from ctf_proxy.analytics.schemas import (
     AnalysisRowsResponse,
     BackfillJobModel,
     BackfillRequest,
+    LostFlagProposalModel,
+    LostFlagsRequest,
+    LostFlagsResponse,
     MessageResponse,
     PreviewMatchModel,
     PreviewRequest,
@@ -182,6 +186,32 @@ async def tags_for_refs(request: TagsForRefsRequest):
     return TagsForRefsResponse(tags=tags)
 
 
+@app.post("/api/lost-flags", response_model=LostFlagsResponse)
+async def lost_flags(request: LostFlagsRequest):
+    active = require_store()
+    if source_reader is None:
+        raise HTTPException(status_code=500, detail="Analyzer not initialized")
+
+    leaks = source_reader.read_http_flag_leaks(request.flags)
+    proposals = []
+    for proposal in propose_rules(leaks):
+        active.save_draft(proposal.name, proposal.rule_source())
+        status = DRAFT
+        if request.observe:
+            active.promote(proposal.name)
+            status = ENABLED
+        proposals.append(
+            LostFlagProposalModel(
+                **vars(proposal), status=status, interceptor=proposal.interceptor_source()
+            )
+        )
+
+    found = {flag for flag, _ in leaks}
+    return LostFlagsResponse(
+        proposals=proposals, unmatched=[f for f in request.flags if f not in found]
+    )
+
+
 def job_model(job) -> BackfillJobModel:
     return BackfillJobModel(
         id=job.id,
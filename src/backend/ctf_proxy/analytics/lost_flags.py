@@ -0,0 +1,103 @@
+import hashlib
+import json
+import re
+from dataclasses import dataclass, field
+
+from ctf_proxy.analytics.context import RequestContext
+from ctf_proxy.analytics.rules_seed.fingerprint import path_template
+
+LOST_FLAG_TAG = "lost_flag"
+
+# Path template placeholders (see path_template) and the segments they stand for.
+SEGMENT_PATTERNS = {
+    "{num}": r"\d+",
+    "{uuid}": r"[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}",
+    "{hex}": r"[0-9a-fA-F]{16,}",
+    "{id}": r"[^/]+",
+    "{str}": r"[^/]+",
+}
+
+RULE_TEMPLATE = '''import re
+
+from ctf_proxy.analytics.rule import Match, PatternRule
+
+PATH_RE = re.compile({path_re})
+
+
+class {class_name}(PatternRule):
+    """Proposed from lost flags, taken by requests {request_ids}."""
+
+    name = {name}
+    port = {port}
+
+    def match(self, ctx):
+        if ctx.method == {method} and PATH_RE.fullmatch(ctx.path.split("?", 1)[0]):
+            yield Match(tag={tag}, meta={meta})
+'''
+
+INTERCEPTOR_TEMPLATE = """pathRe := regexp.MustCompile(`^{path_re}$`)
+RegisterHttpInterceptor({port}, {name}, MatchHttpRequest(Matcher{{
+\tMethod: MatchMethod({method}),
+\tPath:   func(p string) bool {{ return pathRe.MatchString(strings.SplitN(p, "?", 2)[0]) }},
+}}), DoHttpBlock)
+"""
+
+
+@dataclass
+class LostFlagProposal:
+    name: str
+    port: int
+    method: str
+    path: str
+    flags: list[str] = field(default_factory=list)
+    request_ids: list[int] = field(default_factory=list)
+
+    @property
+    def path_re(self) -> str:
+        return "/".join(SEGMENT_PATTERNS.get(s, re.escape(s)) for s in self.path.split("/"))
+
+    def rule_source(self) -> str:
+        return RULE_TEMPLATE.format(
+            path_re=json.dumps(self.path_re),
+            class_name="".join(part.capitalize() for part in self.name.split("_")),
+            request_ids=", ".join(str(i) for i in self.request_ids),
+            name=json.dumps(self.name),
+            port=self.port,
+            method=json.dumps(self.method),
+            tag=json.dumps(LOST_FLAG_TAG),
+            meta=json.dumps(f"{self.method} {self.path}"),
+        )
+
+    def interceptor_source(self) -> str:
+        """Go snippet blocking the pattern in the interceptor, for when the rule proves precise."""
+        return INTERCEPTOR_TEMPLATE.format(
+            path_re=self.path_re,
+            port=self.port,
+            name=json.dumps(self.name),
+            method=json.dumps(self.method),
+        )
+
+
+def proposal_name(port: int, method: str, path: str) -> str:
+    digest = hashlib.sha1(f"{port} {method} {path}".encode()).hexdigest()[:8]
+    return f"lost_flag_{port}_{re.sub(r'[^a-z0-9]', '', method.lower())}_{digest}"
+
+
+def propose_rules(leaks: list[tuple[str, RequestContext]]) -> list[LostFlagProposal]:
+    """Groups the requests that took lost flags by port, method and path shape."""
+    proposals: dict[tuple[int, str, str], LostFlagProposal] = {}
+    for flag, ctx in leaks:
+        method = ctx.method.upper()
+        path = path_template(ctx.path.split("?", 1)[0])
+        key = (ctx.port, method, path)
+        proposal = proposals.get(key)
+        if proposal is None:
+            proposal = LostFlagProposal(
+                name=proposal_name(*key), port=ctx.port, method=method, path=path
+            )
+            proposals[key] = proposal
+        if flag not in proposal.flags:
+            proposal.flags.append(flag)
+        if ctx.id not in proposal.request_ids:
+            proposal.request_ids.append(ctx.id)
+    return sorted(proposals.values(), key=lambda p: (-len(p.flags), p.port, p.name))
@@ -90,6 +90,27 @@ class TagsForRefsResponse(BaseModel):
     tags: dict[int, list[str]]
 
 
+class LostFlagsRequest(BaseModel):
+    flags: list[str]
+    observe: bool = False
+
+
+class LostFlagProposalModel(BaseModel):
+    name: str
+    port: int
+    method: str
+    path: str
+    flags: list[str]
+    request_ids: list[int]
+    status: str
+    interceptor: str
+
+
+class LostFlagsResponse(BaseModel):
+    proposals: list[LostFlagProposalModel]
+    unmatched: list[str]
+
+
 class BackfillRequest(BaseModel):
     target_id: int | None = None
     ports: list[int] | None = None
@@ -40,6 +40,24 @@ class SourceReader:
             requests = self.db.http_requests.read_by_ids(conn, ids)
             return self.hydrate_http(conn, requests)
 
+    def read_http_flag_leaks(self, flags: list[str]) -> list[tuple[str, RequestContext]]:
+        if not flags:
+            return []
+        with self.connect() as conn:
+            if not self.db.table_exists(conn, "flag"):
+                return []
+            leaks = self.db.flags.find_retrieved(conn, flags)
+            if not leaks:
+                return []
+            request_ids = sorted({row["request_id"] for row in leaks})
+            requests = self.db.http_requests.read_by_ids(conn, request_ids)
+            contexts = {ctx.id: ctx for ctx in self.hydrate_http(conn, requests)}
+        return [
+            (row["value"], contexts[row["request_id"]])
+            for row in leaks
+            if row["request_id"] in contexts
+        ]
+
     def hydrate_http(self, conn, requests) -> list[RequestContext]:
         if not requests:
             return []
@@ -3,7 +3,7 @@ from typing import ClassVar
 
 from psycopg import Cursor
 
-from ctf_proxy.db.connection import nul_safe
+from ctf_proxy.db.connection import Row, nul_safe
 from ctf_proxy.db.refs import Ref
 
 
@@ -77,3 +77,15 @@ class FlagTable:
                 for f in flags
             ],
         )
+
+    def find_retrieved(self, tx: Cursor, values: list[str]) -> list[Row]:
+        placeholders = ",".join(["%s"] * len(values))
+        return tx.execute(
+            f"""
+            SELECT flag.value, http_response.request_id
+            FROM flag JOIN http_response ON http_response.id = flag.http_response_id
+            WHERE flag.value IN ({placeholders})
+            ORDER BY http_response.request_id
+            """,
+            values,
+        ).fetchall()
@@ -227,3 +227,64 @@ def test_preview_broken_source_returns_400(client):
         json={"source": "def not valid !!!", "source_type": "http", "ids": [1]},
     )
     assert resp.status_code == 400
+
+
+def seed_lost_flags() -> dict[str, int]:
+    db = make_db()
+    ids = {}
+    with db.connect() as conn:
+        tx = conn.cursor()
+        for name, path, flag in [
+            ("first", "/api/notes/12", "FLAGAAAA"),
+            ("second", "/api/notes/345?raw=1", "FLAGBBBB"),
+        ]:
+            request_id = db.http_requests.insert(
+                tx, port=8080, start_time=now_timestamp(), path=path, method="GET"
+            )
+            response_id = db.http_responses.insert(
+                tx, request_id=request_id, status=200, body=f"note: {flag}"
+            )
+            db.flags.insert(tx, value=flag, http_response_id=response_id, location="body")
+            ids[name] = request_id
+        conn.commit()
+    return ids
+
+
+def test_lost_flags_propose_draft_rule(client):
+    ids = seed_lost_flags()
+
+    resp = client.post("/api/lost-flags", json={"flags": ["FLAGAAAA", "FLAGBBBB", "FLAGCCCC"]})
+    assert resp.status_code == 200
+    body = resp.json()
+    assert body["unmatched"] == ["FLAGCCCC"]
+    assert len(body["proposals"]) == 1
+    proposal = body["proposals"][0]
+    assert proposal["port"] == 8080
+    assert proposal["method"] == "GET"
+    assert proposal["path"] == "/api/notes/{num}"
+    assert proposal["flags"] == ["FLAGAAAA", "FLAGBBBB"]
+    assert proposal["request_ids"] == [ids["first"], ids["second"]]
+    assert proposal["status"] == "draft"
+    assert "RegisterHttpInterceptor(8080" in proposal["interceptor"]
+
+    source = client.get(f"/api/rules/{proposal['name']}", params={"status": "draft"}).json()
+    resp = client.post(
+        "/api/preview",
+        json={
+            "source": source["source"],
+            "source_type": "http",
+            "ids": [ids["first"], client.ids["admin_id"]],
+        },
+    )
+    assert [m["ref_id"] for m in resp.json()["matches"]] == [ids["first"]]
+
+
+def test_lost_flags_observe_enables_rule(client):
+    seed_lost_flags()
+
+    resp = client.post("/api/lost-flags", json={"flags": ["FLAGAAAA"], "observe": True})
+    proposal = resp.json()["proposals"][0]
+    assert proposal["status"] == "enabled"
+
+    rules = {(r["name"], r["status"]) for r in client.get("/api/rules").json()["rules"]}
+    assert (proposal["name"], "enabled") in rules
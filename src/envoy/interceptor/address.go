@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"strconv"
+)
+
+// Game networks are often dual-stack, and with the listener on "::" IPv4 clients show up as
+// mapped addresses (::ffff:a.b.c.d). Source IPs are therefore canonicalized before they key any
+// state, and IPv6 sources are banned and limited by network, as one host usually owns a whole
+// /64 and could otherwise rotate addresses.
+
+// Prefix length IPv6 sources are keyed by unless "ipv6_prefix" is set.
+const defaultIPv6Prefix = 64
+
+// IP part of an "ip:port" address (the address itself if it has no port), canonicalized.
+func addressIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return canonicalIP(host)
+}
+
+// Canonical form of an IP: mapped IPv4 addresses as plain IPv4, IPv6 compressed and lower case.
+// Anything which is not an IP is returned as is.
+func canonicalIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip.String()
+}
+
+// Key of a source for bans and per-source limits: the IP for IPv4, its network ("2001:db8::/64")
+// for IPv6.
+func sourceKey(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String()
+	}
+	prefix := config.ipv6Prefix()
+	if prefix >= 128 {
+		return parsed.String()
+	}
+	masked := parsed.Mask(net.CIDRMask(prefix, 128))
+	return masked.String() + "/" + strconv.Itoa(prefix)
+}
+
+func (c pluginConfig) ipv6Prefix() int {
+	if c.IPv6Prefix <= 0 || c.IPv6Prefix > 128 {
+		return defaultIPv6Prefix
+	}
+	return c.IPv6Prefix
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Requests to admin_api_port are answered by the filter itself and never forwarded. They must carry
+// admin_api_secret in adminApiHeader.
+//
+//	GET    /bans                      list banned IPs and ban expiry
+//	POST   /bans?ip=<ip>&duration=1h  ban an IP (duration defaults to 1h)
+//	DELETE /bans?ip=<ip>              lift a ban
+//	GET    /allow                     list allowed IPs
+//	POST   /allow?ip=<ip>             allow an IP
+//	DELETE /allow?ip=<ip>             remove an IP from the allow list
+//	GET    /maintenance               list ports in maintenance
+//	POST   /maintenance?port=<port>   serve the maintenance page on a port
+//	DELETE /maintenance?port=<port>   serve the port again
+//	GET    /fuses                     list tripped fuses
+//	DELETE /fuses?name=<port>/<name>  reset a fuse
+//	GET    /recent?limit=20           last events (matches, blocks...), newest first
+const adminApiHeader = "x-ctf-admin-secret"
+
+const defaultAdminBan = time.Hour
+
+func init() {
+	registerStartGuard(guardAdminApi, (*httpCtx).serveAdminApi)
+}
+
+// Answers the stream if it targets the admin API port.
+func (h *httpCtx) serveAdminApi() bool {
+	if config.AdminApiPort == 0 {
+		return false
+	}
+	if port, err := h.properties.destinationPort(); err != nil || port != config.AdminApiPort {
+		return false
+	}
+
+	secret := h.getRequestHeader(adminApiHeader)
+	want := Secret(SecretAdminApi)
+	if want == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(want)) != 1 {
+		proxywasm.LogWarn("admin api: rejected request from " + h.sourceIP())
+		h.adminApiReply(403, map[string]string{"error": "forbidden"})
+		return true
+	}
+
+	u, err := url.ParseRequestURI(h.getRequestHeader(":path"))
+	if err != nil {
+		h.adminApiReply(400, map[string]string{"error": "bad path"})
+		return true
+	}
+	method := h.getRequestHeader(":method")
+	status, body := adminApi(method, strings.TrimSuffix(u.Path, "/"), u.Query())
+	proxywasm.LogInfo("admin api: " + method + " " + u.RequestURI())
+	h.adminApiReply(status, body)
+	return true
+}
+
+func adminApi(method, path string, query url.Values) (uint32, interface{}) {
+	ip := query.Get("ip")
+	if method != "GET" && (path == "/bans" || path == "/allow") && net.ParseIP(ip) == nil {
+		return 400, map[string]string{"error": "missing or invalid ip"}
+	}
+
+	var err error
+	switch method + " " + path {
+	case "GET /bans":
+		var bans map[string]time.Time
+		if bans, err = BannedSources(); err != nil {
+			break
+		}
+		out := map[string]string{}
+		for ip, until := range bans {
+			out[ip] = until.UTC().Format(time.RFC3339)
+		}
+		return 200, out
+	case "POST /bans":
+		d := defaultAdminBan
+		if s := query.Get("duration"); s != "" {
+			if d, err = time.ParseDuration(s); err != nil || d <= 0 {
+				return 400, map[string]string{"error": "invalid duration"}
+			}
+		}
+		err = BanSource(ip, d)
+	case "DELETE /bans":
+		err = UnbanSource(ip)
+	case "GET /fingerprints":
+		limit := 20
+		if s := query.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				return 400, map[string]string{"error": "invalid limit"}
+			}
+		}
+		var fps []Fingerprint
+		if fps, err = TopFingerprints(limit); err != nil {
+			break
+		}
+		return 200, fps
+	case "GET /recent":
+		limit := 20
+		if s := query.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				return 400, map[string]string{"error": "invalid limit"}
+			}
+		}
+		var events []Event
+		if events, err = RecentEvents(limit); err != nil {
+			break
+		}
+		return 200, events
+	case "GET /paths":
+		port, perr := strconv.ParseInt(query.Get("port"), 10, 64)
+		if perr != nil {
+			return 400, map[string]string{"error": "missing or invalid port"}
+		}
+		report, ok := LastPathReport(port)
+		if !ok {
+			return 404, map[string]string{"error": "no report yet"}
+		}
+		return 200, report
+	case "GET /allow":
+		var ips []string
+		if ips, err = AllowedSources(); err != nil {
+			break
+		}
+		if ips == nil {
+			ips = []string{}
+		}
+		return 200, ips
+	case "POST /allow":
+		err = AllowSource(ip)
+	case "DELETE /allow":
+		err = DisallowSource(ip)
+	case "GET /maintenance":
+		var ports []int64
+		if ports, err = MaintenancePorts(); err != nil {
+			break
+		}
+		return 200, ports
+	case "POST /maintenance", "DELETE /maintenance":
+		port, perr := strconv.ParseInt(query.Get("port"), 10, 64)
+		if perr != nil {
+			return 400, map[string]string{"error": "missing or invalid port"}
+		}
+		if err = SetMaintenance(port, method == "POST"); err != nil {
+			break
+		}
+		return 200, map[string]int64{"ok": port}
+	case "GET /fuses":
+		var fuses map[string]time.Time
+		if fuses, err = TrippedFuses(); err != nil {
+			break
+		}
+		out := map[string]string{}
+		for name, at := range fuses {
+			out[name] = at.UTC().Format(time.RFC3339)
+		}
+		return 200, out
+	case "DELETE /fuses":
+		name := query.Get("name")
+		if err = ResetFuse(name); err != nil {
+			return 400, map[string]string{"error": err.Error()}
+		}
+		return 200, map[string]string{"ok": name}
+	default:
+		return 404, map[string]string{"error": "not found"}
+	}
+	if err != nil {
+		return 500, map[string]string{"error": err.Error()}
+	}
+	return 200, map[string]string{"ok": ip}
+}
+
+func (h *httpCtx) adminApiReply(status uint32, body interface{}) {
+	b, _ := json.Marshal(body)
+	headers := [][2]string{{"content-type", "application/json"}}
+	reply(status, headers, append(b, '\n'))
+	h.finish(VerdictBlocked)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// VerdictPolicy controls how the verdicts of several interceptors matched on a stream combine
+// under MatchAll. The verdict which ended the stream is counted in interceptor_aggregated_verdicts
+// and traced in x-intercepted-verdict.
+type VerdictPolicy int
+
+const (
+	// A block or drop ends the stream at once; interceptors which modified are done, the others go on.
+	BlockWins VerdictPolicy = iota
+	// The first final verdict, modified included, ends the stream; the remaining Dos don't run.
+	FirstWins
+	// Every Do of the stage runs, so rewrites and logging still happen, then the most severe verdict
+	// applies. Changes made after a Do replied locally are lost.
+	ModifyThenBlock
+)
+
+const verdictTraceHeader = "x-intercepted-verdict"
+
+var verdictPolicies = map[int64]VerdictPolicy{}
+
+// Sets how verdicts combine on a port under MatchAll (BlockWins by default).
+func SetVerdictPolicy(port int64, p VerdictPolicy) {
+	verdictPolicies[port] = p
+}
+
+func (p VerdictPolicy) String() string {
+	switch p {
+	case BlockWins:
+		return "block-wins"
+	case FirstWins:
+		return "first-wins"
+	case ModifyThenBlock:
+		return "modify-then-block"
+	default:
+		return "unknown"
+	}
+}
+
+// Ends the stream with the verdict the policy chose, recording the outcome.
+func (h *httpCtx) finishAggregated(stage HttpStage, p VerdictPolicy, interceptor string, v Verdict) {
+	incrCounter("interceptor_aggregated_verdicts", "policy", p.String(), "verdict", v.String())
+	outcome := fmt.Sprintf("%s by %s (%s)", v, interceptor, p)
+	if stage < StageResponseHeaders {
+		proxywasm.ReplaceHttpRequestHeader(verdictTraceHeader, outcome)
+		h.requestHeaders.set(verdictTraceHeader, outcome)
+	} else {
+		proxywasm.ReplaceHttpResponseHeader(verdictTraceHeader, outcome)
+		h.responseHeaders.set(verdictTraceHeader, outcome)
+	}
+	h.finish(v)
+}
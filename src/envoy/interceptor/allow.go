@@ -0,0 +1,67 @@
+package main
+
+import "github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+
+// Allowed sources (our own hosts, the game's checkers) are never banned and skip all interceptors.
+// The list lives in shared data, so it can change at runtime (see the admin API).
+const allowListKey = "allow-list"
+
+func AllowSource(ip string) error {
+	ip = canonicalIP(ip)
+	if err := rootKV.SetAdd(allowListKey, ip); err != nil {
+		return err
+	}
+	proxywasm.LogInfo("allowed source " + ip)
+	return nil
+}
+
+func DisallowSource(ip string) error {
+	ip = canonicalIP(ip)
+	if err := rootKV.SetRemove(allowListKey, ip); err != nil {
+		return err
+	}
+	proxywasm.LogInfo("disallowed source " + ip)
+	return nil
+}
+
+func AllowedSources() ([]string, error) {
+	return rootKV.SetMembers(allowListKey)
+}
+
+func IsSourceAllowed(ip string) bool {
+	ips, err := AllowedSources()
+	if err != nil {
+		return false
+	}
+	ip = canonicalIP(ip)
+	for _, allowed := range ips {
+		if allowed == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registerStartGuard(guardAllowList, (*httpCtx).allowedSource)
+}
+
+// Lets the stream through untouched if its source is allowed.
+func (h *httpCtx) allowedSource() bool {
+	ip := h.sourceIP()
+	if ip == "" || !IsSourceAllowed(ip) {
+		return false
+	}
+	h.finish(VerdictContinue)
+	return true
+}
+
+// Lets the connection through untouched if its source is allowed.
+func (ctx *tcpCtx) allowedSource() bool {
+	ip := ctx.sourceIP()
+	if ip == "" || !IsSourceAllowed(ip) {
+		return false
+	}
+	ctx.finish(VerdictContinue)
+	return true
+}
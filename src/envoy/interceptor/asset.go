@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path"
+)
+
+// Static files compiled into the plugin: block and maintenance pages, decoys. Add a file to
+// assets/ to serve it by name, e.g. "blocked.html".
+//
+//go:embed assets
+var assets embed.FS
+
+var assetContentTypes = map[string]string{
+	".html": "text/html; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".json": "application/json",
+	".css":  "text/css",
+	".js":   "text/javascript",
+	".svg":  "image/svg+xml",
+	".png":  "image/png",
+	".sql":  "application/sql",
+	".zip":  "application/zip",
+}
+
+// Returns the embedded asset and its content type, by file extension.
+func Asset(name string) ([]byte, string, bool) {
+	body, err := assets.ReadFile(path.Join("assets", name))
+	if err != nil {
+		return nil, "", false
+	}
+	contentType, ok := assetContentTypes[path.Ext(name)]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	return body, contentType, true
+}
+
+func ruleAsset(name string) ([]byte, string) {
+	body, contentType, ok := Asset(name)
+	if !ok {
+		ruleFailed(fmt.Errorf("unknown asset %q", name))
+	}
+	return body, contentType
+}
+
+// Answers with an embedded asset. HoneyflagPlaceholder in it is replaced by a new honeyflag, so
+// decoy files are traceable.
+func DoServeAsset(status uint32, name string) func(*HttpDoContext) Verdict {
+	body, contentType := ruleAsset(name)
+	honeyflagged := bytes.Contains(body, []byte(HoneyflagPlaceholder))
+	headers := [][2]string{{"content-type", contentType}}
+	return func(ctx *HttpDoContext) Verdict {
+		if honeyflagged {
+			return DoServeHoneyflag(status, headers, body)(ctx)
+		}
+		reply(status, headers, body)
+		return VerdictBlocked
+	}
+}
@@ -0,0 +1,13 @@
+-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
+--
+-- Host: localhost    Database: app
+-- ------------------------------------------------------
+
+DROP TABLE IF EXISTS `secrets`;
+CREATE TABLE `secrets` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `value` varchar(255) NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO `secrets` VALUES (1,'{{honeyflag}}');
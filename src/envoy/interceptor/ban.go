@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Source bans live in shared data as "ban/<source key>" -> expiry (unix nanoseconds), so they are
+// shared by all workers and ports. Shared data can't be deleted; expired entries are simply ignored.
+// IPv6 sources are banned by network (see sourceKey).
+var bansKV = NewKV("ban")
+
+// Every IP ever banned, for listing.
+const banIndexKey = "ban-index"
+
+// Bans a source IP (IPv6: its network) for d. An existing longer ban is kept.
+func BanSource(ip string, d time.Duration) error {
+	if ip == "" {
+		return fmt.Errorf("no source ip to ban")
+	}
+	if IsChecker(ip) {
+		proxywasm.LogWarn("not banning checker " + ip)
+		return nil
+	}
+	ip = sourceKey(ip)
+	until := Now().Add(d).UnixNano()
+	v, err := bansKV.Update(ip, func(old []byte) []byte {
+		if decodeInt64(old) > until {
+			return old
+		}
+		return encodeInt64(until)
+	})
+	if err != nil {
+		return err
+	}
+	proxywasm.LogInfo(fmt.Sprintf("banned source %s for %s", ip, d))
+	publishEvent(TopicBans, BanUpdate{Source: ip, Until: time.Unix(0, decodeInt64(v))})
+	return rootKV.SetAdd(banIndexKey, ip)
+}
+
+// Lifts the ban of a source IP.
+func UnbanSource(ip string) error {
+	ip = sourceKey(ip)
+	if _, err := bansKV.Update(ip, func([]byte) []byte { return encodeInt64(0) }); err != nil {
+		return err
+	}
+	proxywasm.LogInfo("unbanned source " + ip)
+	publishEvent(TopicBans, BanUpdate{Source: ip})
+	return rootKV.SetRemove(banIndexKey, ip)
+}
+
+// Currently banned sources (IPs, or networks for IPv6) and their ban expiry.
+func BannedSources() (map[string]time.Time, error) {
+	ips, err := rootKV.SetMembers(banIndexKey)
+	if err != nil {
+		return nil, err
+	}
+	bans := map[string]time.Time{}
+	for _, ip := range ips {
+		if until := SourceBannedUntil(ip); Now().Before(until) {
+			bans[ip] = until
+		}
+	}
+	return bans, nil
+}
+
+// Time until which a source IP is banned (zero if it never was).
+func SourceBannedUntil(ip string) time.Time {
+	until, err := bansKV.GetInt(sourceKey(ip))
+	if err != nil || until == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, until)
+}
+
+// Reports whether a source IP is currently banned.
+func IsSourceBanned(ip string) bool {
+	return Now().Before(SourceBannedUntil(ip))
+}
+
+// Whether streams of banned sources are rejected at their first stage.
+var banEnforcement = true
+
+// Enables or disables rejecting banned sources (bans are still recorded).
+func SetBanEnforcement(enabled bool) {
+	banEnforcement = enabled
+}
+
+// Wraps a Do so the source gets banned for d once it blocks or drops the stream.
+func BanAfterBlock(d time.Duration, do func(*HttpDoContext) Verdict) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		v := do(ctx)
+		if v == VerdictBlocked || v == VerdictDropped {
+			if err := BanSource(ctx.SourceIP, d); err != nil {
+				ctx.LogWarn("failed to ban source: " + err.Error())
+			}
+		}
+		return v
+	}
+}
+
+// Bans the source of the stream for d.
+func (c *HttpDoContext) BanSource(d time.Duration) error {
+	return BanSource(c.SourceIP, d)
+}
+
+// Bans the source of the connection for d.
+func (c *TcpDoContext) BanSource(d time.Duration) error {
+	return BanSource(c.SourceIP, d)
+}
+
+func init() {
+	registerStartGuard(guardBan, (*httpCtx).rejectBanned)
+}
+
+// Rejects the stream with 403 if its source is banned; checked before any interceptor runs.
+func (h *httpCtx) rejectBanned() bool {
+	ip := h.sourceIP()
+	if !banEnforcement || ip == "" || !IsSourceBanned(ip) || IsSourceAllowed(ip) {
+		return false
+	}
+	proxywasm.LogInfo("rejected banned source " + ip)
+	reply(403, nil, []byte("banned"))
+	h.finish(VerdictBlocked)
+	return true
+}
+
+// Closes the connection if its source is banned.
+func (ctx *tcpCtx) rejectBanned() bool {
+	ip := ctx.sourceIP()
+	if !banEnforcement || ip == "" || !IsSourceBanned(ip) || IsSourceAllowed(ip) {
+		return false
+	}
+	proxywasm.LogInfo("rejected banned source " + ip)
+	proxywasm.CloseDownstream()
+	ctx.finish(VerdictDropped)
+	return true
+}
+
+// Downstream IP, "" if unavailable.
+func (ctx *tcpCtx) sourceIP() string {
+	addr, err := ctx.properties.sourceAddress()
+	if err != nil {
+		return ""
+	}
+	return addressIP(addr)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Adds prefix before and suffix after the response body without buffering it: chunks stream
+// through untouched, the prefix goes in front of the first one and the suffix after the last.
+// Content-Length is dropped so the response is sent chunked. Upstream is asked for an unencoded
+// response; encoded responses, and responses ending with trailers, are left alone.
+func DoWrapHttpResponseBody(prefix, suffix []byte) func(*HttpDoContext) Verdict {
+	type state struct {
+		prefixed bool
+	}
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, true)
+		s := State[state](ctx)
+		switch ctx.Stage {
+		case StageRequestHeaders:
+			if ctx.GetRequestHeader("accept-encoding") != "" {
+				ctx.SetRequestHeader("accept-encoding", "identity")
+			}
+			return VerdictContinue
+		case StageRequestBody, StageRequestTrailers:
+			return VerdictContinue
+		case StageResponseHeaders:
+			if encoding := normalizeEncoding(ctx.GetResponseHeader("content-encoding")); encoding != "" {
+				ctx.LogWarn("cannot inject into response with content-encoding " + encoding)
+				return VerdictModified
+			}
+			if ctx.End {
+				// no body (HEAD, 204, 304...)
+				return VerdictModified
+			}
+			return VerdictContinue
+		case StageResponseTrailers:
+			if len(suffix) > 0 {
+				ctx.LogWarn("response ended with trailers, suffix not injected")
+			}
+			return VerdictModified
+		}
+
+		if !s.prefixed && len(prefix) > 0 {
+			if err := proxywasm.PrependHttpResponseBody(prefix); err != nil {
+				ctx.LogWarn("failed to prepend to response body: " + err.Error())
+			}
+		}
+		s.prefixed = true
+		if len(suffix) == 0 {
+			return VerdictModified
+		}
+		if !ctx.End {
+			return VerdictContinue
+		}
+		if err := proxywasm.AppendHttpResponseBody(suffix); err != nil {
+			ctx.LogWarn("failed to append to response body: " + err.Error())
+		}
+		return VerdictModified
+	}
+}
+
+// Streams the response through and appends suffix at its end (see DoWrapHttpResponseBody).
+func DoAppendHttpResponseBody(suffix []byte) func(*HttpDoContext) Verdict {
+	return DoWrapHttpResponseBody(nil, suffix)
+}
+
+// Streams the response through with prefix in front (see DoWrapHttpResponseBody).
+func DoPrependHttpResponseBody(prefix []byte) func(*HttpDoContext) Verdict {
+	return DoWrapHttpResponseBody(prefix, nil)
+}
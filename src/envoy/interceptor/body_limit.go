@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+const (
+	// Log the overflow and let the stream through without further buffering.
+	BodyLimitLog BodyLimitFallback = iota
+	// Silently let the stream through without further buffering.
+	BodyLimitContinue
+	// Reject the stream with 413, or withhold the response with 502 at response stages.
+	BodyLimitBlock
+)
+
+// Global cap on body bytes an interceptor may keep buffered, matching Envoy's default buffer limit.
+var maxBufferedBody = 1 << 20
+
+// Fallback used by interceptors which don't set their own.
+var defaultBodyLimitFallback = BodyLimitLog
+
+// Sets the global body buffering cap and its fallback.
+func SetMaxBufferedBody(size int, fallback BodyLimitFallback) {
+	maxBufferedBody = size
+	defaultBodyLimitFallback = fallback
+}
+
+// Overrides the body buffering cap for a single interceptor.
+func WithMaxBodySize(size int, fallback BodyLimitFallback) HttpInterceptorOption {
+	return func(i *HttpInterceptor) {
+		i.MaxBodySize = size
+		i.BodyLimitFallback = fallback
+	}
+}
+
+func (i *HttpInterceptor) bodyLimit() (int, BodyLimitFallback) {
+	if i.MaxBodySize > 0 {
+		return i.MaxBodySize, i.BodyLimitFallback
+	}
+	return maxBufferedBody, defaultBodyLimitFallback
+}
+
+func bodyLimitExceeded(i *HttpInterceptor, stage HttpStage, n int) bool {
+	if stage != StageRequestBody && stage != StageResponseBody {
+		return false
+	}
+	limit, _ := i.bodyLimit()
+	return limit > 0 && n > limit
+}
+
+// Applies the fallback verdict of an interceptor which exceeded its body limit.
+func (h *httpCtx) applyBodyLimit(i *HttpInterceptor, stage HttpStage, n int) Verdict {
+	limit, fallback := i.bodyLimit()
+	switch fallback {
+	case BodyLimitBlock:
+		proxywasm.LogWarn(fmt.Sprintf("[%s] body limit exceeded stage=%s size=%d limit=%d, blocking", i.Name, stage.String(), n, limit))
+		status, body := uint32(413), "payload too large"
+		if stage == StageResponseBody {
+			// the client's request was fine: the upstream answer is what can't be inspected
+			status, body = 502, "response withheld\n"
+		}
+		reply(status, nil, []byte(body))
+		return VerdictBlocked
+	case BodyLimitContinue:
+		return VerdictContinue
+	default:
+		proxywasm.LogWarn(fmt.Sprintf("[%s] body limit exceeded stage=%s size=%d limit=%d, passing through", i.Name, stage.String(), n, limit))
+		return VerdictContinue
+	}
+}
+
+// Request body caps per port, from SetMaxRequestBody and "max_request_body" in the plugin config
+// (which wins). Unlike the buffering cap, they apply to every request of the port, before any
+// interceptor sees it.
+var requestBodyLimits = map[int64]int{}
+
+// Rejects requests to the port whose body exceeds max bytes with 413.
+func SetMaxRequestBody(port int64, max int) {
+	requestBodyLimits[port] = max
+}
+
+func requestBodyLimit(port int64) int {
+	if max, ok := config.MaxRequestBody[port]; ok {
+		return max
+	}
+	return requestBodyLimits[port]
+}
+
+func init() {
+	registerStageGuard(guardBodySize, (*httpCtx).rejectLargeBody)
+}
+
+// Rejects the request with 413 once its body exceeds the port's cap: at the headers if
+// Content-Length announces it, otherwise as soon as the streamed bytes go over.
+func (h *httpCtx) rejectLargeBody(stage HttpStage, n int, _ bool) bool {
+	if stage != StageRequestHeaders && stage != StageRequestBody || h.finished && h.verdict >= VerdictBlocked {
+		return false
+	}
+	port, err := h.properties.destinationPort()
+	if err != nil {
+		return false
+	}
+	limit := requestBodyLimit(port)
+	if limit <= 0 {
+		return false
+	}
+	size := h.bodyOffset(stage) + n
+	if stage == StageRequestHeaders {
+		size, _ = strconv.Atoi(h.getRequestHeader("content-length"))
+	}
+	if size <= limit {
+		return false
+	}
+	proxywasm.LogWarn(fmt.Sprintf("request body of %d bytes from %s over the limit of %d, rejecting", size, h.sourceIP(), limit))
+	incrCounter("interceptor_bodies_too_large", "port", strconv.FormatInt(port, 10))
+	reply(413, nil, []byte("payload too large"))
+	h.finish(VerdictBlocked)
+	return true
+}
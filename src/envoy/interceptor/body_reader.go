@@ -0,0 +1,42 @@
+package main
+
+// BodyReader yields only the body bytes not read yet, across stage invocations.
+// Keep one per direction in the When/Do Data.
+type BodyReader struct {
+	// stream offset up to which the body has been read
+	read int
+}
+
+// Returns the bytes buffered since the previous call. offset and size are the BodyOffset and BodySize
+// of the context, get is its GetRequestBody or GetResponseBody.
+func (r *BodyReader) Next(offset, size int, get func(start, size int) ([]byte, error)) ([]byte, error) {
+	start := r.read - offset
+	if start < 0 {
+		// bytes between r.read and offset were passed on without being read
+		start = 0
+	}
+	if start >= size {
+		return nil, nil
+	}
+	chunk, err := get(start, size-start)
+	if err != nil {
+		return nil, err
+	}
+	r.read = offset + size
+	return chunk, nil
+}
+
+// Reads the new request body bytes of a When context.
+func (r *BodyReader) NextRequest(ctx *HttpWhenContext) ([]byte, error) {
+	return r.Next(ctx.BodyOffset, ctx.BodySize, ctx.GetRequestBody)
+}
+
+// Reads the new response body bytes of a When context.
+func (r *BodyReader) NextResponse(ctx *HttpWhenContext) ([]byte, error) {
+	return r.Next(ctx.BodyOffset, ctx.BodySize, ctx.GetResponseBody)
+}
+
+// Stream offset of the next byte to be read.
+func (r *BodyReader) Offset() int {
+	return r.read
+}
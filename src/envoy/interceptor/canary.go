@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Canaries are markers embedded in responses, one per team (or per source outside team networks),
+// so leaked content found later (in another team's exploit, a public dump) tells who scraped it.
+
+// CanaryStyle selects where DoEmbedCanary puts the marker, several can be combined.
+type CanaryStyle int
+
+const (
+	// x-trace-id response header
+	CanaryHeader CanaryStyle = 1 << iota
+	// HTML comment before </body> of HTML responses
+	CanaryHtmlComment
+	// One space (0) or tab (1) per bit at the end of the first 64 lines of text responses
+	CanaryWhitespace
+)
+
+const (
+	canaryHeader = "x-trace-id"
+	canaryBits   = 64
+)
+
+// Who a canary was issued to.
+type CanaryIssue struct {
+	Token string `json:"token"`
+	// First source it was issued to
+	Source string    `json:"source"`
+	Team   string    `json:"team,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+var (
+	canaryKV      = NewKV("canary")
+	canaryPattern = regexp.MustCompile(`\b[0-9a-f]{16}\b`)
+)
+
+// Returns the canary of the source's team (or of the source outside team networks), issuing one
+// on first use.
+func canaryFor(ctx *HttpDoContext) (string, error) {
+	team := ctx.SourceTeam()
+	holder := "source/" + ctx.SourceIP
+	if team != "" {
+		holder = "team/" + team
+	}
+	if b, err := canaryKV.Get(holder); err != nil || b != nil {
+		return string(b), err
+	}
+	token := randomString("0123456789abcdef", 16)
+	claimed, err := canaryKV.CompareAndSwap(holder, nil, []byte(token))
+	if err != nil {
+		return "", err
+	}
+	if !claimed {
+		// another worker issued one first
+		b, err := canaryKV.Get(holder)
+		return string(b), err
+	}
+	b, _ := json.Marshal(CanaryIssue{Token: token, Source: ctx.SourceIP, Team: team, Time: Now()})
+	if err := canaryKV.Set("token/"+token, b); err != nil {
+		return "", err
+	}
+	ctx.LogInfo("issued canary " + token + " to " + holder)
+	return token, nil
+}
+
+func lookupCanary(token string) (CanaryIssue, bool) {
+	var issue CanaryIssue
+	b, err := canaryKV.Get("token/" + token)
+	if err != nil || b == nil || json.Unmarshal(b, &issue) != nil {
+		return issue, false
+	}
+	return issue, true
+}
+
+// Embeds the source's canary into responses. Bodies are buffered if a body style is used.
+func DoEmbedCanary(styles CanaryStyle) func(*HttpDoContext) Verdict {
+	type state struct {
+		rc    responseCoding
+		token string
+		html  bool
+		text  bool
+	}
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, true)
+		s := State[state](ctx)
+		s.rc.track(ctx)
+
+		switch {
+		case ctx.Stage < StageResponseHeaders:
+			return VerdictContinue
+		case ctx.Stage == StageResponseHeaders:
+			token, err := canaryFor(ctx)
+			if err != nil {
+				ctx.LogWarn("failed to issue canary: " + err.Error())
+				return VerdictModified
+			}
+			s.token = token
+			if styles&CanaryHeader != 0 {
+				ctx.SetResponseHeader(canaryHeader, token)
+			}
+			contentType := ctx.GetResponseHeader("content-type")
+			s.html = styles&CanaryHtmlComment != 0 && strings.Contains(contentType, "html")
+			s.text = styles&CanaryWhitespace != 0 && strings.HasPrefix(contentType, "text/")
+			if ctx.End || (!s.html && !s.text) {
+				return VerdictModified
+			}
+			return VerdictContinue
+		case ctx.Stage == StageResponseBody && !ctx.End:
+			s.rc.bodySize = ctx.BodySize
+			return VerdictPause
+		}
+
+		bodySize := ctx.BodySize
+		if ctx.Stage == StageResponseTrailers {
+			bodySize = s.rc.bodySize
+		}
+		if bodySize == 0 {
+			return VerdictModified
+		}
+		raw, err := ctx.GetResponseBody(0, bodySize)
+		if err != nil {
+			ctx.LogWarn("failed to read response body: " + err.Error())
+			return VerdictModified
+		}
+		body, ok := s.rc.decode(ctx, raw)
+		if !ok {
+			return s.rc.withhold(ctx)
+		}
+		if s.text {
+			body = embedWhitespaceCanary(body, s.token)
+		}
+		if s.html {
+			body = embedHtmlCanary(body, s.token)
+		}
+		if err := ctx.ReplaceResponseBody(s.rc.encode(ctx, body)); err != nil {
+			ctx.LogWarn("failed to replace response body: " + err.Error())
+		}
+		return VerdictModified
+	}
+}
+
+func embedHtmlCanary(body []byte, token string) []byte {
+	comment := []byte("<!-- " + token + " -->")
+	i := bytes.LastIndex(bytes.ToLower(body), []byte("</body>"))
+	if i < 0 {
+		return append(body, comment...)
+	}
+	return append(body[:i:i], append(comment, body[i:]...)...)
+}
+
+// Appends a space or a tab to each of the first 64 lines, one per bit of the token. Bodies with
+// fewer lines are left alone.
+func embedWhitespaceCanary(body []byte, token string) []byte {
+	bits, err := hex.DecodeString(token)
+	if err != nil || bytes.Count(body, []byte("\n")) < canaryBits {
+		return body
+	}
+	out := make([]byte, 0, len(body)+canaryBits)
+	line := 0
+	for _, c := range body {
+		if c == '\n' && line < canaryBits {
+			if bits[line/8]&(0x80>>(line%8)) != 0 {
+				out = append(out, '\t')
+			} else {
+				out = append(out, ' ')
+			}
+			line++
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func decodeWhitespaceCanary(data []byte) (string, bool) {
+	bits := make([]byte, canaryBits/8)
+	line := 0
+	for i := 1; i < len(data) && line < canaryBits; i++ {
+		if data[i] != '\n' {
+			continue
+		}
+		switch data[i-1] {
+		case '\t':
+			bits[line/8] |= 0x80 >> (line % 8)
+		case ' ':
+		default:
+			return "", false
+		}
+		line++
+	}
+	if line < canaryBits {
+		return "", false
+	}
+	return hex.EncodeToString(bits), true
+}
+
+// Finds the canaries in leaked content (a response, a dump, a header value) and returns who they
+// were issued to.
+func FindCanaries(data []byte) []CanaryIssue {
+	var issues []CanaryIssue
+	seen := map[string]bool{}
+	tokens := canaryPattern.FindAllString(string(data), -1)
+	if token, ok := decodeWhitespaceCanary(data); ok {
+		tokens = append(tokens, token)
+	}
+	for _, token := range tokens {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		if issue, ok := lookupCanary(token); ok {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// Fault injection for practice, "chaos" in the plugin config: a share of the traffic of each listed
+// port is dropped, delayed or corrupted, to rehearse how services and monitoring behave when
+// defenses misfire. Never enable it during a game: checkers are not spared.
+type chaosConfig struct {
+	Enabled bool `json:"enabled"`
+	// Faults by port
+	Ports map[int64]chaosFaults `json:"ports"`
+}
+
+// Percentages of the streams of a port getting each fault (at most one per stream).
+type chaosFaults struct {
+	// HTTP requests answered with 503, TCP connections closed
+	Drop float64 `json:"drop"`
+	// HTTP requests held for DelayMs
+	Delay   float64 `json:"delay"`
+	DelayMs int     `json:"delay_ms"`
+	// HTTP responses with a byte of the first body chunk flipped
+	Corrupt float64 `json:"corrupt"`
+}
+
+type chaosFault int
+
+const (
+	chaosNone chaosFault = iota
+	chaosDrop
+	chaosDelay
+	chaosCorrupt
+)
+
+func (f chaosFaults) delay() time.Duration {
+	if f.DelayMs <= 0 {
+		return time.Second
+	}
+	return time.Duration(f.DelayMs) * time.Millisecond
+}
+
+func (f chaosFaults) roll() chaosFault {
+	r := rand.Float64() * 100
+	switch {
+	case r < f.Drop:
+		return chaosDrop
+	case r < f.Drop+f.Delay:
+		return chaosDelay
+	case r < f.Drop+f.Delay+f.Corrupt:
+		return chaosCorrupt
+	}
+	return chaosNone
+}
+
+func (c chaosConfig) faults(port int64) (chaosFaults, bool) {
+	if !c.Enabled {
+		return chaosFaults{}, false
+	}
+	f, ok := c.Ports[port]
+	return f, ok
+}
+
+// Warns loudly and enables the delays chaos needs. Called with the plugin config.
+func (c chaosConfig) init() {
+	if !c.Enabled {
+		return
+	}
+	EnableDelays()
+	proxywasm.LogWarn(fmt.Sprintf("chaos mode enabled on %d ports: traffic will be dropped, delayed and corrupted", len(c.Ports)))
+}
+
+func init() {
+	registerStartGuard(guardChaos, (*httpCtx).chaosDrop)
+}
+
+// Picks the stream's fault at its first callback and drops it right away if it is to be dropped.
+func (h *httpCtx) chaosDrop() bool {
+	port, err := h.properties.destinationPort()
+	if err != nil {
+		return false
+	}
+	f, ok := config.Chaos.faults(port)
+	if !ok {
+		return false
+	}
+	if h.chaos = f.roll(); h.chaos != chaosDrop {
+		return false
+	}
+	proxywasm.LogInfo("chaos: dropping request")
+	reply(503, nil, []byte("service unavailable"))
+	h.finish(VerdictBlocked)
+	return true
+}
+
+// Applies the stream's delay or corruption once interceptors let the data through.
+func (h *httpCtx) injectChaos(stage HttpStage, n int, action types.Action) types.Action {
+	if h.chaos == chaosNone || action != types.ActionContinue {
+		return action
+	}
+	port, _ := h.properties.destinationPort()
+	switch {
+	case h.chaos == chaosDelay && stage == StageRequestHeaders:
+		h.chaos = chaosNone
+		proxywasm.LogInfo("chaos: delaying request")
+		h.resumeAfter(config.Chaos.Ports[port].delay())
+		return types.ActionPause
+	case h.chaos == chaosCorrupt && stage == StageResponseBody && n > 0:
+		h.chaos = chaosNone
+		body, err := proxywasm.GetHttpResponseBody(0, n)
+		if err != nil || len(body) == 0 {
+			return action
+		}
+		body[rand.Intn(len(body))] ^= 0xff
+		proxywasm.LogInfo("chaos: corrupting response")
+		if err := proxywasm.ReplaceHttpResponseBody(body); err != nil {
+			proxywasm.LogWarn("failed to replace response body: " + err.Error())
+		}
+	}
+	return action
+}
+
+// Closes the connection if it is to be dropped.
+func (t *tcpCtx) chaosDrop() bool {
+	port, err := t.properties.destinationPort()
+	if err != nil {
+		return false
+	}
+	f, ok := config.Chaos.faults(port)
+	if !ok || f.roll() != chaosDrop {
+		return false
+	}
+	proxywasm.LogInfo("chaos: dropping connection")
+	proxywasm.CloseDownstream()
+	t.finish(VerdictDropped)
+	return true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Checker (jury) networks, from SetCheckerRanges and "checker_ranges" in the plugin config.
+// Interceptors still match checker streams, so matches show up in the logs, but their Do never
+// runs and checkers are never banned: a false positive must not cost SLA points.
+var checkerRanges = &NetworkSet{}
+
+// Ranges set by rules, kept to rebuild checkerRanges when the plugin config is loaded again.
+var ruleCheckerRanges []string
+
+// Adds checker ranges, as CIDRs or single IPs.
+func SetCheckerRanges(cidrs ...string) {
+	if err := addCheckerRanges(cidrs...); err != nil {
+		ruleFailed(err)
+	}
+	ruleCheckerRanges = append(ruleCheckerRanges, cidrs...)
+}
+
+// Replaces the ranges of the previous plugin config by cidrs, keeping those set by rules.
+func loadCheckerRanges(cidrs []string) error {
+	checkerRanges = &NetworkSet{}
+	// invalid ones were reported at registration
+	addCheckerRanges(ruleCheckerRanges...)
+	return addCheckerRanges(cidrs...)
+}
+
+func addCheckerRanges(cidrs ...string) error {
+	var errs []error
+	for _, c := range cidrs {
+		if err := checkerRanges.Add(c, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid checker range %q: %w", c, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Reports whether ip belongs to a checker.
+func IsChecker(ip string) bool {
+	return checkerRanges.Contains(ip)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Proxies in front of Envoy, from SetTrustedProxies and "trusted_proxies" in the plugin config.
+// For streams coming from one of them, the client address is taken from Forwarded or
+// X-Forwarded-For: the rightmost address which is not a trusted proxy, as everything left of it
+// is client-controlled. Without trusted proxies these headers are ignored.
+var trustedProxies = &NetworkSet{}
+
+// Proxies set by rules, kept to rebuild trustedProxies when the plugin config is loaded again.
+var ruleTrustedProxies []string
+
+// Adds trusted proxies, as CIDRs or single IPs.
+func SetTrustedProxies(cidrs ...string) {
+	if err := addTrustedProxies(cidrs...); err != nil {
+		ruleFailed(err)
+	}
+	ruleTrustedProxies = append(ruleTrustedProxies, cidrs...)
+}
+
+// Replaces the proxies of the previous plugin config by cidrs, keeping those set by rules.
+func loadTrustedProxies(cidrs []string) error {
+	trustedProxies = &NetworkSet{}
+	// invalid ones were reported at registration
+	addTrustedProxies(ruleTrustedProxies...)
+	return addTrustedProxies(cidrs...)
+}
+
+func addTrustedProxies(cidrs ...string) error {
+	var errs []error
+	for _, c := range cidrs {
+		if err := trustedProxies.Add(c, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid trusted proxy %q: %w", c, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func isTrustedProxy(ip string) bool {
+	return trustedProxies.Contains(ip)
+}
+
+// Client IP of the stream (see trustedProxies), "" if unavailable. Bans, rate limits, teams and
+// all other IP-keyed features use it.
+func (h *httpCtx) sourceIP() string {
+	if !h.clientIPResolved {
+		h.clientIP = h.resolveClientIP()
+		h.clientIPResolved = true
+	}
+	return h.clientIP
+}
+
+func (h *httpCtx) resolveClientIP() string {
+	addr, err := h.properties.sourceAddress()
+	if err != nil {
+		return ""
+	}
+	peer := addressIP(addr)
+	if !isTrustedProxy(peer) {
+		return peer
+	}
+	hops := forwardedFor(h.getRequestHeader("forwarded"))
+	if len(hops) == 0 {
+		hops = strings.Split(h.getRequestHeader("x-forwarded-for"), ",")
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := addressIP(strings.TrimSpace(hops[i]))
+		if strings.HasPrefix(ip, "[") {
+			// bracketed IPv6 without a port
+			ip = canonicalIP(strings.Trim(ip, "[]"))
+		}
+		if net.ParseIP(ip) == nil {
+			// "unknown" or obfuscated: the last known hop is as far as we can see
+			break
+		}
+		client = ip
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// The for= addresses of a Forwarded header (RFC 7239), in order.
+func forwardedFor(header string) []string {
+	var out []string
+	for _, element := range strings.Split(header, ",") {
+		for _, pair := range strings.Split(element, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(k, "for") {
+				out = append(out, strings.Trim(v, `"`))
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+)
+
+// Clock is the time source for all time-dependent helpers (tarpits, rate limiters, TTL bans).
+type Clock interface {
+	Now() time.Time
+}
+
+type hostClock struct{}
+
+func (hostClock) Now() time.Time { return time.Now() }
+
+// Clock used by helpers; replace with SetClock to fake time.
+var clock Clock = hostClock{}
+
+// Replaces the clock used by time-dependent helpers. Passing nil restores the host clock.
+func SetClock(c Clock) {
+	if c == nil {
+		c = hostClock{}
+	}
+	clock = c
+}
+
+// Current time according to the configured clock.
+func Now() time.Time {
+	return clock.Now()
+}
+
+// Time elapsed since t according to the configured clock.
+func Since(t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
+
+// FakeClock is a manually driven clock.
+type FakeClock struct {
+	now time.Time
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time { return c.now }
+
+// Moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// Sets the clock to t.
+func (c *FakeClock) Set(t time.Time) { c.now = t }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Compression for every body codec (matchers, response re-encoding, gRPC messages) goes through
+// here. Compressing uses Envoy's "compress" foreign function (zlib) when the host has it, gzip
+// being the same deflate stream in another wrapper, and the Go compressor otherwise.
+// Decompressing stays in Go: the host's "uncompress" grows its output without bound, so a bomb
+// would exhaust the proxy rather than fail.
+
+// Largest decoded body; bigger ones are rejected as bombs.
+const maxDecodedBody = 16 << 20
+
+var errDecodedTooLarge = errors.New("decoded body too large")
+
+// Whether the host's compress foreign function works, known after the first call.
+var (
+	hostCompressChecked bool
+	hostCompress        bool
+)
+
+// Compresses data in zlib format.
+func zlibCompress(data []byte) ([]byte, error) {
+	if !hostCompressChecked || hostCompress {
+		out, err := proxywasm.CallForeignFunction("compress", data)
+		if !hostCompressChecked {
+			hostCompressChecked, hostCompress = true, err == nil
+			if err != nil {
+				proxywasm.LogInfo("host compression unavailable, compressing in wasm: " + err.Error())
+			}
+		}
+		if err == nil {
+			return out, nil
+		}
+	}
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Compresses data in gzip format, rewrapping the zlib stream: zlib has a 2 byte header and an
+// Adler-32 trailer, gzip a 10 byte header and a CRC-32 and size trailer.
+func gzipCompress(data []byte) ([]byte, error) {
+	z, err := zlibCompress(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(z) < 6 || z[1]&0x20 != 0 {
+		return nil, errors.New("unexpected zlib stream")
+	}
+	deflated := z[2 : len(z)-4]
+	out := make([]byte, 0, 10+len(deflated)+8)
+	// magic, deflate, no flags, no mtime, no extra flags, unknown OS
+	out = append(out, 0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff)
+	out = append(out, deflated...)
+	out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(data))
+	return binary.LittleEndian.AppendUint32(out, uint32(len(data))), nil
+}
+
+// Reads a decompressor to the end, up to maxDecodedBody.
+func readDecoded(r io.Reader) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(r, maxDecodedBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxDecodedBody {
+		return nil, errDecodedTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+var streamsKV = NewKV("streams")
+
+// Counts in-flight streams of a source on a port; returns the key counted in, "" if the source is
+// unknown.
+func countStream(port int64, ip string) (string, int64) {
+	if ip == "" {
+		return "", 0
+	}
+	key := strconv.FormatInt(port, 10) + "/" + sourceKey(ip)
+	n, err := streamsKV.Increment(key, 1)
+	if err != nil {
+		return "", 0
+	}
+	return key, n
+}
+
+// Rejects new requests on a port with 429 while the source already has max streams in flight.
+// Counts live in shared data so the cap holds across workers; a stream is released when done.
+func RegisterConcurrencyLimit(port int64, max int) {
+	RegisterHttpInterceptor(port, "concurrency limit", func(ctx *HttpWhenContext) bool {
+		key, n := countStream(port, ctx.SourceIP)
+		if key == "" {
+			return false
+		}
+		ctx.Data = key
+		if n > int64(max) {
+			ctx.LogInfo(fmt.Sprintf("source %s has %d streams in flight", ctx.SourceIP, n))
+			return true
+		}
+		return false
+	}, DoRateLimited, WithStages(StageRequestHeaders), WithDone(func(ctx *HttpDoneContext) {
+		if key, ok := ctx.WhenData.(string); ok {
+			if _, err := streamsKV.Increment(key, -1); err != nil {
+				ctx.LogInfo("failed to release stream: " + err.Error())
+			}
+		}
+	}))
+}
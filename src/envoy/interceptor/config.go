@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Plugin configuration, JSON in the filter's plugin config.
+type pluginConfig struct {
+	// Secrets (see secretsConfig)
+	Secrets secretsConfig `json:"secrets"`
+	// Deprecated: secrets.admin_hmac_key
+	AdminHmacKey string `json:"admin_hmac_key"`
+	// Per-key limits by rate limit name, overriding RateLimit.Limit
+	RateLimits map[string]map[string]int `json:"rate_limits"`
+	// Port whose requests are served by the admin API (disabled if unset, or without secrets.admin_api_secret)
+	AdminApiPort int64 `json:"admin_api_port"`
+	// Deprecated: secrets.admin_api_secret
+	AdminApiSecret string `json:"admin_api_secret"`
+	// Checker networks exempt from interceptor actions (CIDRs or IPs)
+	CheckerRanges []string `json:"checker_ranges"`
+	// Game server flag ID feed (see flagFeedConfig)
+	FlagIds flagFeedConfig `json:"flag_ids"`
+	// Block notifications (see webhookConfig)
+	Webhook webhookConfig `json:"webhook"`
+	// Extra leak scanner patterns, name to regexp
+	LeakPatterns map[string]string `json:"leak_patterns"`
+	// Shared queue pub/sub (see pubSubConfig)
+	PubSub pubSubConfig `json:"pubsub"`
+	// Prometheus metrics endpoint (see metricsConfig)
+	Metrics metricsConfig `json:"metrics"`
+	// Hot path reports (see pathTopConfig)
+	PathTop pathTopConfig `json:"path_top"`
+	// Known exploit blocking (see exploitDedupConfig)
+	ExploitDedup exploitDedupConfig `json:"exploit_dedup"`
+	// Maintenance page (see maintenanceConfig)
+	Maintenance maintenanceConfig `json:"maintenance"`
+	// Hex SHA-256 hashes of request bodies to reject (see BlockPayloadHashes)
+	PayloadBlocklist []string `json:"payload_blocklist"`
+	// Fault injection for practice (see chaosConfig)
+	Chaos chaosConfig `json:"chaos"`
+	// Per-stream flow records (see flowRecordConfig)
+	FlowRecords flowRecordConfig `json:"flow_records"`
+	// Team networks (see teamsConfig)
+	Teams teamsConfig `json:"teams"`
+	// Prefix length IPv6 sources are banned and limited by (default 64, 128 for single addresses)
+	IPv6Prefix int `json:"ipv6_prefix"`
+	// Proxies whose forwarded client address is trusted (see trustedProxies)
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Request body caps in bytes by port (see SetMaxRequestBody)
+	MaxRequestBody map[int64]int `json:"max_request_body"`
+	// Events kept for GET /recent (default 100, negative disables)
+	RecentEvents int `json:"recent_events"`
+}
+
+var config pluginConfig
+
+func loadPluginConfig() error {
+	data, err := proxywasm.GetPluginConfiguration()
+	if err != nil || len(data) == 0 {
+		// no configuration is fine
+		return nil
+	}
+	var c pluginConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	c.migrateSecrets()
+	c.Chaos.init()
+	config = c
+	// every problem is reported, not just the first
+	return errors.Join(
+		c.compileLeakPatterns(),
+		c.checkReferences(),
+		loadCheckerRanges(c.CheckerRanges),
+		loadTrustedProxies(c.TrustedProxies),
+		c.Teams.load(),
+	)
+}
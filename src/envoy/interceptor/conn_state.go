@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// ConnState is state of a downstream connection, shared by all HTTP streams of a keep-alive
+// client. It lives in a bounded shared data table keyed by the connection id, which Envoy never
+// reuses: values of long-closed connections are overwritten by new ones.
+type ConnState struct {
+	properties *propertyCache
+}
+
+var connKV = NewBoundedKV("conn", 65536)
+
+func (s *ConnState) key(name string) (string, bool) {
+	id, err := s.properties.connectionID()
+	if err != nil {
+		proxywasm.LogWarn("connection state unavailable: " + err.Error())
+		return "", false
+	}
+	return fmt.Sprintf("%d/%s", id, name), true
+}
+
+// Returns the value stored for the connection, or nil.
+func (s *ConnState) Get(name string) []byte {
+	key, ok := s.key(name)
+	if !ok {
+		return nil
+	}
+	v, err := connKV.Get(key)
+	if err != nil {
+		proxywasm.LogWarn(fmt.Sprintf("failed to get connection state %s: %v", name, err))
+	}
+	return v
+}
+
+// Stores a value for the connection.
+func (s *ConnState) Set(name string, value []byte) error {
+	key, ok := s.key(name)
+	if !ok {
+		return fmt.Errorf("connection state unavailable")
+	}
+	return connKV.Set(key, value)
+}
+
+// Atomically adds delta to a counter of the connection and returns the new value.
+func (s *ConnState) Incr(name string, delta int64) int64 {
+	key, ok := s.key(name)
+	if !ok {
+		return 0
+	}
+	v, err := connKV.Increment(key, delta)
+	if err != nil {
+		proxywasm.LogWarn(fmt.Sprintf("failed to increment connection state %s: %v", name, err))
+	}
+	return v
+}
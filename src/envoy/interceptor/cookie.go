@@ -0,0 +1,180 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// CookiePolicy rewrites the Set-Cookie headers of upstream responses, for services whose session
+// cookies lack the usual protections.
+type CookiePolicy struct {
+	// Cookies the policy applies to (all if empty)
+	Names []string
+	// Adds Secure, on TLS streams only: plaintext clients (and checkers) would stop sending the cookie
+	Secure   bool
+	HttpOnly bool
+	// "Strict", "Lax" or "None" (kept as sent if empty)
+	SameSite string
+	// Path and Domain set on the cookies (kept if empty); Domain "-" removes it
+	Path   string
+	Domain string
+	// Cookie renames, upstream name to client name (e.g. "session" to "__Host-session"). Request
+	// cookies are renamed back, and cookies sent under the upstream name are dropped.
+	Rename map[string]string
+}
+
+// Rewrites cookies by the policy: Set-Cookie at the response headers, and Cookie at the request
+// headers if cookies are renamed.
+func DoRewriteCookies(p CookiePolicy) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		switch ctx.Stage {
+		case StageRequestHeaders:
+			if len(p.Rename) > 0 {
+				p.restoreRequestCookies(ctx)
+			}
+			return VerdictContinue
+		case StageResponseHeaders:
+			p.rewriteSetCookies(ctx)
+			return VerdictModified
+		default:
+			return VerdictContinue
+		}
+	}
+}
+
+// Rewrites the cookies of a port's responses. With renames it has to see requests too, so it
+// matches every request: under MatchFirst it then shadows the port's other interceptors.
+func RegisterCookieRewriting(port int64, p CookiePolicy) {
+	stage := StageResponseHeaders
+	if len(p.Rename) > 0 {
+		stage = StageRequestHeaders
+	}
+	RegisterHttpInterceptor(port, "cookie rewriting", func(*HttpWhenContext) bool {
+		return true
+	}, DoRewriteCookies(p), WithStages(stage), WithoutFuse())
+}
+
+func (p CookiePolicy) applies(name string) bool {
+	return len(p.Names) == 0 || containsString(p.Names, name)
+}
+
+func (p CookiePolicy) rewriteSetCookies(ctx *HttpDoContext) {
+	headers, err := proxywasm.GetHttpResponseHeaders()
+	if err != nil {
+		ctx.LogWarn("failed to get response headers: " + err.Error())
+		return
+	}
+	var cookies []string
+	for _, h := range headers {
+		if strings.EqualFold(h[0], "set-cookie") {
+			cookies = append(cookies, p.rewrite(h[1], ctx.TLS != nil))
+		}
+	}
+	if len(cookies) == 0 {
+		return
+	}
+	// replacing drops every set-cookie, the others are added back
+	ctx.SetResponseHeader("set-cookie", cookies[0])
+	for _, c := range cookies[1:] {
+		if err := proxywasm.AddHttpResponseHeader("set-cookie", c); err != nil {
+			ctx.LogWarn("failed to add set-cookie: " + err.Error())
+		}
+	}
+}
+
+// Rewrites a Set-Cookie value.
+func (p CookiePolicy) rewrite(cookie string, tls bool) string {
+	parts := strings.Split(cookie, ";")
+	name, value, _ := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !p.applies(name) {
+		return cookie
+	}
+	if renamed, ok := p.Rename[name]; ok {
+		name = renamed
+	}
+
+	secure := p.Secure && tls
+	out := []string{name + "=" + value}
+	for _, attr := range parts[1:] {
+		attr = strings.TrimSpace(attr)
+		key, _, _ := strings.Cut(attr, "=")
+		switch strings.ToLower(key) {
+		case "secure":
+			if secure {
+				continue
+			}
+		case "httponly":
+			if p.HttpOnly {
+				continue
+			}
+		case "samesite":
+			if p.SameSite != "" {
+				continue
+			}
+		case "path":
+			if p.Path != "" {
+				continue
+			}
+		case "domain":
+			if p.Domain != "" {
+				continue
+			}
+		case "":
+			continue
+		}
+		out = append(out, attr)
+	}
+	if p.Path != "" {
+		out = append(out, "Path="+p.Path)
+	}
+	if p.Domain != "" && p.Domain != "-" {
+		out = append(out, "Domain="+p.Domain)
+	}
+	if secure {
+		out = append(out, "Secure")
+	}
+	if p.HttpOnly {
+		out = append(out, "HttpOnly")
+	}
+	if p.SameSite != "" {
+		out = append(out, "SameSite="+p.SameSite)
+	}
+	return strings.Join(out, "; ")
+}
+
+// Renames request cookies back to their upstream names and drops cookies sent under an upstream
+// name, which only a client bypassing the rename would send.
+func (p CookiePolicy) restoreRequestCookies(ctx *HttpDoContext) {
+	header := ctx.GetRequestHeader("cookie")
+	if header == "" {
+		return
+	}
+	upstream := map[string]string{}
+	for from, to := range p.Rename {
+		upstream[to] = from
+	}
+	var out []string
+	changed := false
+	for _, c := range strings.Split(header, ";") {
+		c = strings.TrimSpace(c)
+		name, value, _ := strings.Cut(c, "=")
+		if _, ok := p.Rename[name]; ok {
+			changed = true
+			continue
+		}
+		if from, ok := upstream[name]; ok {
+			c, changed = from+"="+value, true
+		}
+		out = append(out, c)
+	}
+	if !changed {
+		return
+	}
+	if len(out) == 0 {
+		ctx.DelRequestHeader("cookie")
+		return
+	}
+	ctx.SetRequestHeader("cookie", strings.Join(out, "; "))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CorsPolicy is the CORS policy enforced for a port regardless of what the service sends.
+type CorsPolicy struct {
+	// Allowed origins ("scheme://host[:port]"); "*" allows any origin without credentials
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// Allows credentials for origins listed by name (never for those only allowed by "*")
+	AllowCredentials bool
+	// Preflight cache lifetime (not sent if 0)
+	MaxAge time.Duration
+}
+
+func (p CorsPolicy) allowsOrigin(origin string) bool {
+	return p.wildcard() || p.listsOrigin(origin)
+}
+
+func (p CorsPolicy) listsOrigin(origin string) bool {
+	for _, o := range p.AllowedOrigins {
+		if o != "*" && strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p CorsPolicy) wildcard() bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether credentials are allowed for an allowed origin: only origins listed by name get them.
+func (p CorsPolicy) allowsCredentials(origin string) bool {
+	return p.AllowCredentials && p.listsOrigin(origin)
+}
+
+// Access-Control-Allow-Origin value for an allowed origin.
+func (p CorsPolicy) allowOriginValue(origin string) string {
+	if p.wildcard() && !p.allowsCredentials(origin) {
+		return "*"
+	}
+	return origin
+}
+
+// Registers the policy on a port: preflights are answered by the proxy, and CORS headers of
+// responses are rewritten to the policy (or stripped for origins it doesn't allow).
+func RegisterCorsPolicy(port int64, policy CorsPolicy) {
+	RegisterHttpInterceptor(port, "cors preflight", func(ctx *HttpWhenContext) bool {
+		return ctx.GetRequestHeader(":method") == "OPTIONS" &&
+			ctx.GetRequestHeader("origin") != "" &&
+			ctx.GetRequestHeader("access-control-request-method") != ""
+	}, DoCorsPreflight(policy), WithStages(StageRequestHeaders))
+
+	RegisterHttpInterceptor(port, "cors response", func(ctx *HttpWhenContext) bool {
+		return ctx.GetResponseHeader("access-control-allow-origin") != "" ||
+			ctx.GetResponseHeader("access-control-allow-credentials") != "" ||
+			ctx.GetRequestHeader("origin") != ""
+	}, DoCorsResponse(policy), WithStages(StageResponseHeaders), WithoutFuse())
+}
+
+// Answers a preflight request according to the policy: 204 with the allowed methods and headers, 403 otherwise.
+func DoCorsPreflight(policy CorsPolicy) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		origin := ctx.GetRequestHeader("origin")
+		if !policy.allowsOrigin(origin) {
+			ctx.LogInfo("preflight from disallowed origin " + origin)
+			reply(403, [][2]string{{"vary", "origin"}}, nil)
+			return VerdictBlocked
+		}
+		headers := [][2]string{
+			{"access-control-allow-origin", policy.allowOriginValue(origin)},
+			{"vary", "origin"},
+		}
+		if len(policy.AllowedMethods) > 0 {
+			headers = append(headers, [2]string{"access-control-allow-methods", strings.Join(policy.AllowedMethods, ", ")})
+		}
+		if len(policy.AllowedHeaders) > 0 {
+			headers = append(headers, [2]string{"access-control-allow-headers", strings.Join(policy.AllowedHeaders, ", ")})
+		}
+		if policy.allowsCredentials(origin) {
+			headers = append(headers, [2]string{"access-control-allow-credentials", "true"})
+		}
+		if policy.MaxAge > 0 {
+			headers = append(headers, [2]string{"access-control-max-age", strconv.Itoa(int(policy.MaxAge.Seconds()))})
+		}
+		reply(204, headers, nil)
+		return VerdictBlocked
+	}
+}
+
+// Rewrites the CORS headers of a response to the policy.
+func DoCorsResponse(policy CorsPolicy) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		if ctx.Stage != StageResponseHeaders {
+			return VerdictContinue
+		}
+		origin := ctx.GetRequestHeader("origin")
+		if origin == "" || !policy.allowsOrigin(origin) {
+			if ctx.GetResponseHeader("access-control-allow-origin") != "" {
+				ctx.LogInfo("stripped access-control-allow-origin for origin " + origin)
+			}
+			ctx.DelResponseHeader("access-control-allow-origin")
+			ctx.DelResponseHeader("access-control-allow-credentials")
+			return VerdictModified
+		}
+		ctx.SetResponseHeader("access-control-allow-origin", policy.allowOriginValue(origin))
+		if policy.allowsCredentials(origin) {
+			ctx.SetResponseHeader("access-control-allow-credentials", "true")
+		} else {
+			ctx.DelResponseHeader("access-control-allow-credentials")
+		}
+		if vary := ctx.GetResponseHeader("vary"); vary == "" {
+			ctx.SetResponseHeader("vary", "origin")
+		} else if !strings.Contains(strings.ToLower(vary), "origin") {
+			ctx.SetResponseHeader("vary", vary+", origin")
+		}
+		return VerdictModified
+	}
+}
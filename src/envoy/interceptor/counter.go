@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// SharedCounter is an int64 counter in shared data, counted across every stream and worker of the
+// VM. Updates retry on contention, so concurrent streams never lose counts. Values are synced to
+// Envoy gauges ("interceptor_counter.<name>") and listed by the metrics endpoint.
+type SharedCounter struct {
+	name string
+}
+
+const (
+	counterIndexKey   = "counter-index"
+	counterSyncPeriod = 5 * time.Second
+)
+
+var (
+	counterKV = NewKV("counter")
+	// Names of the counters of this worker, for the index
+	knownCounters = map[string]bool{}
+	counterGauges = map[string]proxywasm.MetricGauge{}
+)
+
+// Returns the counter named name. Best called at registration time (e.g. for a package-level
+// variable), so the counter is synced to Envoy from the start.
+func Counter(name string) SharedCounter {
+	knownCounters[name] = false
+	return SharedCounter{name: name}
+}
+
+// Adds delta and returns the new value (0 if shared data failed, which is logged).
+func (c SharedCounter) Add(delta int64) int64 {
+	v, err := counterKV.Increment(c.name, delta)
+	if err != nil {
+		proxywasm.LogWarn("counter " + c.name + ": " + err.Error())
+		return 0
+	}
+	if !knownCounters[c.name] {
+		if err := rootKV.SetAdd(counterIndexKey, c.name); err == nil {
+			knownCounters[c.name] = true
+		}
+	}
+	return v
+}
+
+func (c SharedCounter) Inc() int64 {
+	return c.Add(1)
+}
+
+func (c SharedCounter) Get() int64 {
+	v, err := counterKV.GetInt(c.name)
+	if err != nil {
+		proxywasm.LogWarn("counter " + c.name + ": " + err.Error())
+	}
+	return v
+}
+
+// Resets the counter to 0.
+func (c SharedCounter) Reset() {
+	if err := counterKV.Set(c.name, encodeInt64(0)); err != nil {
+		proxywasm.LogWarn("counter " + c.name + ": " + err.Error())
+	}
+}
+
+func counterTickPeriod() time.Duration {
+	if len(knownCounters) == 0 {
+		return 0
+	}
+	return counterSyncPeriod
+}
+
+// Called from the plugin tick: brings every counter's Envoy gauge to its value.
+func syncCounters() {
+	names, err := rootKV.SetMembers(counterIndexKey)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		g, ok := counterGauges[name]
+		if !ok {
+			g = proxywasm.DefineGaugeMetric("interceptor_counter." + strings.ReplaceAll(name, ".", "_"))
+			counterGauges[name] = g
+		}
+		// gauges only take deltas; a race between workers is corrected by the next sync
+		g.Add(SharedCounter{name: name}.Get() - g.Value())
+	}
+}
+
+// Prometheus text exposition of the counters, as one gauge labelled by name.
+func counterExposition(b *strings.Builder) error {
+	names, err := rootKV.SetMembers(counterIndexKey)
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	sort.Strings(names)
+	b.WriteString("# TYPE interceptor_counter gauge\n")
+	for _, name := range names {
+		v, err := counterKV.GetInt(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "interceptor_counter{name=\"%s\"} %d\n", escapeLabelValue(name), v)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Granularity of delayed resumes.
+const delayTickPeriod = 50 * time.Millisecond
+
+// Set once an interceptor may delay streams, enabling the fast tick.
+var delaysEnabled bool
+
+// Allows Do functions to use ResumeAfter. Must be called at registration time.
+func EnableDelays() {
+	delaysEnabled = true
+}
+
+type delayedResume struct {
+	h  *httpCtx
+	at time.Time
+}
+
+// Streams to resume once their delay elapsed, context id -> resume
+var delayedStreams = map[uint32]delayedResume{}
+
+func (h *httpCtx) resumeAfter(d time.Duration) {
+	if !delaysEnabled {
+		proxywasm.LogWarn("ResumeAfter used without EnableDelays, the stream will only be resumed by the pause budget")
+	}
+	delayedStreams[h.contextID] = delayedResume{h: h, at: Now().Add(d)}
+}
+
+// Called from the plugin tick: resumes streams whose delay elapsed.
+func checkDelayedStreams() {
+	now := Now()
+	for id, d := range delayedStreams {
+		if now.Before(d.at) {
+			continue
+		}
+		delete(delayedStreams, id)
+		if err := proxywasm.SetEffectiveContext(id); err != nil {
+			continue
+		}
+		d.h.clearPause()
+		if err := d.h.resumeStream(); err != nil {
+			proxywasm.LogWarn("failed to resume delayed stream: " + err.Error())
+		}
+	}
+}
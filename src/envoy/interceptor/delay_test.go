@@ -0,0 +1,49 @@
+//go:build !wasip1
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/proxytest"
+)
+
+func TestCheckDelayedStreams(t *testing.T) {
+	host, reset := proxytest.NewHostEmulator(proxytest.NewEmulatorOption().WithVMContext(&vmContext{}))
+	defer reset()
+	clk := NewFakeClock(time.Unix(1700000000, 0))
+	SetClock(clk)
+	defer SetClock(nil)
+
+	soon := &httpCtx{contextID: host.InitializeHttpContext(), lastStage: StageRequestBody, lastSize: 10}
+	later := &httpCtx{contextID: host.InitializeHttpContext(), lastStage: StageResponseBody, lastSize: 20}
+	delayedStreams[soon.contextID] = delayedResume{h: soon, at: Now().Add(time.Second)}
+	delayedStreams[later.contextID] = delayedResume{h: later, at: Now().Add(3 * time.Second)}
+	defer clear(delayedStreams)
+
+	steps := []struct {
+		advance           time.Duration
+		soonDue, laterDue bool
+	}{
+		{advance: 0},
+		{advance: 999 * time.Millisecond},
+		{advance: time.Millisecond, soonDue: true},
+		{advance: time.Second, soonDue: true},
+		{advance: time.Second, soonDue: true, laterDue: true},
+	}
+	for i, step := range steps {
+		clk.Advance(step.advance)
+		checkDelayedStreams()
+		if _, waiting := delayedStreams[soon.contextID]; waiting == step.soonDue {
+			t.Errorf("step %d: first stream waiting = %v, want %v", i, waiting, !step.soonDue)
+		}
+		if _, waiting := delayedStreams[later.contextID]; waiting == step.laterDue {
+			t.Errorf("step %d: second stream waiting = %v, want %v", i, waiting, !step.laterDue)
+		}
+	}
+	// resumed exactly once, passing the paused chunk on
+	if soon.requestBodyOffset != 10 || later.responseBodyOffset != 20 {
+		t.Errorf("body offsets = %d, %d, want 10, 20", soon.requestBodyOffset, later.responseBodyOffset)
+	}
+}
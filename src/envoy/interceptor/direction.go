@@ -0,0 +1,62 @@
+package main
+
+// Direction of the traffic a listener handles, from the listener's traffic_direction.
+type Direction int
+
+const (
+	// Traffic towards the services (the default, also for listeners without a direction)
+	Inbound Direction = iota
+	// Traffic leaving the services
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "outbound"
+	}
+	return "inbound"
+}
+
+// Registry key: interceptors attach to a destination port in one direction.
+type regKey struct {
+	dir  Direction
+	port int64
+}
+
+// Attaches an HTTP interceptor to outbound listeners instead, port then being the port the
+// service connects to.
+func WithDirection(dir Direction) HttpInterceptorOption {
+	return func(i *HttpInterceptor) {
+		i.Direction = dir
+	}
+}
+
+// Like WithDirection, for TCP interceptors.
+func WithTcpDirection(dir Direction) TcpInterceptorOption {
+	return func(i *TcpInterceptor) {
+		i.Direction = dir
+	}
+}
+
+// Envoy's listener_direction is 1 for INBOUND and 2 for OUTBOUND.
+func (c *propertyCache) direction() Direction {
+	if v, err := c.getInt(PropListenerDirection); err == nil && v == 2 {
+		return Outbound
+	}
+	return Inbound
+}
+
+// Logs and drops egress connections to port, e.g. a reverse shell or flag exfiltration channel.
+func RegisterEgressBlock(port int64) {
+	RegisterHttpInterceptor(port, "egress block", func(ctx *HttpWhenContext) bool {
+		return true
+	}, func(ctx *HttpDoContext) Verdict {
+		ctx.LogWarn("blocked egress request to " + ctx.GetRequestHeader(":authority") + ctx.GetRequestHeader(":path"))
+		reply(403, nil, []byte("egress blocked"))
+		return VerdictBlocked
+	}, WithDirection(Outbound), WithStages(StageRequestHeaders))
+	RegisterTcpInterceptor(port, "egress block", func(ctx *TcpWhenContext) bool {
+		ctx.LogInfo("blocked egress connection from " + ctx.SourceIP)
+		return true
+	}, DoTcpBlock, WithTcpDirection(Outbound))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+)
+
+var errUnsupportedEncoding = errors.New("unsupported content-encoding")
+
+// Content codings the interceptor can decode and re-encode (brotli is not in the stdlib).
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// Normalizes a Content-Encoding value; "" means identity.
+func normalizeEncoding(encoding string) string {
+	e := strings.ToLower(strings.TrimSpace(encoding))
+	switch e {
+	case "", "identity":
+		return ""
+	case "x-gzip":
+		return "gzip"
+	default:
+		return e
+	}
+}
+
+func isSupportedEncoding(encoding string) bool {
+	e := normalizeEncoding(encoding)
+	if e == "" {
+		return true
+	}
+	for _, s := range supportedEncodings {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Decodes a body according to its Content-Encoding.
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	var r io.Reader
+	var err error
+	switch normalizeEncoding(encoding) {
+	case "":
+		return body, nil
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// "deflate" is zlib-wrapped per RFC 9110, but raw deflate is common in the wild
+		r, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			r, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return nil, errUnsupportedEncoding
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readDecoded(r)
+}
+
+// Encodes a body with the given Content-Encoding.
+func encodeBody(encoding string, body []byte) ([]byte, error) {
+	switch normalizeEncoding(encoding) {
+	case "":
+		return body, nil
+	case "gzip":
+		return gzipCompress(body)
+	case "deflate":
+		return zlibCompress(body)
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
+
+// Reports whether an Accept-Encoding header value allows the given coding.
+func acceptsEncoding(acceptEncoding, encoding string) bool {
+	encoding = normalizeEncoding(encoding)
+	if encoding == "" {
+		return true
+	}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = normalizeEncoding(name)
+		if name != encoding && name != "*" {
+			continue
+		}
+		q := strings.ReplaceAll(strings.TrimSpace(params), " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// Drops codings the interceptor cannot decode from an Accept-Encoding header value.
+func filterAcceptEncoding(acceptEncoding string) string {
+	var kept []string
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, _, _ := strings.Cut(part, ";")
+		name = normalizeEncoding(name)
+		if name != "" && name != "*" && isSupportedEncoding(name) {
+			kept = append(kept, strings.TrimSpace(part))
+		}
+	}
+	return strings.Join(kept, ", ")
+}
@@ -10,6 +10,7 @@ import (
 func main() {}
 
 // For some reason TCP requires vm context registration, instead of just tcp context.
+// HTTP uses it too, for ticks.
 type vmContext struct {
 	types.DefaultVMContext
 }
@@ -23,21 +24,44 @@ func (*vmContext) NewPluginContext(contextID uint32) types.PluginContext {
 }
 
 func (ctx *pluginContext) NewTcpContext(contextID uint32) types.TcpContext {
-	return &tcpCtx{skip: undefinedAction}
+	return &tcpCtx{contextID: contextID}
+}
+
+func (ctx *pluginContext) NewHttpContext(contextID uint32) types.HttpContext {
+	return &httpCtx{contextID: contextID}
+}
+
+func (ctx *pluginContext) OnPluginStart(int) types.OnPluginStartStatus {
+	if err := validatePlugin(); err != nil {
+		proxywasm.LogCritical("plugin not started:\n" + err.Error())
+		return types.OnPluginStartStatusFailed
+	}
+	if err := registerSubscriptions(); err != nil {
+		proxywasm.LogCritical(err.Error())
+		return types.OnPluginStartStatusFailed
+	}
+	if err := loadPayloadBlocklist(); err != nil {
+		proxywasm.LogCritical("failed to load payload blocklist: " + err.Error())
+		return types.OnPluginStartStatusFailed
+	}
+	registerBuiltinTickers()
+	startTickers()
+	return types.OnPluginStartStatusOK
 }
 
 func init() {
+	if !hosted {
+		return
+	}
 	switch {
 	case os.Getenv("CTF_PROXY_IS_TCP") != "":
 		registerTcpInterceptors()
 		proxywasm.SetVMContext(&vmContext{})
-		proxywasm.LogInfo("initialized WASM interceptor (tcp)")
+		proxywasm.LogInfo("initialized WASM interceptor (tcp, " + toolchain + ")")
 	case os.Getenv("CTF_PROXY_IS_HTTP") != "":
 		registerHttpInterceptors()
-		proxywasm.SetHttpContext(func(contextID uint32) types.HttpContext {
-			return &httpCtx{skip: undefinedAction}
-		})
-		proxywasm.LogInfo("initialized WASM interceptor (http)")
+		proxywasm.SetVMContext(&vmContext{})
+		proxywasm.LogInfo("initialized WASM interceptor (http, " + toolchain + ")")
 	default:
 		panic("interceptor mode not set: specify CTF_PROXY_IS_HTTP or CTF_PROXY_IS_TCP in vm_config environment_variables")
 	}
@@ -0,0 +1,41 @@
+package main
+
+import "strconv"
+
+var defaultErrorBody = []byte("internal server error\n")
+
+// Replaces the body of every 5xx response with body (a neutral message if nil), hiding stack traces,
+// framework error pages and debug output. The status code is kept.
+func DoNormalizeError(body []byte) func(*HttpDoContext) Verdict {
+	if body == nil {
+		body = defaultErrorBody
+	}
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, true)
+		switch {
+		case ctx.Stage == StageResponseHeaders:
+			ctx.SetResponseHeader("content-type", "text/plain; charset=utf-8")
+			ctx.DelResponseHeader("content-encoding")
+			if ctx.End {
+				return VerdictModified
+			}
+			return VerdictContinue
+		case ctx.Stage == StageResponseBody && !ctx.End:
+			return VerdictPause
+		}
+		if err := ctx.ReplaceResponseBody(body); err != nil {
+			ctx.LogWarn("failed to replace response body: " + err.Error())
+		}
+		return VerdictModified
+	}
+}
+
+func isServerError(ctx *HttpWhenContext) bool {
+	status, err := strconv.Atoi(ctx.GetResponseHeader(":status"))
+	return err == nil && status >= 500 && status <= 599
+}
+
+// Normalizes upstream 5xx responses on a port, see DoNormalizeError.
+func RegisterErrorNormalization(port int64, body []byte) {
+	RegisterHttpInterceptor(port, "error normalization", isServerError, DoNormalizeError(body), WithStages(StageResponseHeaders))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Event is something an interceptor did (match, block, fuse...). Every event is published to
+// TopicEvents, kept among the recent events and counted; the webhook is one of its consumers.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	Interceptor string    `json:"interceptor"`
+	Port        int64     `json:"port"`
+	Source      string    `json:"source"`
+	Team        string    `json:"team,omitempty"`
+	// "<method> <path>" for HTTP
+	Request   string `json:"request,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Verdict   string `json:"verdict,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	// Where a TCP interceptor matched
+	Tcp *TcpMatch `json:"tcp,omitempty"`
+}
+
+// Emits an event to its consumers: TopicEvents, the recent events, counters and the webhook.
+func EmitEvent(e Event) {
+	if e.Time.IsZero() {
+		e.Time = Now()
+	}
+	if e.Team == "" {
+		e.Team = TeamOf(e.Source)
+	}
+	publishEvent(TopicEvents, e)
+	recordRecent(e)
+	incrCounter("interceptor_events", "kind", e.Kind, "interceptor", e.Interceptor)
+	queueWebhook(e)
+}
+
+func (h *httpCtx) emitEvent(kind, interceptor string, port int64, v Verdict) {
+	e := Event{
+		Kind:        kind,
+		Interceptor: interceptor,
+		Port:        port,
+		Source:      h.sourceIP(),
+		Request:     h.getRequestHeader(":method") + " " + h.getRequestHeader(":path"),
+		RequestID:   h.getRequestHeader(requestIDHeader),
+	}
+	if kind == "block" {
+		e.Verdict = v.String()
+	}
+	EmitEvent(e)
+}
+
+func (ctx *tcpCtx) emitEvent(kind, interceptor string, port int64, v Verdict, m *TcpMatch) {
+	e := Event{Kind: kind, Interceptor: interceptor, Port: port, Source: ctx.sourceIP(), Tcp: m}
+	if kind == "block" {
+		e.Verdict = v.String()
+	}
+	EmitEvent(e)
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("%s %s port=%d source=%s", e.Kind, e.Interceptor, e.Port, e.Source)
+	if e.Team != "" {
+		s += " team=" + e.Team
+	}
+	if e.Request != "" {
+		s += " " + e.Request
+	}
+	if e.Tcp != nil {
+		s += fmt.Sprintf(" connection=%d %s offset=%d", e.Tcp.ConnectionID, e.Tcp.Direction, e.Tcp.Offset)
+	}
+	if e.Verdict != "" {
+		s += " (" + e.Verdict + ")"
+	}
+	if e.RequestID != "" {
+		s += " request_id=" + e.RequestID
+	}
+	if e.Detail != "" {
+		s += ": " + e.Detail
+	}
+	return s
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Exceptions are rules without a Do: once their When matches, the stream is exempted from the
+// interceptors they name, by interceptor name or group (see WithGroup), e.g. a health check path
+// bypassing the "waf" group. Exceptions of a port run before its other interceptors at every
+// stage, so an exception matching at a stage also exempts from the rules checked at that stage.
+// Interceptors which already matched are not stopped: their Do keeps running.
+
+// Adds the interceptor to groups exceptions can refer to.
+func WithGroup(groups ...string) HttpInterceptorOption {
+	return func(i *HttpInterceptor) {
+		i.Groups = append(i.Groups, groups...)
+	}
+}
+
+// Registers an exception on a port, exempting streams it matches from the interceptors or groups
+// in exempts.
+func RegisterHttpException(port int64, name string, when func(*HttpWhenContext) bool, exempts []string, opts ...HttpInterceptorOption) {
+	if len(exempts) == 0 {
+		ruleFailed(fmt.Errorf("exception %s on port %d exempts from nothing", name, port))
+		return
+	}
+	i := HttpInterceptor{
+		Name:    name,
+		When:    when,
+		Exempts: exempts,
+	}
+	for _, opt := range opts {
+		opt(&i)
+	}
+	addHttpInterceptor(port, i)
+}
+
+func (i *HttpInterceptor) isException() bool {
+	return len(i.Exempts) > 0
+}
+
+// Records the exemptions of a matched exception.
+func (h *httpCtx) exempt(wc *HttpWhenContext) {
+	wc.LogInfo("exception matched, exempting from " + strings.Join(wc.interceptor.Exempts, ","))
+	h.exemptions = append(h.exemptions, wc.interceptor.Exempts...)
+	incrCounter("interceptor_exceptions", "interceptor", wc.interceptor.Name)
+}
+
+// Reports whether an exception matched earlier exempts the stream from the interceptor.
+func (h *httpCtx) isExempt(i *HttpInterceptor) bool {
+	for _, e := range h.exemptions {
+		if e == i.Name || containsString(i.Groups, e) {
+			return true
+		}
+	}
+	return false
+}
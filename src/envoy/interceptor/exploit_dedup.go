@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"strconv"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Known exploit blocking, "exploit_dedup" in the plugin config: the payload (method, path with query
+// and body) of every request an exploit interceptor (see WithExploit) blocked is hashed into shared
+// data, and requests with a known payload are then rejected on every port, before any interceptor
+// runs.
+type exploitDedupConfig struct {
+	Enabled bool `json:"enabled"`
+	// Payloads remembered (default 1000); the oldest are forgotten
+	MaxPayloads int `json:"max_payloads"`
+}
+
+// Where a known payload was first blocked.
+type KnownExploit struct {
+	Interceptor string    `json:"interceptor"`
+	Port        int64     `json:"port"`
+	Time        time.Time `json:"time"`
+}
+
+// Hashes of the request payload, fed as the request streams.
+type payloadHash struct {
+	// method, path and body, for exploit_dedup
+	hash hash.Hash
+	// body only, for the payload blocklist
+	bodyHash hash.Hash
+	body     BodyReader
+	// Hex digests once the request is complete
+	digest     string
+	bodyDigest string
+}
+
+const exploitIndexKey = "exploit-index"
+
+var exploitKV = NewKV("exploit")
+
+// Marks the interceptor's blocks as exploit blocks, remembered by exploit_dedup. Interceptors which
+// reply locally to legitimate requests (caches, rate limits, decoys...) must not be marked.
+func WithExploit() HttpInterceptorOption {
+	return func(i *HttpInterceptor) {
+		i.Exploit = true
+	}
+}
+
+func (c exploitDedupConfig) maxPayloads() int {
+	if c.MaxPayloads <= 0 {
+		return 1000
+	}
+	return c.MaxPayloads
+}
+
+func init() {
+	registerStageGuard(guardKnownPayload, (*httpCtx).rejectKnownPayload)
+}
+
+// Hashes the request data of the stage, and once the request is complete rejects it with 403 if
+// its payload is known or blocklisted.
+func (h *httpCtx) rejectKnownPayload(stage HttpStage, n int, end bool) bool {
+	if (!config.ExploitDedup.Enabled && !payloadBlocklistEnabled) || stage > StageRequestTrailers {
+		return false
+	}
+	p := h.payload
+	if p == nil {
+		p = &payloadHash{hash: sha256.New(), bodyHash: sha256.New()}
+		h.payload = p
+	}
+	if p.digest != "" {
+		return false
+	}
+	switch stage {
+	case StageRequestHeaders:
+		p.hash.Write([]byte(h.getRequestHeader(":method") + " " + h.getRequestHeader(":path") + "\n"))
+	case StageRequestBody:
+		chunk, err := p.body.Next(h.bodyOffset(stage), n, proxywasm.GetHttpRequestBody)
+		if err != nil {
+			proxywasm.LogWarn("failed to read request body: " + err.Error())
+		}
+		p.hash.Write(chunk)
+		p.bodyHash.Write(chunk)
+	}
+	if !end && stage != StageRequestTrailers {
+		return false
+	}
+	p.digest = hex.EncodeToString(p.hash.Sum(nil))
+	p.bodyDigest = hex.EncodeToString(p.bodyHash.Sum(nil))
+
+	ip := h.sourceIP()
+	if IsChecker(ip) || IsSourceAllowed(ip) {
+		return false
+	}
+	switch {
+	case config.ExploitDedup.Enabled && isKnownExploit(p.digest, ip):
+		incrCounter("interceptor_known_exploits")
+	case payloadBlocklistEnabled && p.body.Offset() > 0 && isBlockedPayload(p.bodyDigest, ip):
+		incrCounter("interceptor_blocked_payloads")
+	default:
+		return false
+	}
+	reply(403, nil, []byte("forbidden"))
+	h.finish(VerdictBlocked)
+	return true
+}
+
+func isKnownExploit(digest, ip string) bool {
+	b, err := exploitKV.Get(digest)
+	if err != nil || len(b) == 0 {
+		return false
+	}
+	var known KnownExploit
+	json.Unmarshal(b, &known)
+	proxywasm.LogInfo("rejected known exploit from " + ip + ", first blocked by " + known.Interceptor + " on port " + strconv.FormatInt(known.Port, 10))
+	return true
+}
+
+// Remembers the payload of a request an exploit interceptor blocked. Requests blocked before they
+// were complete have no payload hash and are skipped.
+func (h *httpCtx) recordExploit(i *HttpInterceptor, port int64) {
+	if !config.ExploitDedup.Enabled || !i.Exploit || h.payload == nil || h.payload.digest == "" {
+		return
+	}
+	digest := h.payload.digest
+	b, _ := json.Marshal(KnownExploit{Interceptor: i.Name, Port: port, Time: Now()})
+	claimed, err := exploitKV.CompareAndSwap(digest, nil, b)
+	if err != nil {
+		proxywasm.LogWarn("failed to record exploit: " + err.Error())
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	var evicted []string
+	_, err = rootKV.Update(exploitIndexKey, func(old []byte) []byte {
+		digests := append(decodeMembers(old), digest)
+		evicted = nil
+		if over := len(digests) - config.ExploitDedup.maxPayloads(); over > 0 {
+			evicted = append(evicted, digests[:over]...)
+			digests = digests[over:]
+		}
+		b, _ := json.Marshal(digests)
+		return b
+	})
+	if err != nil {
+		proxywasm.LogWarn("failed to index exploit: " + err.Error())
+	}
+	for _, d := range evicted {
+		exploitKV.Set(d, nil)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// ExternalVerdict asks an external service (an Envoy cluster) whether to let a request through.
+// The service gets a POST with the JSON request summary and answers with
+// {"verdict": "allow"|"block", "status": 403, "body": "..."} (status and body optional).
+type ExternalVerdict struct {
+	Cluster   string
+	Authority string
+	Path      string
+	// Request body bytes included in the summary (0 sends headers only)
+	MaxBody int
+	// Time to wait for the answer (1s if 0)
+	Timeout time.Duration
+	// Let requests through when the service fails or doesn't answer in time
+	FailOpen bool
+}
+
+type externalSummary struct {
+	Source  string            `json:"source"`
+	Port    int64             `json:"port"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	// Base64, at most MaxBody bytes
+	Body      []byte `json:"body,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+type externalAnswer struct {
+	Verdict string `json:"verdict"`
+	Status  uint32 `json:"status"`
+	Body    string `json:"body"`
+}
+
+type externalState struct {
+	summary externalSummary
+	asked   bool
+}
+
+// Pauses the request until the external service decided; blocked requests get the status and body it
+// chose (403 by default).
+func DoExternalVerdict(ev ExternalVerdict) func(*HttpDoContext) Verdict {
+	timeout := ev.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		s := State[externalState](ctx)
+		if s.asked {
+			return VerdictPause
+		}
+
+		switch ctx.Stage {
+		case StageRequestHeaders:
+			headers, _ := proxywasm.GetHttpRequestHeaders()
+			s.summary = externalSummary{
+				Source:  ctx.SourceIP,
+				Port:    ctx.Port,
+				Method:  ctx.GetRequestHeader(":method"),
+				Path:    ctx.GetRequestHeader(":path"),
+				Headers: make(map[string]string, len(headers)),
+			}
+			for _, h := range headers {
+				if !strings.HasPrefix(h[0], ":") {
+					s.summary.Headers[h[0]] = h[1]
+				}
+			}
+			if ev.MaxBody > 0 && !ctx.End {
+				return VerdictContinue
+			}
+		case StageRequestBody:
+			if ctx.BodySize < ev.MaxBody && !ctx.End {
+				return VerdictPause
+			}
+			size := ctx.BodySize
+			if size > ev.MaxBody {
+				size = ev.MaxBody
+				s.summary.Truncated = true
+			}
+			s.summary.Body, _ = ctx.GetRequestBody(0, size)
+		case StageRequestTrailers:
+		default:
+			// the request went through before the service was asked
+			return VerdictModified
+		}
+
+		body, _ := json.Marshal(s.summary)
+		headers := [][2]string{
+			{":method", "POST"},
+			{":path", ev.Path},
+			{":authority", ev.Authority},
+			{"content-type", "application/json"},
+		}
+		_, err := proxywasm.DispatchHttpCall(ev.Cluster, headers, body, nil, uint32(timeout.Milliseconds()), func(_, bodySize, _ int) {
+			ev.apply(ctx, bodySize)
+		})
+		if err != nil {
+			ctx.LogWarn("external verdict: " + err.Error())
+			if ev.FailOpen {
+				return VerdictModified
+			}
+			return ev.block(ctx, externalAnswer{})
+		}
+		s.asked = true
+		return VerdictPause
+	}
+}
+
+// Applies the service's answer, from the HTTP call callback.
+func (ev ExternalVerdict) apply(ctx *HttpDoContext, bodySize int) {
+	if proxywasm.SetEffectiveContext(ctx.ContextID) != nil {
+		return
+	}
+	var answer externalAnswer
+	var err error
+	if status := httpCallStatus(); status != "200" {
+		err = errExternalStatus(status)
+	} else {
+		var body []byte
+		if body, err = proxywasm.GetHttpCallResponseBody(0, bodySize); err == nil {
+			err = json.Unmarshal(body, &answer)
+		}
+	}
+
+	switch {
+	case err != nil:
+		ctx.LogWarn("external verdict: " + err.Error())
+		if ev.FailOpen {
+			ctx.Resume(VerdictModified)
+		} else {
+			ctx.Resume(ev.block(ctx, externalAnswer{}))
+		}
+	case answer.Verdict == "block":
+		ctx.LogInfo("external verdict: block")
+		ctx.Resume(ev.block(ctx, answer))
+	default:
+		ctx.Resume(VerdictModified)
+	}
+}
+
+func (ev ExternalVerdict) block(ctx *HttpDoContext, answer externalAnswer) Verdict {
+	status := answer.Status
+	if status == 0 {
+		status = 403
+	}
+	body := answer.Body
+	if body == "" {
+		body = "forbidden"
+	}
+	reply(status, nil, []byte(body))
+	return VerdictBlocked
+}
+
+type errExternalStatus string
+
+func (e errExternalStatus) Error() string {
+	if e == "" {
+		return "no answer"
+	}
+	return "status " + string(e)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Encodes SetEnvoyFilterStateArguments by hand: protobuf reflection is not usable under TinyGo.
+func marshalFilterState(path, value string, span LifeSpan) []byte {
+	var b []byte
+	if path != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, path)
+	}
+	if value != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, value)
+	}
+	if span != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(span))
+	}
+	return b
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fingerprints of streams which leaked, aggregated in shared data so the attack shapes behind
+// leaks can be turned into precise rules (see the admin API's /fingerprints).
+type Fingerprint struct {
+	Method string `json:"method"`
+	// Path with ids and numbers replaced by placeholders, e.g. /notes/{num}
+	Path string `json:"path"`
+	// Sorted query and form parameter names
+	Params []string `json:"params"`
+	// Hash of the body structure (JSON keys and value types, form names, or the content type)
+	Body string `json:"body"`
+
+	Count int64     `json:"count"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+	// Leak patterns seen
+	Leaks []string `json:"leaks"`
+}
+
+const (
+	fingerprintIndexKey = "fingerprint-index"
+	maxFingerprints     = 500
+	// Request body bytes kept for the body structure
+	maxFingerprintBody = 8 << 10
+)
+
+var fingerprintKV = NewKV("fingerprint")
+
+var (
+	shapeUuid  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	shapeNum   = regexp.MustCompile(`^\d+$`)
+	shapeHex   = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	shapeToken = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+	shapeDigit = regexp.MustCompile(`\d`)
+)
+
+// Replaces the variable segments of a path (without query) by placeholders.
+func pathShape(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		switch {
+		case s == "":
+		case shapeNum.MatchString(s):
+			segments[i] = "{num}"
+		case shapeUuid.MatchString(s):
+			segments[i] = "{uuid}"
+		case shapeHex.MatchString(s):
+			segments[i] = "{hex}"
+		case shapeToken.MatchString(s):
+			if len(s) >= 12 && shapeDigit.MatchString(s) {
+				segments[i] = "{id}"
+			}
+		default:
+			segments[i] = "{str}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Structure of a JSON value: object keys and value types, not values.
+func jsonShape(v interface{}) string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = k + ":" + jsonShape(v[k])
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case []interface{}:
+		if len(v) == 0 {
+			return "[]"
+		}
+		return "[" + jsonShape(v[0]) + "]"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	}
+	return "null"
+}
+
+// Request side of a fingerprint, collected while the request streams.
+type requestFingerprint struct {
+	method      string
+	path        string
+	params      []string
+	contentType string
+	body        []byte
+	truncated   bool
+	reader      BodyReader
+}
+
+// Collects the request parts of a fingerprint at the request stages; returns nil once they passed.
+func collectFingerprint(ctx *HttpWhenContext) *requestFingerprint {
+	fp := State[requestFingerprint](ctx)
+	switch ctx.Stage {
+	case StageRequestHeaders:
+		*fp = requestFingerprint{method: ctx.GetRequestHeader(":method"), contentType: ctx.GetRequestHeader("content-type")}
+		path, query, _ := strings.Cut(ctx.GetRequestHeader(":path"), "?")
+		fp.path = pathShape(path)
+		if values, err := url.ParseQuery(query); err == nil {
+			for name := range values {
+				fp.params = append(fp.params, name)
+			}
+		}
+	case StageRequestBody:
+		if fp.method == "" || fp.truncated {
+			break
+		}
+		chunk, err := fp.reader.NextRequest(ctx)
+		if err != nil {
+			break
+		}
+		if len(fp.body)+len(chunk) > maxFingerprintBody {
+			fp.truncated = true
+			fp.body = nil
+			break
+		}
+		fp.body = append(fp.body, chunk...)
+	}
+	return fp
+}
+
+func (r *requestFingerprint) fingerprint() Fingerprint {
+	fp := Fingerprint{Method: r.method, Path: r.path, Params: append([]string(nil), r.params...)}
+	ct, _, _ := strings.Cut(strings.ToLower(r.contentType), ";")
+	shape := strings.TrimSpace(ct)
+	var doc interface{}
+	switch {
+	case r.truncated:
+		shape += " large"
+	case len(r.body) == 0:
+		shape = ""
+	case json.Unmarshal(r.body, &doc) == nil:
+		shape = "json " + jsonShape(doc)
+	case shape == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(r.body)); err == nil {
+			for name := range values {
+				fp.Params = append(fp.Params, name)
+			}
+		}
+	}
+	sort.Strings(fp.Params)
+	if shape != "" {
+		sum := sha256.Sum256([]byte(shape))
+		fp.Body = hex.EncodeToString(sum[:8])
+	}
+	return fp
+}
+
+func (fp Fingerprint) digest() string {
+	sum := sha256.Sum256([]byte(fp.Method + " " + fp.Path + " " + strings.Join(fp.Params, ",") + " " + fp.Body))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Counts a leak by the stream the When collected a requestFingerprint for.
+func recordFingerprint(ctx *HttpDoContext, leaks []string) {
+	r := WhenState[requestFingerprint](ctx)
+	if r == nil {
+		return
+	}
+	fp := r.fingerprint()
+	digest := fp.digest()
+	now := Now()
+	_, err := fingerprintKV.Update(digest, func(old []byte) []byte {
+		var stored Fingerprint
+		if old == nil || json.Unmarshal(old, &stored) != nil {
+			stored = fp
+			stored.First = now
+		}
+		stored.Count++
+		stored.Last = now
+		for _, l := range leaks {
+			if !containsString(stored.Leaks, l) {
+				stored.Leaks = append(stored.Leaks, l)
+			}
+		}
+		b, _ := json.Marshal(stored)
+		return b
+	})
+	if err == nil {
+		err = addFingerprint(digest)
+	}
+	if err != nil {
+		ctx.LogWarn("failed to record fingerprint: " + err.Error())
+	}
+}
+
+// Adds a digest to the index, dropping the oldest beyond maxFingerprints.
+func addFingerprint(digest string) error {
+	_, err := rootKV.Update(fingerprintIndexKey, func(old []byte) []byte {
+		digests := decodeMembers(old)
+		if containsString(digests, digest) {
+			return old
+		}
+		digests = append(digests, digest)
+		if len(digests) > maxFingerprints {
+			digests = digests[len(digests)-maxFingerprints:]
+		}
+		b, _ := json.Marshal(digests)
+		return b
+	})
+	return err
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// The n most frequent fingerprints of leaking streams.
+func TopFingerprints(n int) ([]Fingerprint, error) {
+	digests, err := rootKV.SetMembers(fingerprintIndexKey)
+	if err != nil {
+		return nil, err
+	}
+	fps := make([]Fingerprint, 0, len(digests))
+	for _, d := range digests {
+		b, err := fingerprintKV.Get(d)
+		if err != nil {
+			return nil, err
+		}
+		var fp Fingerprint
+		if json.Unmarshal(b, &fp) == nil {
+			fps = append(fps, fp)
+		}
+	}
+	sort.SliceStable(fps, func(i, j int) bool { return fps[i].Count > fps[j].Count })
+	if len(fps) > n {
+		fps = fps[:n]
+	}
+	return fps, nil
+}
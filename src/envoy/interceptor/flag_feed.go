@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Where the game server publishes flag IDs, "flag_ids" in the plugin config. The response is any
+// JSON document; every string or number under a key equal to Team (anywhere if Team is empty) is
+// taken as a flag ID, which fits the usual attack.json layouts (service -> team -> round -> ids).
+type flagFeedConfig struct {
+	// Envoy cluster of the game server (feed disabled if empty)
+	Cluster   string `json:"cluster"`
+	Authority string `json:"authority"`
+	Path      string `json:"path"`
+	// Key of our team in the document, e.g. our vulnbox IP
+	Team string `json:"team"`
+	// Seconds between fetches (default 30)
+	Interval int `json:"interval"`
+}
+
+// Known flag IDs live in shared data as a JSON array, the newest last. The version counts their
+// updates, so workers only reload the array when it changed.
+const (
+	flagIdsKey        = "flag-ids"
+	flagIdsVersionKey = "flag-ids/version"
+	flagFeedClaimKey  = "flag-ids/fetched-at"
+	maxFlagIds        = 10000
+	flagFeedTimeoutMs = 5000
+)
+
+func (c flagFeedConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.Interval) * time.Second
+}
+
+func flagFeedTickPeriod() time.Duration {
+	if config.FlagIds.Cluster == "" {
+		return 0
+	}
+	return time.Second
+}
+
+// This VM's copy of the known flag IDs, reloaded by the tick, and the version it was loaded at.
+var (
+	flagIdSet     = map[string]bool{}
+	flagIdVersion = int64(-1)
+)
+
+// Called from the plugin tick: reloads the known flag IDs if they changed, and fetches the feed once
+// the interval passed. Every worker ticks, the first to claim the fetch in shared data does it.
+func checkFlagFeed() {
+	c := config.FlagIds
+	if c.Cluster == "" {
+		return
+	}
+	reloadFlagIds()
+	last, err := rootKV.Get(flagFeedClaimKey)
+	if err != nil || Since(time.Unix(0, decodeInt64(last))) < c.interval() {
+		return
+	}
+	if claimed, _ := rootKV.CompareAndSwap(flagFeedClaimKey, last, encodeInt64(Now().UnixNano())); !claimed {
+		return
+	}
+
+	headers := [][2]string{{":method", "GET"}, {":path", c.Path}, {":authority", c.Authority}}
+	_, err = proxywasm.DispatchHttpCall(c.Cluster, headers, nil, nil, flagFeedTimeoutMs, func(_, bodySize, _ int) {
+		if status := httpCallStatus(); status != "200" {
+			proxywasm.LogWarn("flag id feed: status " + status)
+			return
+		}
+		body, err := proxywasm.GetHttpCallResponseBody(0, bodySize)
+		if err != nil {
+			proxywasm.LogWarn("flag id feed: " + err.Error())
+			return
+		}
+		ids, err := parseFlagIds(body, c.Team)
+		if err != nil {
+			proxywasm.LogWarn("flag id feed: " + err.Error())
+			return
+		}
+		if err := addFlagIds(ids); err != nil {
+			proxywasm.LogWarn("flag id feed: " + err.Error())
+			return
+		}
+		proxywasm.LogInfo("flag id feed: " + strconv.Itoa(len(ids)) + " ids")
+	})
+	if err != nil {
+		proxywasm.LogWarn("flag id feed: " + err.Error())
+	}
+}
+
+func parseFlagIds(body []byte, team string) ([]string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	var ids []string
+	var walk func(v interface{}, collect bool)
+	walk = func(v interface{}, collect bool) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				walk(child, collect || k == team)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child, collect)
+			}
+		case string:
+			if collect {
+				ids = append(ids, v)
+			}
+		case float64:
+			if collect {
+				ids = append(ids, strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+	}
+	walk(doc, team == "")
+	return ids, nil
+}
+
+// Merges ids into the known set, dropping the oldest beyond maxFlagIds.
+func addFlagIds(ids []string) error {
+	_, err := rootKV.Update(flagIdsKey, func(old []byte) []byte {
+		known := decodeMembers(old)
+		seen := make(map[string]bool, len(known))
+		for _, id := range known {
+			seen[id] = true
+		}
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				known = append(known, id)
+			}
+		}
+		if len(known) > maxFlagIds {
+			known = known[len(known)-maxFlagIds:]
+		}
+		b, _ := json.Marshal(known)
+		return b
+	})
+	if err != nil {
+		return err
+	}
+	_, err = rootKV.Increment(flagIdsVersionKey, 1)
+	return err
+}
+
+func reloadFlagIds() {
+	version, err := rootKV.GetInt(flagIdsVersionKey)
+	if err != nil || version == flagIdVersion {
+		return
+	}
+	ids, err := rootKV.SetMembers(flagIdsKey)
+	if err != nil {
+		proxywasm.LogWarn("flag id feed: " + err.Error())
+		return
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	flagIdSet, flagIdVersion = set, version
+}
+
+// Flag IDs issued to our team so far (the latest maxFlagIds).
+func FlagIds() []string {
+	ids, _ := rootKV.SetMembers(flagIdsKey)
+	return ids
+}
+
+// Reports whether id was issued to our team, as of this worker's last tick.
+func IsIssuedFlagId(id string) bool {
+	return flagIdSet[id]
+}
+
+// Matches requests referencing a flag ID (as extracted by key) which was never issued to our team,
+// i.e. guessed or enumerated rather than taken from the feed. Requests without one don't match, and
+// nothing matches until the feed delivered IDs.
+func WhenUnknownFlagId(key KeyExtractor) func(*HttpWhenContext) bool {
+	return func(ctx *HttpWhenContext) bool {
+		id, ok := key.extract(ctx, State[int](ctx))
+		if !ok || id == "" {
+			return false
+		}
+		if len(flagIdSet) == 0 || flagIdSet[id] {
+			return false
+		}
+		ctx.LogInfo("unknown flag id " + id)
+		return true
+	}
+}
+
+// Status of the response to a DispatchHttpCall, from within its callback.
+func httpCallStatus() string {
+	status, _ := httpCallHeader(":status")
+	return status
+}
+
+// Header of the response to a DispatchHttpCall, from within its callback.
+func httpCallHeader(name string) (string, bool) {
+	headers, _ := proxywasm.GetHttpCallResponseHeaders()
+	for _, h := range headers {
+		if h[0] == name {
+			return h[1], true
+		}
+	}
+	return "", false
+}
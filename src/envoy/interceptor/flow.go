@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Flow records, "flow_records" in the plugin config: one record per HTTP stream pairing request
+// and response (timestamps, sizes, headers, verdict, truncated bodies), for external collectors.
+// They don't replace the tap capture logs_ingestion reads. Records are posted as JSON lines to a
+// collector cluster in batches, or logged one line each without a cluster.
+type flowRecordConfig struct {
+	Enabled bool `json:"enabled"`
+	// Envoy cluster of the collector (records are logged if empty)
+	Cluster   string `json:"cluster"`
+	Authority string `json:"authority"`
+	Path      string `json:"path"`
+	// Body bytes kept per direction (default 1024)
+	MaxBody int `json:"max_body"`
+}
+
+type FlowRecord struct {
+	RequestID  string    `json:"request_id,omitempty"`
+	Port       int64     `json:"port"`
+	Source     string    `json:"source"`
+	Team       string    `json:"team,omitempty"`
+	JA3        string    `json:"ja3,omitempty"`
+	Start      time.Time `json:"start"`
+	DurationMs int64     `json:"duration_ms"`
+
+	Method         string      `json:"method"`
+	Path           string      `json:"path"`
+	RequestHeaders [][2]string `json:"request_headers"`
+	RequestBody    []byte      `json:"request_body,omitempty"`
+	RequestBytes   int         `json:"request_bytes"`
+
+	Status          string      `json:"status,omitempty"`
+	ResponseHeaders [][2]string `json:"response_headers,omitempty"`
+	ResponseBody    []byte      `json:"response_body,omitempty"`
+	ResponseBytes   int         `json:"response_bytes"`
+
+	// Interceptors whose When matched
+	Matched   []string `json:"matched,omitempty"`
+	Verdict   string   `json:"verdict"`
+	LastStage string   `json:"last_stage"`
+	// Recorded by DoRecordAndContinue
+	Recorded bool `json:"recorded,omitempty"`
+}
+
+// Request and response data collected as the stream goes.
+type flowCapture struct {
+	record       FlowRecord
+	requestBody  BodyReader
+	responseBody BodyReader
+}
+
+const (
+	// Records beyond this are dropped until the next batch
+	maxFlowQueue     = 500
+	flowFlushPeriod  = 2 * time.Second
+	flowTimeoutMs    = 5000
+	defaultFlowBody  = 1024
+	flowRecordPrefix = "flow "
+)
+
+var (
+	flowQueue   []FlowRecord
+	flowDropped int
+)
+
+func (c flowRecordConfig) maxBody() int {
+	if c.MaxBody <= 0 {
+		return defaultFlowBody
+	}
+	return c.MaxBody
+}
+
+func flowTickPeriod() time.Duration {
+	// recordings are posted even if per-stream records are disabled
+	if config.FlowRecords.Cluster == "" {
+		return 0
+	}
+	return flowFlushPeriod
+}
+
+// Collects the data of the stage, before interceptors see it.
+func (h *httpCtx) captureFlow(stage HttpStage, n int) {
+	if !config.FlowRecords.Enabled {
+		return
+	}
+	f := h.flow
+	if f == nil {
+		if stage != StageRequestHeaders {
+			// streams answered by the filter itself (admin API, bans) have no record
+			return
+		}
+		f = &flowCapture{record: FlowRecord{Start: h.startedAt, Source: h.sourceIP(), Team: TeamOf(h.sourceIP())}}
+		if h.tls != nil {
+			f.record.JA3 = h.tls.JA3
+		}
+		h.flow = f
+	}
+	r := &f.record
+	switch stage {
+	case StageRequestHeaders:
+		r.RequestHeaders, _ = proxywasm.GetHttpRequestHeaders()
+		r.RequestID = h.getRequestHeader(requestIDHeader)
+		r.Method = h.getRequestHeader(":method")
+		r.Path = h.getRequestHeader(":path")
+	case StageRequestBody:
+		r.RequestBody = appendCapped(r.RequestBody, &f.requestBody, h.bodyOffset(stage), n, proxywasm.GetHttpRequestBody)
+	case StageResponseHeaders:
+		r.ResponseHeaders, _ = proxywasm.GetHttpResponseHeaders()
+		r.Status = h.getResponseHeader(":status")
+	case StageResponseBody:
+		r.ResponseBody = appendCapped(r.ResponseBody, &f.responseBody, h.bodyOffset(stage), n, proxywasm.GetHttpResponseBody)
+	}
+}
+
+func appendCapped(body []byte, r *BodyReader, offset, size int, get func(int, int) ([]byte, error)) []byte {
+	room := config.FlowRecords.maxBody() - len(body)
+	if room <= 0 {
+		return body
+	}
+	chunk, err := r.Next(offset, size, get)
+	if err != nil {
+		return body
+	}
+	if len(chunk) > room {
+		chunk = chunk[:room]
+	}
+	return append(body, chunk...)
+}
+
+// Completes the stream's record and exports it.
+func (h *httpCtx) exportFlow(port int64) {
+	if h.flow == nil {
+		return
+	}
+	r := h.flow.record
+	h.flow = nil
+	r.Port = port
+	r.DurationMs = Since(r.Start).Milliseconds()
+	r.RequestBytes = h.requestBodyBytes
+	r.ResponseBytes = h.responseBodyBytes
+	r.Verdict = h.lastVerdict.String()
+	r.LastStage = h.lastStage.String()
+	for _, wc := range h.whenContexts {
+		if wc != nil && wc.doContext != nil {
+			r.Matched = append(r.Matched, wc.interceptor.Name)
+		}
+	}
+
+	queueFlow(r)
+}
+
+// Logs the record without a collector cluster, or queues it for the next batch.
+func queueFlow(r FlowRecord) {
+	if config.FlowRecords.Cluster == "" {
+		b, _ := json.Marshal(r)
+		proxywasm.LogInfo(flowRecordPrefix + string(b))
+		return
+	}
+	if len(flowQueue) >= maxFlowQueue {
+		flowDropped++
+		return
+	}
+	flowQueue = append(flowQueue, r)
+}
+
+// Called from the plugin tick: posts the queued records as JSON lines.
+func flushFlows() {
+	c := config.FlowRecords
+	if flowDropped > 0 {
+		proxywasm.LogWarn(fmt.Sprintf("flow records: queue full, %d records lost", flowDropped))
+		flowDropped = 0
+	}
+	if len(flowQueue) == 0 {
+		return
+	}
+	lines := make([]string, len(flowQueue))
+	for i, r := range flowQueue {
+		b, _ := json.Marshal(r)
+		lines[i] = string(b)
+	}
+	count := len(flowQueue)
+	flowQueue = nil
+
+	headers := [][2]string{
+		{":method", "POST"},
+		{":path", c.Path},
+		{":authority", c.Authority},
+		{"content-type", "application/x-ndjson"},
+	}
+	body := []byte(strings.Join(lines, "\n") + "\n")
+	_, err := proxywasm.DispatchHttpCall(c.Cluster, headers, body, nil, flowTimeoutMs, func(int, int, int) {
+		if status := httpCallStatus(); !strings.HasPrefix(status, "2") {
+			proxywasm.LogWarn(fmt.Sprintf("flow records: status %s, %d records lost", status, count))
+		}
+	})
+	if err != nil {
+		proxywasm.LogWarn(fmt.Sprintf("flow records: %s, %d records lost", err.Error(), count))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Declares which bodies Do may replace, so that the other direction keeps its Content-Length.
+// Without it, an active Do is assumed to replace both.
+func (c *HttpDoContext) ReplacesBodies(request, response bool) {
+	c.keepsFraming = 0
+	if !request {
+		c.keepsFraming |= 1 << StageRequestHeaders
+	}
+	if !response {
+		c.keepsFraming |= 1 << StageResponseHeaders
+	}
+}
+
+// A Do context staying active past the headers may still replace the body, and headers can't be
+// changed once forwarded. Drop Content-Length up front so the body is sent chunked, unless none of
+// the active Dos replaces that direction's body.
+func (h *httpCtx) releaseContentLength(stage HttpStage) {
+	replaced := false
+	for _, c := range h.doContexts {
+		replaced = replaced || !c.keepsFraming.has(stage)
+	}
+	if !replaced {
+		return
+	}
+	switch stage {
+	case StageRequestHeaders:
+		if h.getRequestHeader("content-length") != "" {
+			proxywasm.RemoveHttpRequestHeader("content-length")
+			h.requestHeaders.del("content-length")
+		}
+	case StageResponseHeaders:
+		if h.getResponseHeader("content-length") != "" {
+			proxywasm.RemoveHttpResponseHeader("content-length")
+			h.responseHeaders.del("content-length")
+		}
+	}
+}
+
+// Keeps framing consistent after a body replacement: if headers are still held back, Content-Length is
+// set to the new size when the whole body is known, and dropped otherwise.
+func (h *httpCtx) fixFraming(isReq bool, end bool, size int) {
+	sent, offset := h.responseHeadersSent, h.responseBodyOffset
+	get, set, del := h.getResponseHeader, proxywasm.ReplaceHttpResponseHeader, proxywasm.RemoveHttpResponseHeader
+	cache := h.responseHeaders
+	if isReq {
+		sent, offset = h.requestHeadersSent, h.requestBodyOffset
+		get, set, del = h.getRequestHeader, proxywasm.ReplaceHttpRequestHeader, proxywasm.RemoveHttpRequestHeader
+		cache = h.requestHeaders
+	}
+
+	if sent {
+		if get("content-length") != "" {
+			proxywasm.LogWarn("body replaced after headers with content-length were sent, framing may be broken")
+		}
+		return
+	}
+	if end && offset == 0 {
+		length := strconv.Itoa(size)
+		set("content-length", length)
+		cache.set("content-length", length)
+		return
+	}
+	del("content-length")
+	cache.del("content-length")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// A fuse caps how often an interceptor may act: once its Do blocked or modified streams more than
+// Max times within Window, the fuse trips and the interceptor only observes (When still matches and logs, Do no longer
+// runs) until the fuse is reset from the admin API. A "fuse" event is sent when it trips. This
+// protects the SLA against a rule which suddenly matches checker traffic.
+type Fuse struct {
+	// Actions allowed per window (no fuse if 0)
+	Max    int
+	Window time.Duration
+}
+
+// Fuse of interceptors which don't set their own (none by default).
+var defaultFuse Fuse
+
+// Sets the fuse of every interceptor (HTTP and TCP) without one of its own.
+func SetDefaultFuse(max int, window time.Duration) {
+	defaultFuse = Fuse{Max: max, Window: window}
+}
+
+// Overrides the fuse of a single interceptor.
+func WithFuse(max int, window time.Duration) HttpInterceptorOption {
+	return func(i *HttpInterceptor) {
+		i.Fuse = Fuse{Max: max, Window: window}
+	}
+}
+
+// Exempts the interceptor from the default fuse, for rules acting on every stream by design
+// (hardening, caching...).
+func WithoutFuse() HttpInterceptorOption {
+	return func(i *HttpInterceptor) {
+		i.Fuse = Fuse{Max: -1}
+	}
+}
+
+const trippedFusesKey = "tripped"
+
+var fuseKV = NewKV("fuse")
+
+func fuseName(port int64, interceptor string) string {
+	return strconv.FormatInt(port, 10) + "/" + interceptor
+}
+
+// Reports whether the fuse of the interceptor is tripped, in which case Do must not run.
+func (f Fuse) tripped(port int64, interceptor string) bool {
+	if f.Max <= 0 || f.Window <= 0 {
+		return false
+	}
+	b, err := fuseKV.Get("tripped/" + fuseName(port, interceptor))
+	return err == nil && len(b) > 0
+}
+
+// Counts an action (a block or modification) of the interceptor, tripping the fuse once there were
+// too many.
+func (f Fuse) count(port int64, interceptor, source string) {
+	if f.Max <= 0 || f.Window <= 0 {
+		return
+	}
+	name := fuseName(port, interceptor)
+	count, err := fuseKV.IncrementWindow("count/"+name, f.Window)
+	if err != nil || count <= int64(f.Max) {
+		return
+	}
+
+	claimed, err := fuseKV.CompareAndSwap("tripped/"+name, nil, encodeInt64(Now().UnixNano()))
+	if err != nil || !claimed {
+		// already tripped, possibly by another worker
+		return
+	}
+	fuseKV.SetAdd(trippedFusesKey, name)
+	detail := fmt.Sprintf("fuse tripped after %d actions in %s, observing only", count, f.Window)
+	proxywasm.LogWarn(fmt.Sprintf("[%s] %s", interceptor, detail))
+	incrCounter("interceptor_fuses_tripped", "interceptor", interceptor)
+	EmitEvent(Event{Kind: "fuse", Interceptor: interceptor, Port: port, Source: source, Detail: detail})
+}
+
+func (i *HttpInterceptor) fuse() Fuse {
+	if i.Fuse.Max != 0 {
+		return i.Fuse
+	}
+	return defaultFuse
+}
+
+// Tripped fuses and when they tripped, by "<port>/<interceptor>".
+func TrippedFuses() (map[string]time.Time, error) {
+	names, err := fuseKV.SetMembers(trippedFusesKey)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]time.Time{}
+	for _, name := range names {
+		at, err := fuseKV.GetInt("tripped/" + name)
+		if err != nil {
+			return nil, err
+		}
+		if at != 0 {
+			out[name] = time.Unix(0, at)
+		}
+	}
+	return out, nil
+}
+
+// Resets a tripped fuse, named "<port>/<interceptor>", so the interceptor acts again.
+func ResetFuse(name string) error {
+	if _, _, ok := strings.Cut(name, "/"); !ok {
+		return fmt.Errorf("invalid fuse %q", name)
+	}
+	if err := fuseKV.Set("tripped/"+name, nil); err != nil {
+		return err
+	}
+	if err := fuseKV.Set("count/"+name, nil); err != nil {
+		return err
+	}
+	if err := fuseKV.SetRemove(trippedFusesKey, name); err != nil {
+		return err
+	}
+	proxywasm.LogInfo("reset fuse " + name)
+	return nil
+}
@@ -6,3 +6,5 @@ require (
 	github.com/proxy-wasm/proxy-wasm-go-sdk v0.0.0-20250212164326-ab4161dcf924
 	google.golang.org/protobuf v1.36.9
 )
+
+require github.com/tetratelabs/wazero v1.7.2 // indirect
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var errGraphqlSyntax = errors.New("graphql syntax error")
+
+// Selections nested deeper than this are rejected by the parser outright.
+const maxGraphqlNesting = 256
+
+// A parsed GraphQL operation, fragments expanded.
+type GraphqlOperation struct {
+	// query, mutation or subscription
+	Type string
+	Name string
+	// Names of all selected fields, at any depth (aliases resolved)
+	Fields []string
+	// Deepest field nesting, 1 for a flat selection
+	Depth int
+	// Variables sent along
+	Variables map[string]interface{}
+}
+
+type graphqlField struct {
+	name     string
+	children []graphqlSelection
+}
+
+// Either a field or a fragment spread (by name).
+type graphqlSelection struct {
+	field  *graphqlField
+	spread string
+}
+
+type graphqlParser struct {
+	toks []string
+	pos  int
+	// Nesting of the selection set being parsed
+	depth int
+}
+
+func tokenizeGraphql(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(s) && s[i] != '\n' && s[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "..."):
+			toks = append(toks, "...")
+			i += 3
+		case strings.IndexByte("!$&()/:=@[]{|}", c) >= 0:
+			toks = append(toks, s[i:i+1])
+			i++
+		case strings.HasPrefix(s[i:], `"""`):
+			j := i + 3
+			for {
+				k := strings.Index(s[j:], `"""`)
+				if k < 0 {
+					return nil, errGraphqlSyntax
+				}
+				if k > 0 && s[j+k-1] == '\\' {
+					j += k + 3
+					continue
+				}
+				i = j + k + 3
+				break
+			}
+			toks = append(toks, `"`)
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, errGraphqlSyntax
+			}
+			toks = append(toks, `"`)
+			i = j + 1
+		case isGraphqlNameByte(c, false):
+			j := i + 1
+			for j < len(s) && isGraphqlNameByte(s[j], true) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		case c == '-' || ('0' <= c && c <= '9'):
+			j := i + 1
+			for j < len(s) && strings.IndexByte("0123456789.eE+-", s[j]) >= 0 {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		default:
+			return nil, errGraphqlSyntax
+		}
+	}
+	return toks, nil
+}
+
+func (p *graphqlParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *graphqlParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func isGraphqlNameByte(c byte, digits bool) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || (digits && '0' <= c && c <= '9')
+}
+
+func isGraphqlName(t string) bool {
+	return t != "" && isGraphqlNameByte(t[0], false)
+}
+
+// Skips a balanced (...) or [...] group if one starts here.
+func (p *graphqlParser) skipGroup(open, close string) error {
+	if p.peek() != open {
+		return nil
+	}
+	level := 0
+	for p.pos < len(p.toks) {
+		switch p.next() {
+		case open:
+			level++
+		case close:
+			level--
+			if level == 0 {
+				return nil
+			}
+		}
+	}
+	return errGraphqlSyntax
+}
+
+func (p *graphqlParser) skipDirectives() error {
+	for p.peek() == "@" {
+		p.next()
+		if !isGraphqlName(p.next()) {
+			return errGraphqlSyntax
+		}
+		if err := p.skipGroup("(", ")"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *graphqlParser) selectionSet() ([]graphqlSelection, error) {
+	if p.next() != "{" {
+		return nil, errGraphqlSyntax
+	}
+	p.depth++
+	if p.depth > maxGraphqlNesting {
+		return nil, errGraphqlSyntax
+	}
+	defer func() { p.depth-- }()
+
+	var sels []graphqlSelection
+	for p.peek() != "}" {
+		if p.pos >= len(p.toks) {
+			return nil, errGraphqlSyntax
+		}
+		if p.peek() == "..." {
+			p.next()
+			if t := p.peek(); isGraphqlName(t) && t != "on" {
+				p.next()
+				if err := p.skipDirectives(); err != nil {
+					return nil, err
+				}
+				sels = append(sels, graphqlSelection{spread: t})
+				continue
+			}
+			// inline fragment: its fields belong to the enclosing selection
+			if p.peek() == "on" {
+				p.next()
+				p.next()
+			}
+			if err := p.skipDirectives(); err != nil {
+				return nil, err
+			}
+			inner, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			sels = append(sels, inner...)
+			continue
+		}
+
+		name := p.next()
+		if !isGraphqlName(name) {
+			return nil, errGraphqlSyntax
+		}
+		if p.peek() == ":" {
+			p.next()
+			if name = p.next(); !isGraphqlName(name) {
+				return nil, errGraphqlSyntax
+			}
+		}
+		if err := p.skipGroup("(", ")"); err != nil {
+			return nil, err
+		}
+		if err := p.skipDirectives(); err != nil {
+			return nil, err
+		}
+		f := &graphqlField{name: name}
+		if p.peek() == "{" {
+			children, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			f.children = children
+		}
+		sels = append(sels, graphqlSelection{field: f})
+	}
+	p.next()
+	return sels, nil
+}
+
+type graphqlDefinition struct {
+	typ, name string
+	sels      []graphqlSelection
+}
+
+// Parses a document into its operations and fragments.
+func parseGraphqlDocument(query string) ([]graphqlDefinition, map[string][]graphqlSelection, error) {
+	toks, err := tokenizeGraphql(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &graphqlParser{toks: toks}
+	var ops []graphqlDefinition
+	fragments := map[string][]graphqlSelection{}
+	for p.pos < len(p.toks) {
+		def := graphqlDefinition{typ: "query"}
+		switch t := p.peek(); t {
+		case "{":
+		case "query", "mutation", "subscription":
+			p.next()
+			def.typ = t
+			if isGraphqlName(p.peek()) {
+				def.name = p.next()
+			}
+			if err := p.skipGroup("(", ")"); err != nil {
+				return nil, nil, err
+			}
+		case "fragment":
+			p.next()
+			def.typ = t
+			def.name = p.next()
+			if p.next() != "on" || !isGraphqlName(p.next()) {
+				return nil, nil, errGraphqlSyntax
+			}
+		default:
+			return nil, nil, errGraphqlSyntax
+		}
+		if err := p.skipDirectives(); err != nil {
+			return nil, nil, err
+		}
+		sels, err := p.selectionSet()
+		if err != nil {
+			return nil, nil, err
+		}
+		def.sels = sels
+		if def.typ == "fragment" {
+			fragments[def.name] = sels
+		} else {
+			ops = append(ops, def)
+		}
+	}
+	if len(ops) == 0 {
+		return nil, nil, errGraphqlSyntax
+	}
+	return ops, fragments, nil
+}
+
+// Expands the fragments of a document. Each fragment is walked once and its result reused by later
+// spreads, so nested fragments spreading each other several times stay linear.
+type graphqlWalker struct {
+	fragments map[string][]graphqlSelection
+	// Fragments being expanded, skipped so cyclic spreads terminate
+	expanding map[string]bool
+	done      map[string]graphqlWalk
+}
+
+type graphqlWalk struct {
+	depth  int
+	fields map[string]bool
+}
+
+func newGraphqlWalker(fragments map[string][]graphqlSelection) *graphqlWalker {
+	return &graphqlWalker{fragments: fragments, expanding: map[string]bool{}, done: map[string]graphqlWalk{}}
+}
+
+// Walks selections expanding fragments, adding the selected fields; returns the depth.
+func (w *graphqlWalker) walk(sels []graphqlSelection, fields map[string]bool) int {
+	depth := 0
+	for _, s := range sels {
+		if s.field == nil {
+			r, ok := w.done[s.spread]
+			if !ok {
+				if w.expanding[s.spread] {
+					continue
+				}
+				w.expanding[s.spread] = true
+				r = graphqlWalk{fields: map[string]bool{}}
+				r.depth = w.walk(w.fragments[s.spread], r.fields)
+				delete(w.expanding, s.spread)
+				w.done[s.spread] = r
+			}
+			for f := range r.fields {
+				fields[f] = true
+			}
+			if r.depth > depth {
+				depth = r.depth
+			}
+			continue
+		}
+		fields[s.field.name] = true
+		if d := 1 + w.walk(s.field.children, fields); d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Parses a GraphQL request body: a JSON request, a batch of them, or a bare query
+// (application/graphql). Only the operation selected by operationName is returned if one is given.
+// The operations of every request which parses are returned; the error reports the first which
+// didn't.
+func ParseGraphql(body []byte) ([]GraphqlOperation, error) {
+	var reqs []graphqlRequest
+	var single graphqlRequest
+	switch {
+	case json.Unmarshal(body, &single) == nil && single.Query != "":
+		reqs = []graphqlRequest{single}
+	case json.Unmarshal(body, &reqs) == nil:
+	default:
+		reqs = []graphqlRequest{{Query: string(body)}}
+	}
+
+	var ops []GraphqlOperation
+	var firstErr error
+	for _, r := range reqs {
+		defs, fragments, err := parseGraphqlDocument(r.Query)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		w := newGraphqlWalker(fragments)
+		for _, d := range defs {
+			if r.OperationName != "" && d.name != r.OperationName {
+				continue
+			}
+			fields := map[string]bool{}
+			op := GraphqlOperation{
+				Type:      d.typ,
+				Name:      d.name,
+				Depth:     w.walk(d.sels, fields),
+				Variables: r.Variables,
+			}
+			for f := range fields {
+				op.Fields = append(op.Fields, f)
+			}
+			ops = append(ops, op)
+		}
+	}
+	return ops, firstErr
+}
+
+// Criteria on GraphQL operations; every one set must hold.
+type GraphqlMatcher struct {
+	OperationName func(string) bool
+	// query, mutation or subscription ("" for any)
+	OperationType string
+	// Any of these fields is selected
+	Fields []string
+	// Selections nest deeper than this (0 disables)
+	MaxDepth int
+	// __schema or __type is selected
+	Introspection bool
+	// Also match bodies with a request which doesn't parse (set by RegisterGraphqlFilter)
+	Unparsable bool
+}
+
+func (m GraphqlMatcher) match(op GraphqlOperation) bool {
+	if m.OperationName != nil && !m.OperationName(op.Name) {
+		return false
+	}
+	if m.OperationType != "" && op.Type != m.OperationType {
+		return false
+	}
+	if m.MaxDepth > 0 && op.Depth <= m.MaxDepth {
+		return false
+	}
+	selected := func(names ...string) bool {
+		for _, f := range op.Fields {
+			for _, n := range names {
+				if f == n {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if len(m.Fields) > 0 && !selected(m.Fields...) {
+		return false
+	}
+	if m.Introspection && !selected("__schema", "__type") {
+		return false
+	}
+	return true
+}
+
+// Body matcher for GraphQL requests with any operation satisfying m. Every request of a batch which
+// parses is checked; one which doesn't only matches if m.Unparsable is set.
+func MatchGraphql(m GraphqlMatcher) func([]byte) bool {
+	return func(body []byte) bool {
+		ops, err := ParseGraphql(body)
+		if err != nil && m.Unparsable {
+			return true
+		}
+		for _, op := range ops {
+			if m.match(op) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Rejects GraphQL requests to matching paths on a port with 400 when m matches, or when any request
+// of the body doesn't parse (it could hide an operation m would match).
+func RegisterGraphqlFilter(port int64, path func(string) bool, m GraphqlMatcher) {
+	m.Unparsable = true
+	RegisterHttpMatcher(port, "graphql filter", Matcher{
+		Path:   path,
+		Method: MatchMethod("POST"),
+		Body:   MatchGraphql(m),
+	}, DoBadRequest, WithExploit())
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseGraphql(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		types  []string
+		names  []string
+		fields []string
+		depth  int
+		err    bool
+	}{
+		{
+			name:   "bare query",
+			body:   `{ user { id name } }`,
+			types:  []string{"query"},
+			names:  []string{""},
+			fields: []string{"id", "name", "user"},
+			depth:  2,
+		},
+		{
+			name:   "json request",
+			body:   `{"query": "mutation Reset($id: ID!) { reset(id: $id) { ok } }", "variables": {"id": 1}}`,
+			types:  []string{"mutation"},
+			names:  []string{"Reset"},
+			fields: []string{"ok", "reset"},
+			depth:  2,
+		},
+		{
+			name:   "aliases resolved",
+			body:   `{ a: flag { value } b: flag { value } }`,
+			types:  []string{"query"},
+			names:  []string{""},
+			fields: []string{"flag", "value"},
+			depth:  2,
+		},
+		{
+			name:   "fragments expanded",
+			body:   `query Q { user { ...F } } fragment F on User { secret { flag } }`,
+			types:  []string{"query"},
+			names:  []string{"Q"},
+			fields: []string{"flag", "secret", "user"},
+			depth:  3,
+		},
+		{
+			name:   "cyclic fragments",
+			body:   `{ ...A } fragment A on Q { a ...B } fragment B on Q { b ...A }`,
+			types:  []string{"query"},
+			names:  []string{""},
+			fields: []string{"a", "b"},
+			depth:  1,
+		},
+		{
+			name:   "operation name selects",
+			body:   `{"query": "query A { a } query B { b }", "operationName": "B"}`,
+			types:  []string{"query"},
+			names:  []string{"B"},
+			fields: []string{"b"},
+			depth:  1,
+		},
+		{
+			name:  "batch",
+			body:  `[{"query": "{ a }"}, {"query": "subscription S { s }"}]`,
+			types: []string{"query", "subscription"},
+			names: []string{"", "S"},
+			depth: 1,
+		},
+		{
+			name:   "directives and arguments skipped",
+			body:   `query ($x: Int = 1) @live { item(id: "}") @include(if: true) { id } }`,
+			types:  []string{"query"},
+			names:  []string{""},
+			fields: []string{"id", "item"},
+			depth:  2,
+		},
+		{
+			name:  "batch with a broken request",
+			body:  `[{"query": "{ a"}, {"query": "{ b }"}]`,
+			types: []string{"query"},
+			names: []string{""},
+			depth: 1,
+			err:   true,
+		},
+		{name: "unbalanced", body: `{ user { id }`, err: true},
+		{name: "not graphql", body: `hello`, err: true},
+		{name: "fragment only", body: `fragment F on User { id }`, err: true},
+		{name: "too deep", body: strings.Repeat("{ a ", maxGraphqlNesting+1) + strings.Repeat("}", maxGraphqlNesting+1), err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := ParseGraphql([]byte(tt.body))
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if err != nil && !errors.Is(err, errGraphqlSyntax) {
+				t.Errorf("err = %v, want %v", err, errGraphqlSyntax)
+			}
+			if len(ops) != len(tt.types) {
+				t.Fatalf("got %d operations, want %d", len(ops), len(tt.types))
+			}
+			depth := 0
+			for i, op := range ops {
+				if op.Type != tt.types[i] || op.Name != tt.names[i] {
+					t.Errorf("operation %d = %s %q, want %s %q", i, op.Type, op.Name, tt.types[i], tt.names[i])
+				}
+				depth = max(depth, op.Depth)
+			}
+			if depth != tt.depth {
+				t.Errorf("depth = %d, want %d", depth, tt.depth)
+			}
+			if tt.fields != nil {
+				fields := slices.Sorted(slices.Values(ops[0].Fields))
+				if !slices.Equal(fields, tt.fields) {
+					t.Errorf("fields = %v, want %v", fields, tt.fields)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// gRPC messages are framed as a compressed flag byte, a big-endian uint32 length and the message.
+const grpcFrameHeader = 5
+
+type grpcFrame struct {
+	compressed bool
+	data       []byte
+}
+
+func isGrpcContentType(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(contentType), "application/grpc")
+}
+
+// Splits body into complete frames; returns them and how many bytes they span.
+func parseGrpcFrames(body []byte) ([]grpcFrame, int) {
+	var frames []grpcFrame
+	used := 0
+	for len(body)-used >= grpcFrameHeader {
+		size := int(binary.BigEndian.Uint32(body[used+1:]))
+		end := used + grpcFrameHeader + size
+		if size < 0 || end > len(body) {
+			break
+		}
+		frames = append(frames, grpcFrame{body[used] == 1, body[used+grpcFrameHeader : end]})
+		used = end
+	}
+	return frames, used
+}
+
+func appendGrpcFrame(b []byte, f grpcFrame) []byte {
+	var hdr [grpcFrameHeader]byte
+	if f.compressed {
+		hdr[0] = 1
+	}
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(f.data)))
+	return append(append(b, hdr[:]...), f.data...)
+}
+
+// Message of a frame, decompressed per grpc-encoding; ok is false if it can't be decoded.
+func (f grpcFrame) message(encoding string) ([]byte, bool) {
+	if !f.compressed {
+		return f.data, true
+	}
+	encoding = normalizeEncoding(encoding)
+	if !isSupportedEncoding(encoding) {
+		return nil, false
+	}
+	msg, err := decodeBody(encoding, f.data)
+	return msg, err == nil
+}
+
+func (f grpcFrame) withMessage(encoding string, msg []byte) grpcFrame {
+	if !f.compressed {
+		return grpcFrame{data: msg}
+	}
+	data, err := encodeBody(normalizeEncoding(encoding), msg)
+	if err != nil {
+		return grpcFrame{data: msg}
+	}
+	return grpcFrame{compressed: true, data: data}
+}
+
+// Values of the length-delimited fields (bytes, strings, messages) at path, descending through
+// nested messages. Malformed messages yield what was parsed so far.
+func ProtoFields(msg []byte, path ...protowire.Number) [][]byte {
+	if len(path) == 0 {
+		return [][]byte{msg}
+	}
+	var out [][]byte
+	eachField(msg, func(num protowire.Number, v []byte) error {
+		if num == path[0] {
+			out = append(out, ProtoFields(v, path[1:]...)...)
+		}
+		return nil
+	})
+	return out
+}
+
+// Values of the varint fields (ints, bools, enums) at path.
+func ProtoVarints(msg []byte, path ...protowire.Number) []uint64 {
+	if len(path) == 0 {
+		return nil
+	}
+	var out []uint64
+	for _, parent := range ProtoFields(msg, path[:len(path)-1]...) {
+		b := parent
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				break
+			}
+			b = b[n:]
+			if typ == protowire.VarintType && num == path[len(path)-1] {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					break
+				}
+				out = append(out, v)
+			}
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				break
+			}
+			b = b[n:]
+		}
+	}
+	return out
+}
+
+// Re-encodes msg with every length-delimited field at path replaced by rewrite(value). Malformed
+// messages are returned unchanged.
+func ProtoRewrite(msg []byte, rewrite func([]byte) []byte, path ...protowire.Number) []byte {
+	if len(path) == 0 {
+		return rewrite(msg)
+	}
+	out := make([]byte, 0, len(msg))
+	b := msg
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return msg
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			return msg
+		}
+		if typ == protowire.BytesType && num == path[0] {
+			v, _ := protowire.ConsumeBytes(b[n:])
+			out = protowire.AppendTag(out, num, typ)
+			out = protowire.AppendBytes(out, ProtoRewrite(v, rewrite, path[1:]...))
+		} else {
+			out = append(out, b[:n+m]...)
+		}
+		b = b[n+m:]
+	}
+	return out
+}
+
+type grpcWhenState struct {
+	grpc bool
+	// set once the content type and method were checked
+	checked bool
+	// Bytes of the buffered body already checked
+	scanned int
+}
+
+// Matches gRPC requests to methods accepted by method (":path" is "/package.Service/Method") with a
+// request message satisfying match (nil matches on the method alone). The body is held back only
+// while a message is incomplete.
+func MatchGrpcRequest(method func(string) bool, match func(msg []byte) bool) func(*HttpWhenContext) bool {
+	return func(ctx *HttpWhenContext) bool {
+		s := State[grpcWhenState](ctx)
+		if !s.checked {
+			s.grpc = isGrpcContentType(ctx.GetRequestHeader("content-type")) && method(ctx.GetRequestHeader(":path"))
+			s.checked = true
+		}
+		if !s.grpc {
+			return false
+		}
+		if match == nil {
+			return true
+		}
+		if ctx.Stage != StageRequestBody || ctx.BodySize <= s.scanned {
+			return false
+		}
+
+		body, err := ctx.GetRequestBody(s.scanned, ctx.BodySize-s.scanned)
+		if err != nil {
+			return false
+		}
+		frames, used := parseGrpcFrames(body)
+		encoding := ctx.GetRequestHeader("grpc-encoding")
+		for _, f := range frames {
+			if msg, ok := f.message(encoding); ok && match(msg) {
+				return true
+			}
+		}
+		if used < len(body) && !ctx.End {
+			s.scanned += used
+			ctx.Pause()
+		} else {
+			s.scanned = 0
+		}
+		return false
+	}
+}
+
+type grpcDoState struct {
+	responseEncoding string
+}
+
+// Rewrites every message of the stream in one direction. Buffered data is forwarded as soon as it
+// ends on a message boundary.
+func doRewriteGrpc(isReq bool, rewrite func(msg []byte) []byte) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(isReq, !isReq)
+		s := State[grpcDoState](ctx)
+
+		var body []byte
+		var err error
+		var encoding string
+		switch {
+		case isReq && ctx.Stage == StageRequestBody:
+			body, err = ctx.GetRequestBody(0, ctx.BodySize)
+			encoding = ctx.GetRequestHeader("grpc-encoding")
+		case !isReq && ctx.Stage == StageResponseHeaders:
+			s.responseEncoding = ctx.GetResponseHeader("grpc-encoding")
+			return VerdictContinue
+		case !isReq && ctx.Stage == StageResponseBody:
+			body, err = ctx.GetResponseBody(0, ctx.BodySize)
+			encoding = s.responseEncoding
+		case isReq && ctx.Stage >= StageResponseHeaders:
+			return VerdictModified
+		default:
+			return VerdictContinue
+		}
+		if err != nil || len(body) == 0 {
+			return VerdictContinue
+		}
+
+		frames, used := parseGrpcFrames(body)
+		if used < len(body) && !ctx.End {
+			return VerdictPause
+		}
+		out := make([]byte, 0, len(body))
+		for _, f := range frames {
+			if msg, ok := f.message(encoding); ok {
+				f = f.withMessage(encoding, rewrite(msg))
+			}
+			out = appendGrpcFrame(out, f)
+		}
+		out = append(out, body[used:]...)
+		if isReq {
+			err = ctx.ReplaceRequestBody(out)
+		} else {
+			err = ctx.ReplaceResponseBody(out)
+		}
+		if err != nil {
+			ctx.LogWarn("failed to replace gRPC messages: " + err.Error())
+		}
+		return VerdictContinue
+	}
+}
+
+// Rewrites every request message of a matched gRPC stream.
+func DoRewriteGrpcRequest(rewrite func(msg []byte) []byte) func(*HttpDoContext) Verdict {
+	return doRewriteGrpc(true, rewrite)
+}
+
+// Rewrites every response message of a matched gRPC stream.
+func DoRewriteGrpcResponse(rewrite func(msg []byte) []byte) func(*HttpDoContext) Verdict {
+	return doRewriteGrpc(false, rewrite)
+}
+
+// Rejects a gRPC call with PERMISSION_DENIED as a trailers-only response.
+func DoGrpcBlock(ctx *HttpDoContext) Verdict {
+	headers := [][2]string{
+		{"content-type", "application/grpc"},
+		{"grpc-status", "7"},
+		{"grpc-message", "blocked"},
+	}
+	reply(200, headers, nil)
+	return VerdictBlocked
+}
@@ -0,0 +1,70 @@
+package main
+
+import "sort"
+
+// Guards are features answering or letting through a stream before its interceptors run (admin
+// API, bans, body caps...). A guard reports whether it handled the stream, after setting its final
+// verdict. Each feature registers its guard from its own file.
+
+// Order of the guards checked once, when a stream starts.
+const (
+	guardMetrics = iota
+	guardAdminApi
+	guardAdminBypass
+	guardMaintenance
+	guardAllowList
+	guardBan
+	guardChaos
+)
+
+// Order of the guards checked at every stage, before dispatching.
+const (
+	guardSlowloris = iota
+	guardBodySize
+	guardKnownPayload
+)
+
+type startGuard struct {
+	order int
+	check func(*httpCtx) bool
+}
+
+type stageGuard struct {
+	order int
+	check func(h *httpCtx, stage HttpStage, n int, end bool) bool
+}
+
+var (
+	startGuards []startGuard
+	stageGuards []stageGuard
+)
+
+func registerStartGuard(order int, check func(*httpCtx) bool) {
+	startGuards = append(startGuards, startGuard{order, check})
+	sort.SliceStable(startGuards, func(i, j int) bool { return startGuards[i].order < startGuards[j].order })
+}
+
+func registerStageGuard(order int, check func(h *httpCtx, stage HttpStage, n int, end bool) bool) {
+	stageGuards = append(stageGuards, stageGuard{order, check})
+	sort.SliceStable(stageGuards, func(i, j int) bool { return stageGuards[i].order < stageGuards[j].order })
+}
+
+// Reports whether a start guard handled the stream.
+func (h *httpCtx) guardStart() bool {
+	for _, g := range startGuards {
+		if g.check(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a stage guard handled the stage.
+func (h *httpCtx) guardStage(stage HttpStage, n int, end bool) bool {
+	for _, g := range stageGuards {
+		if g.check(h, stage, n, end) {
+			return true
+		}
+	}
+	return false
+}
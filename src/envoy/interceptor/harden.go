@@ -0,0 +1,50 @@
+package main
+
+// HeaderProfile is a set of security headers added to responses.
+type HeaderProfile struct {
+	Headers [][2]string
+	// Replace headers the service already sets (otherwise only missing ones are added)
+	Override bool
+	// Paths matching any of these are left untouched
+	Exclude []func(path string) bool
+}
+
+// Conservative defaults which rarely break CTF services.
+var DefaultHardening = HeaderProfile{
+	Headers: [][2]string{
+		{"x-frame-options", "DENY"},
+		{"x-content-type-options", "nosniff"},
+		{"referrer-policy", "no-referrer"},
+		{"content-security-policy", "default-src 'self'; object-src 'none'; frame-ancestors 'none'; base-uri 'none'"},
+	},
+}
+
+// Adds the profile headers to the response unless the request path is excluded.
+func DoHardenResponseHeaders(profile HeaderProfile) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		if ctx.Stage != StageResponseHeaders {
+			return VerdictContinue
+		}
+		path := ctx.GetRequestHeader(":path")
+		for _, exclude := range profile.Exclude {
+			if exclude(path) {
+				return VerdictModified
+			}
+		}
+		for _, h := range profile.Headers {
+			if profile.Override || ctx.GetResponseHeader(h[0]) == "" {
+				ctx.SetResponseHeader(h[0], h[1])
+			}
+		}
+		return VerdictModified
+	}
+}
+
+// Hardens all responses of a port. It matches every response, so with MatchFirst register it last.
+// Matching at the response keeps request framing untouched.
+func RegisterResponseHardening(port int64, profile HeaderProfile) {
+	RegisterHttpInterceptor(port, "harden response headers", func(*HttpWhenContext) bool {
+		return true
+	}, DoHardenResponseHeaders(profile), WithStages(StageResponseHeaders), WithoutFuse())
+}
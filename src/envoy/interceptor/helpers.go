@@ -10,18 +10,66 @@ type Matcher struct {
 	Path    func(string) bool
 	Method  func(string) bool
 	Headers map[string]string
-	Body    func([]byte) bool
+	// Called once with the complete body; pauses the stream until the body is fully buffered
+	Body func([]byte) bool
+	// Called for every chunk as it arrives, prefixed with the tail of the previous chunk
+	BodyScan func([]byte) bool
+	// Bytes of the previous chunk kept in front of the next one (defaultBodyScanOverlap if 0)
+	BodyScanOverlap int
+	// Applied to path, method, header values (both sides) and body before matching
+	Normalize Normalization
 }
 
 type MatcherResult struct {
-	Path    bool
-	Method  bool
-	Headers bool
-	Body    bool
+	Path     bool
+	Method   bool
+	Headers  bool
+	Body     bool
+	BodyScan bool
+
+	scanner bodyScanner
+	// request Content-Encoding; bodies are decoded before matching
+	encoding string
+	// buffered request body size, for matching at the trailers stage
+	bodySize int
+	started  bool
 }
 
 func (r MatcherResult) All() bool {
-	return r.Path && r.Method && r.Headers && r.Body
+	return r.Path && r.Method && r.Headers && r.Body && r.BodyScan
+}
+
+const defaultBodyScanOverlap = 256
+
+// Feeds body chunks to a scan function as they arrive without buffering the whole body.
+// The last overlap bytes of each window are kept, so matches spanning chunk boundaries are found.
+type bodyScanner struct {
+	overlap int
+	reader  BodyReader
+	tail    []byte
+}
+
+// Returns the previous tail followed by the body bytes not scanned yet.
+func (s *bodyScanner) next(offset, size int, get func(start, size int) ([]byte, error)) ([]byte, error) {
+	chunk, err := s.reader.Next(offset, size, get)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunk) == 0 {
+		return s.tail, nil
+	}
+
+	window := append(append([]byte{}, s.tail...), chunk...)
+	overlap := s.overlap
+	if overlap <= 0 {
+		overlap = defaultBodyScanOverlap
+	}
+	if len(window) > overlap {
+		s.tail = append(s.tail[:0], window[len(window)-overlap:]...)
+	} else {
+		s.tail = append(s.tail[:0], window...)
+	}
+	return window, nil
 }
 
 func MatchPrefix(prefix string) func(string) bool {
@@ -38,107 +86,247 @@ func MatchMethod(expected string) func(string) bool {
 
 func MatchHttpRequest(matcher Matcher) func(ctx *HttpWhenContext) bool {
 	return func(ctx *HttpWhenContext) bool {
-		if ctx.Data == nil {
-			ctx.Data = &MatcherResult{
-				Path:    matcher.Path == nil,
-				Method:  matcher.Method == nil,
-				Headers: matcher.Headers == nil,
-				Body:    matcher.Body == nil,
+		res := State[MatcherResult](ctx)
+		if !res.started {
+			*res = MatcherResult{
+				Path:     matcher.Path == nil,
+				Method:   matcher.Method == nil,
+				Headers:  matcher.Headers == nil,
+				Body:     matcher.Body == nil,
+				BodyScan: matcher.BodyScan == nil,
+				scanner:  bodyScanner{overlap: matcher.BodyScanOverlap},
+				started:  true,
 			}
 		}
-		var res = ctx.Data.(*MatcherResult)
 		if ctx.Stage == StageRequestHeaders {
+			norm := matcher.Normalize
 			if !res.Path && matcher.Path != nil {
-				res.Path = matcher.Path(ctx.GetRequestHeader(":path"))
+				path := ctx.GetRequestHeader(":path")
+				if norm&NormalizeURLPath != 0 {
+					path = NormalizePath(path)
+				}
+				res.Path = matcher.Path(NormalizeString(path, norm))
 			}
 			if !res.Method && matcher.Method != nil {
-				res.Method = matcher.Method(ctx.GetRequestHeader(":method"))
+				res.Method = matcher.Method(NormalizeString(ctx.GetRequestHeader(":method"), norm))
 			}
 			if !res.Headers && matcher.Headers != nil {
 				res.Headers = true
 				for k, v := range matcher.Headers {
-					res.Headers = res.Headers && (ctx.GetRequestHeader(k) == v)
+					res.Headers = res.Headers && (NormalizeString(ctx.GetRequestHeader(k), norm) == NormalizeString(v, norm))
 				}
 			}
+			res.encoding = normalizeEncoding(ctx.GetRequestHeader("content-encoding"))
 		}
 		if ctx.Stage == StageRequestBody {
-			if !res.Body && matcher.Body != nil {
+			// Compressed chunks can't be scanned independently, so encoded bodies are scanned as a whole
+			if !res.BodyScan && matcher.BodyScan != nil && res.encoding == "" {
+				window, err := res.scanner.next(ctx.BodyOffset, ctx.BodySize, ctx.GetRequestBody)
+				if err == nil {
+					res.BodyScan = matcher.BodyScan(Normalize(window, matcher.Normalize))
+				}
+			}
+			if res.needsFullBody(matcher) {
 				if !ctx.End {
+					res.bodySize = ctx.BodySize
 					ctx.Pause()
 					return false
 				}
-				body, err := ctx.GetRequestBody(0, ctx.BodySize)
-				if err != nil {
-					return false
-				}
-				res.Body = matcher.Body(body)
+				res.matchFullBody(ctx, matcher, ctx.BodySize)
 			}
 		}
+		// With trailers the last body chunk doesn't carry End, so the buffered body is complete here
+		if ctx.Stage == StageRequestTrailers && res.needsFullBody(matcher) && res.bodySize > 0 {
+			res.matchFullBody(ctx, matcher, res.bodySize)
+		}
 		return res.All()
 	}
 }
 
-func ModifyHttpResponseBody(modifyFunc func([]byte) []byte) func(ctx *HttpDoContext) bool {
-	return func(ctx *HttpDoContext) bool {
-		if ctx.Stage == StageResponseHeaders {
-			ctx.DelResponseHeader("content-length")
+// Content coding of a response being modified.
+type responseCoding struct {
+	// client Accept-Encoding, if seen
+	accept     string
+	seenAccept bool
+	// response Content-Encoding
+	encoding string
+	// re-encode the modified body with encoding
+	recompress bool
+	// Content-Encoding was removed for a client not accepting it
+	stripped bool
+	// buffered body size, for modifying at the trailers stage
+	bodySize int
+}
+
+// Tracks response coding so modifiers work on decoded bytes. Upstream is asked only for codings
+// which can be decoded; the modified body is re-encoded if the client accepts the original coding.
+func trackResponseCoding(ctx *HttpDoContext) *responseCoding {
+	rc := State[responseCoding](ctx)
+	rc.track(ctx)
+	return rc
+}
+
+// trackResponseCoding for Do functions keeping a responseCoding in their own Data.
+func (rc *responseCoding) track(ctx *HttpDoContext) {
+	switch ctx.Stage {
+	case StageRequestHeaders:
+		rc.accept = ctx.GetRequestHeader("accept-encoding")
+		rc.seenAccept = true
+		if rc.accept != "" {
+			if filtered := filterAcceptEncoding(rc.accept); filtered != "" {
+				ctx.SetRequestHeader("accept-encoding", filtered)
+			} else {
+				ctx.SetRequestHeader("accept-encoding", "identity")
+			}
+		}
+	case StageResponseHeaders:
+		rc.encoding = normalizeEncoding(ctx.GetResponseHeader("content-encoding"))
+		if rc.encoding == "" {
+			break
+		}
+		if !isSupportedEncoding(rc.encoding) {
+			ctx.LogWarn("cannot decode response content-encoding " + rc.encoding + ", modifying raw bytes")
+			break
+		}
+		rc.recompress = !rc.seenAccept || acceptsEncoding(rc.accept, rc.encoding)
+		if !rc.recompress {
 			ctx.DelResponseHeader("content-encoding")
+			rc.stripped = true
+		}
+	}
+}
+
+func (res *MatcherResult) needsFullBody(matcher Matcher) bool {
+	return (!res.Body && matcher.Body != nil) || (!res.BodyScan && matcher.BodyScan != nil && res.encoding != "")
+}
+
+func (res *MatcherResult) matchFullBody(ctx *HttpWhenContext, matcher Matcher, size int) {
+	body, err := ctx.GetRequestBody(0, size)
+	if err != nil {
+		return
+	}
+	if decoded, err := decodeBody(res.encoding, body); err == nil {
+		body = decoded
+	} else {
+		ctx.LogInfo("failed to decode request body, matching raw bytes: " + err.Error())
+	}
+	body = Normalize(body, matcher.Normalize)
+	if !res.Body && matcher.Body != nil {
+		res.Body = matcher.Body(body)
+	}
+	if !res.BodyScan && matcher.BodyScan != nil {
+		res.BodyScan = matcher.BodyScan(body)
+	}
+}
+
+func ModifyHttpResponseBody(modifyFunc func([]byte) []byte) func(ctx *HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, true)
+		rc := trackResponseCoding(ctx)
+
+		if ctx.Stage == StageResponseHeaders {
 			proxywasm.AddHttpResponseTrailer("x-blocked", "1")
 		}
 
 		if ctx.Stage == StageResponseBody && !ctx.End {
 			ctx.LogInfo("buffering response body")
-			ctx.Pause()
-			return false
+			rc.bodySize = ctx.BodySize
+			return VerdictPause
 		}
 
-		if ctx.Stage == StageResponseBody && ctx.End {
-			if b, err := ctx.GetResponseBody(0, ctx.BodySize); err == nil {
-				newBody := modifyFunc(b)
+		bodySize := ctx.BodySize
+		if ctx.Stage == StageResponseTrailers {
+			bodySize = rc.bodySize
+		}
+		if (ctx.Stage == StageResponseBody && ctx.End) || (ctx.Stage == StageResponseTrailers && bodySize > 0) {
+			if b, err := ctx.GetResponseBody(0, bodySize); err == nil {
+				decoded, ok := rc.decode(ctx, b)
+				if !ok {
+					return rc.withhold(ctx)
+				}
+				newBody := rc.encode(ctx, modifyFunc(decoded))
 				err = ctx.ReplaceResponseBody(newBody)
 				if err != nil {
 					ctx.LogInfo("failed to replace response body: " + err.Error())
 				}
 			}
 			ctx.LogInfo("mofidied response body")
-			return true
+			return VerdictModified
 		}
 
-		return false
+		return VerdictContinue
+	}
+}
+
+// Returns the decoded body. Fails if it can't be decoded after Content-Encoding was removed: the
+// raw bytes would then reach the client as garbage, so the response must be withheld.
+func (rc *responseCoding) decode(ctx *HttpDoContext, body []byte) ([]byte, bool) {
+	if rc.encoding == "" || !isSupportedEncoding(rc.encoding) {
+		return body, true
+	}
+	decoded, err := decodeBody(rc.encoding, body)
+	if err != nil {
+		ctx.LogWarn("failed to decode response body: " + err.Error())
+		rc.recompress = false
+		return body, !rc.stripped
+	}
+	return decoded, true
+}
+
+// Replaces a response which can't be decoded with 502; Envoy resets the stream instead if its
+// headers were already sent.
+func (rc *responseCoding) withhold(ctx *HttpDoContext) Verdict {
+	reply(502, [][2]string{{"content-type", "text/plain"}}, []byte("response withheld\n"))
+	return VerdictBlocked
+}
+
+func (rc *responseCoding) encode(ctx *HttpDoContext, body []byte) []byte {
+	if !rc.recompress {
+		return body
 	}
+	encoded, err := encodeBody(rc.encoding, body)
+	if err != nil {
+		ctx.LogWarn("failed to encode response body: " + err.Error())
+		return body
+	}
+	return encoded
 }
 
-func DoReplaceHttpResponseBody(newBody []byte) func(ctx *HttpDoContext) bool {
+func DoReplaceHttpResponseBody(newBody []byte) func(ctx *HttpDoContext) Verdict {
 	return ModifyHttpResponseBody(func(_ []byte) []byte {
 		return newBody
 	})
 }
 
-func DoHttpPause(ctx *HttpDoContext) bool {
-	proxywasm.ReplaceHttpRequestTrailer("x-blocked", "1")
-	ctx.Pause()
+// Answers the stream with a local response; reports whether it was sent.
+func reply(status uint32, headers [][2]string, body []byte) bool {
+	if err := proxywasm.SendHttpResponse(status, headers, body, -1); err != nil {
+		proxywasm.LogWarn("failed to send HTTP response: " + err.Error())
+		return false
+	}
 	return true
 }
 
-func DoHttpBlock(ctx *HttpDoContext) bool {
-	if ctx.Data == nil {
+func DoHttpPause(ctx *HttpDoContext) Verdict {
+	proxywasm.ReplaceHttpRequestTrailer("x-blocked", "1")
+	return VerdictBlocked
+}
+
+func DoHttpBlock(ctx *HttpDoContext) Verdict {
+	if marked := State[bool](ctx); !*marked {
 		proxywasm.ReplaceHttpRequestTrailer("x-blocked", "1")
-		ctx.Data = ""
+		*marked = true
 	}
 
 	if ctx.Stage != StageResponseHeaders {
-		return false
+		return VerdictContinue
 	}
 
 	// If call before StageResponseHeaders, we'll pause request
-	err := proxywasm.SendHttpResponse(418, nil, []byte("hey you"), -1)
-	if err != nil {
-		ctx.LogInfo("Failed to send HTTP response: " + err.Error())
-	}
+	reply(418, nil, []byte("hey you"))
 
 	// Required to avoid any further processing and passing request to the upstream
-	ctx.Pause()
-	return true
+	return VerdictBlocked
 }
 
 var bomb = []byte{
@@ -255,27 +443,21 @@ var bomb = []byte{
 	0xf6, 0x37, 0x45, 0x98, 0x81, 0xa0, 0x89, 0x67, 0x00, 0x00,
 }
 
-func DoHttpBomb(ctx *HttpDoContext) bool {
+func DoHttpBomb(ctx *HttpDoContext) Verdict {
 	proxywasm.ReplaceHttpRequestTrailer("x-blocked", "1")
 
 	if ctx.Stage != StageResponseHeaders {
-		return false
+		return VerdictContinue
 	}
 
-	err := proxywasm.SendHttpResponse(200, [][2]string{
-		{"content-encoding", "gzip, gzip, gzip"},
-	}, bomb, -1)
-	if err != nil {
-		ctx.LogInfo("Failed to send HTTP response: " + err.Error())
-	}
+	reply(200, [][2]string{{"content-encoding", "gzip, gzip, gzip"}}, bomb)
 
-	ctx.Pause()
-	return true
+	return VerdictBlocked
 }
 
-func DoTcpBlock(ctx *TcpDoContext) bool {
+func DoTcpBlock(ctx *TcpDoContext) Verdict {
 	ctx.MarkBlocked()
 	proxywasm.CloseDownstream()
 	proxywasm.CloseUpstream()
-	return false
+	return VerdictDropped
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Honeyflags are fake flags handed out by decoys, each unique and recorded with the source it was
+// issued to. Seeing one again in a request proves where the attacker got it.
+
+// Replaced by a new honeyflag in decoy bodies.
+const HoneyflagPlaceholder = "{{honeyflag}}"
+
+const honeyflagAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+var (
+	newHoneyflag     = func() string { return randomString(honeyflagAlphabet, 31) + "=" }
+	honeyflagPattern = regexp.MustCompile(`[A-Z0-9]{31}=`)
+)
+
+// Sets how honeyflags are generated and found in requests, to match the game's flag format.
+func SetHoneyflagFormat(generate func() string, pattern *regexp.Regexp) {
+	newHoneyflag = generate
+	honeyflagPattern = pattern
+}
+
+// Where a honeyflag went, kept in shared data by flag.
+type HoneyflagIssue struct {
+	Source string    `json:"source"`
+	Port   int64     `json:"port"`
+	Path   string    `json:"path"`
+	Time   time.Time `json:"time"`
+}
+
+// Every request to a decoy issues a flag, so issues are bounded: an old flag is forgotten once a new
+// one takes its slot.
+var honeyflagKV = NewBoundedKV("honeyflag", 65536)
+
+func randomString(alphabet string, n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
+
+// Generates a honeyflag and records it as issued to the stream's source.
+func issueHoneyflag(ctx *HttpDoContext) string {
+	flag := newHoneyflag()
+	issue := HoneyflagIssue{Source: ctx.SourceIP, Port: ctx.Port, Path: ctx.GetRequestHeader(":path"), Time: Now()}
+	b, _ := json.Marshal(issue)
+	if err := honeyflagKV.Set(flag, b); err != nil {
+		ctx.LogWarn("failed to record honeyflag: " + err.Error())
+	}
+	incrCounter("interceptor_honeyflags_issued")
+	ctx.LogInfo("issued honeyflag " + flag + " to " + ctx.SourceIP)
+	return flag
+}
+
+// Returns where flag was issued, if it is a honeyflag.
+func LookupHoneyflag(flag string) (HoneyflagIssue, bool) {
+	var issue HoneyflagIssue
+	b, err := honeyflagKV.Get(flag)
+	if err != nil || b == nil || json.Unmarshal(b, &issue) != nil {
+		return issue, false
+	}
+	return issue, true
+}
+
+// Answers with a decoy response, HoneyflagPlaceholder in body replaced by a new honeyflag.
+func DoServeHoneyflag(status uint32, headers [][2]string, body []byte) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		flag := []byte(issueHoneyflag(ctx))
+		reply(status, headers, bytes.ReplaceAll(body, []byte(HoneyflagPlaceholder), flag))
+		return VerdictBlocked
+	}
+}
+
+// Replaces HoneyflagPlaceholder in the response body by a new honeyflag, e.g. for decoy data
+// planted in a service. The response body is buffered.
+func DoInjectHoneyflag(ctx *HttpDoContext) Verdict {
+	ctx.ReplacesBodies(false, true)
+	rc := trackResponseCoding(ctx)
+
+	switch {
+	case ctx.Stage < StageResponseBody:
+		return VerdictContinue
+	case ctx.Stage == StageResponseBody && !ctx.End:
+		rc.bodySize = ctx.BodySize
+		return VerdictPause
+	}
+
+	bodySize := ctx.BodySize
+	if ctx.Stage == StageResponseTrailers {
+		bodySize = rc.bodySize
+	}
+	if bodySize == 0 {
+		return VerdictModified
+	}
+	raw, err := ctx.GetResponseBody(0, bodySize)
+	if err != nil {
+		ctx.LogWarn("failed to read response body: " + err.Error())
+		return VerdictModified
+	}
+	body, ok := rc.decode(ctx, raw)
+	if !ok {
+		return rc.withhold(ctx)
+	}
+	if !bytes.Contains(body, []byte(HoneyflagPlaceholder)) {
+		return VerdictModified
+	}
+	body = bytes.ReplaceAll(body, []byte(HoneyflagPlaceholder), []byte(issueHoneyflag(ctx)))
+	if err := ctx.ReplaceResponseBody(rc.encode(ctx, body)); err != nil {
+		ctx.LogWarn("failed to replace response body: " + err.Error())
+	}
+	return VerdictModified
+}
+
+type honeyflagWatch struct {
+	scanner bodyScanner
+	seen    map[string]bool
+}
+
+// Reports honeyflags found in the request (path, headers, body) to the log and as "honeyflag"
+// events (webhook, TopicEvents).
+func (w *honeyflagWatch) check(ctx *HttpWhenContext, port int64, data []byte) {
+	for _, m := range honeyflagPattern.FindAll(data, -1) {
+		flag := string(m)
+		if w.seen[flag] {
+			continue
+		}
+		w.seen[flag] = true
+		issue, ok := LookupHoneyflag(flag)
+		if !ok {
+			continue
+		}
+		incrCounter("interceptor_honeyflags_seen")
+		detail := fmt.Sprintf("honeyflag %s issued to %s at %s (port %d %s)", flag, issue.Source, issue.Time.Format(time.RFC3339), issue.Port, issue.Path)
+		ctx.LogInfo(detail + " seen from " + ctx.SourceIP)
+		EmitEvent(Event{
+			Kind:        "honeyflag",
+			Interceptor: "honeyflag tracking",
+			Port:        port,
+			Source:      ctx.SourceIP,
+			Request:     ctx.GetRequestHeader(":method") + " " + ctx.GetRequestHeader(":path"),
+			Detail:      detail,
+		})
+	}
+}
+
+// Watches requests on the ports for honeyflags issued earlier. It only reports, never matches, so
+// other interceptors are not affected. Bodies are scanned as they stream.
+func RegisterHoneyflagTracking(ports ...int64) {
+	for _, port := range ports {
+		port := port
+		RegisterHttpInterceptor(port, "honeyflag tracking", func(ctx *HttpWhenContext) bool {
+			w := State[honeyflagWatch](ctx)
+			if w.seen == nil {
+				*w = honeyflagWatch{scanner: bodyScanner{overlap: 128}, seen: map[string]bool{}}
+			}
+			switch ctx.Stage {
+			case StageRequestHeaders:
+				headers, err := proxywasm.GetHttpRequestHeaders()
+				if err != nil {
+					ctx.LogInfo("failed to get request headers: " + err.Error())
+				}
+				for _, h := range headers {
+					w.check(ctx, port, []byte(h[1]))
+				}
+			case StageRequestBody:
+				if window, err := w.scanner.next(ctx.BodyOffset, ctx.BodySize, ctx.GetRequestBody); err == nil {
+					w.check(ctx, port, window)
+				}
+			}
+			return false
+		}, nil, WithStages(StageRequestHeaders, StageRequestBody))
+	}
+}
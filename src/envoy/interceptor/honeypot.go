@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Ban applied on the first honeypot hit of a source; it doubles with every further hit up to honeypotMaxBan.
+var (
+	honeypotBaseBan = time.Minute
+	honeypotMaxBan  = time.Hour
+)
+
+// Sets the ban durations of honeypot hits.
+func SetHoneypotBan(base, max time.Duration) {
+	honeypotBaseBan = base
+	honeypotMaxBan = max
+}
+
+// Response served for honeypot paths: looks like a real file so scanners keep going.
+var (
+	honeypotStatus  uint32 = 200
+	honeypotHeaders        = [][2]string{{"content-type", "application/octet-stream"}}
+	honeypotBody           = []byte("PK\x03\x04\x14\x00\x00\x00\x08\x00")
+)
+
+// Sets the decoy response served for honeypot paths.
+func SetHoneypotDecoy(status uint32, headers [][2]string, body []byte) {
+	honeypotStatus = status
+	honeypotHeaders = headers
+	honeypotBody = body
+}
+
+// Registers decoy paths on a port: requests for them (or anything below them) get the decoy response
+// and the source gets banned, longer with every hit.
+func RegisterHoneypot(port int64, paths ...string) {
+	RegisterHttpInterceptor(port, "honeypot "+strings.Join(paths, ","), func(ctx *HttpWhenContext) bool {
+		path, _, _ := strings.Cut(ctx.GetRequestHeader(":path"), "?")
+		for _, p := range paths {
+			if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+				return true
+			}
+		}
+		return false
+	}, doHoneypot, WithStages(StageRequestHeaders))
+}
+
+var honeypotKV = NewKV("honeypot")
+
+func doHoneypot(ctx *HttpDoContext) Verdict {
+	hits, err := honeypotKV.Increment(sourceKey(ctx.SourceIP), 1)
+	if err != nil {
+		ctx.LogWarn("failed to record honeypot hit: " + err.Error())
+		hits = 1
+	}
+	incrCounter("interceptor_honeypot_hits")
+
+	ban := honeypotBaseBan
+	for i := int64(1); i < hits && ban < honeypotMaxBan; i++ {
+		ban *= 2
+	}
+	if ban > honeypotMaxBan {
+		ban = honeypotMaxBan
+	}
+	ctx.LogInfo(fmt.Sprintf("honeypot hit source=%s path=%s hits=%d", ctx.SourceIP, ctx.GetRequestHeader(":path"), hits))
+	if err := BanSource(ctx.SourceIP, ban); err != nil {
+		ctx.LogWarn("failed to ban source: " + err.Error())
+	}
+
+	reply(honeypotStatus, honeypotHeaders, honeypotBody)
+	return VerdictBlocked
+}
@@ -0,0 +1,6 @@
+//go:build !wasip1
+
+package main
+
+// Native builds only run unit tests: there is no proxy to register with, so init does nothing.
+const hosted = false
@@ -0,0 +1,6 @@
+//go:build wasip1
+
+package main
+
+// Whether the module runs in a proxy; init only registers with one then.
+const hosted = true
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// IdempotencyGuard rejects repeats of a state-changing request within a window: double submits
+// (redeeming the same voucher twice) and client retries hitting fragile services alike. Keys are
+// scoped by source, so clients can't collide with each other. Concurrent first requests on
+// different workers may both pass, as a missing key can't be created atomically.
+type IdempotencyGuard struct {
+	// Unique name, used for shared data keys
+	Name string
+	// Key of a request ("" to let it through), e.g. KeyIdempotencyHeader or KeyRequestHash
+	Key KeyExtractor
+	// How long a key is remembered (10s if 0)
+	Window time.Duration
+	// Methods guarded (POST, PUT, PATCH and DELETE if nil)
+	Methods []string
+}
+
+type idempotencyState struct {
+	bodySize int
+	done     bool
+}
+
+var (
+	idempotencyKV = NewBoundedKV("idempotency", 65536)
+	// Keys requests by their Idempotency-Key header.
+	KeyIdempotencyHeader = KeyHeader("idempotency-key")
+	// Keys requests by a hash of method, path and body, for clients sending no key.
+	KeyRequestHash = KeyExtractor{
+		NeedsBody: true,
+		Extract: func(ctx *HttpWhenContext, body []byte) string {
+			h := sha256.New()
+			h.Write([]byte(ctx.GetRequestHeader(":method") + " " + ctx.GetRequestHeader(":path") + "\n"))
+			h.Write(body)
+			return hex.EncodeToString(h.Sum(nil))
+		},
+	}
+)
+
+var defaultIdempotentMethods = []string{"POST", "PUT", "PATCH", "DELETE"}
+
+func (g IdempotencyGuard) window() time.Duration {
+	if g.Window <= 0 {
+		return 10 * time.Second
+	}
+	return g.Window
+}
+
+// Registers an idempotency guard on a port; repeated requests get 409.
+func RegisterIdempotencyGuard(port int64, g IdempotencyGuard) {
+	methods := g.Methods
+	if methods == nil {
+		methods = defaultIdempotentMethods
+	}
+	RegisterHttpInterceptor(port, "idempotency "+g.Name, func(ctx *HttpWhenContext) bool {
+		state := State[idempotencyState](ctx)
+		if state.done {
+			return false
+		}
+		if ctx.Stage == StageRequestHeaders && !containsString(methods, ctx.GetRequestHeader(":method")) {
+			state.done = true
+			return false
+		}
+		key, ok := g.Key.extract(ctx, &state.bodySize)
+		if !ok {
+			return false
+		}
+		state.done = true
+		return key != "" && g.seen(key, ctx)
+	}, DoDuplicateRequest, WithStages(StageRequestHeaders, StageRequestBody, StageRequestTrailers))
+}
+
+// Records the key and reports whether it was already seen within the window. Not atomic across
+// workers for a key that is not stored yet.
+func (g IdempotencyGuard) seen(key string, ctx *HttpWhenContext) bool {
+	sum := sha256.Sum256([]byte(sourceKey(ctx.SourceIP) + "\n" + key))
+	now := Now().UnixNano()
+	duplicate := false
+	_, err := idempotencyKV.Update(g.Name+"/"+hex.EncodeToString(sum[:16]), func(old []byte) []byte {
+		if first := decodeInt64(old); first != 0 && now-first < int64(g.window()) {
+			duplicate = true
+			return old
+		}
+		return encodeInt64(now)
+	})
+	if err != nil {
+		ctx.LogInfo("failed to record idempotency key: " + err.Error())
+		return false
+	}
+	if duplicate {
+		ctx.LogInfo("duplicate request key=" + key)
+	}
+	return duplicate
+}
+
+// Rejects the stream with 409.
+func DoDuplicateRequest(ctx *HttpDoContext) Verdict {
+	reply(409, nil, []byte("duplicate request"))
+	return VerdictBlocked
+}
@@ -11,30 +11,65 @@ import (
 const (
 	StageRequestHeaders HttpStage = iota
 	StageRequestBody
+	StageRequestTrailers
 	StageResponseHeaders
 	StageResponseBody
+	StageResponseTrailers
 )
 
 // Pause makes the current hook return ActionPause (caller should then expect a
 // re-entry with more data or with End=true).
 func (c *HttpWhenContext) Pause() { c.resultAction = types.ActionPause }
 
-// Pause makes the current hook return ActionPause (caller should then expect a
-// re-entry with more data or with End=true).
-func (c *HttpDoContext) Pause() { c.resultAction = types.ActionPause }
+// MatchMode controls how many interceptors may act on a single stream.
+type MatchMode int
+
+const (
+	// Only the first interceptor whose When matches runs its Do; the rest are ignored for the stream.
+	MatchFirst MatchMode = iota
+	// Every interceptor whose When matches runs its Do; verdicts combine by the port's VerdictPolicy.
+	MatchAll
+)
 
-// Interceptor registry port -> []HttpInterceptor
-var httpReg = map[int64][]HttpInterceptor{}
+var httpMatchMode = MatchFirst
 
-// Registers an interceptor for a service port
-func RegisterHttpInterceptor(port int64, name string, when func(*HttpWhenContext) bool, do func(*HttpDoContext) bool) {
+// Sets how matching HTTP interceptors are executed.
+func SetHttpMatchMode(mode MatchMode) {
+	httpMatchMode = mode
+}
+
+// Interceptor registry (direction, port) -> []HttpInterceptor
+var httpReg = map[regKey][]HttpInterceptor{}
+
+// Registers an interceptor for a service port (inbound unless WithDirection is given)
+func RegisterHttpInterceptor(port int64, name string, when func(*HttpWhenContext) bool, do func(*HttpDoContext) Verdict, opts ...HttpInterceptorOption) {
 	i := HttpInterceptor{
 		Name: name,
 		When: when,
 		Do:   do,
 	}
-	httpReg[port] = append(httpReg[port], i)
-	proxywasm.LogInfo(fmt.Sprintf("registered http interceptor name=%s port=%d", name, port))
+	for _, opt := range opts {
+		opt(&i)
+	}
+	addHttpInterceptor(port, i)
+}
+
+// Adds an interceptor to the registry; exceptions go before the port's other interceptors.
+func addHttpInterceptor(port int64, i HttpInterceptor) {
+	i.stages = makeStageMask(i.Stages)
+	key := regKey{i.Direction, port}
+	ints := append(httpReg[key], i)
+	if i.isException() {
+		at := 0
+		for at < len(ints)-1 && ints[at].isException() {
+			at++
+		}
+		copy(ints[at+1:], ints[at:len(ints)-1])
+		ints[at] = i
+	}
+	httpReg[key] = ints
+	updateStageInterest(key)
+	proxywasm.LogInfo(fmt.Sprintf("registered http interceptor name=%s port=%d direction=%s", i.Name, port, i.Direction))
 }
 
 func (h *httpCtx) OnHttpRequestHeaders(n int, end bool) types.Action {
@@ -43,161 +78,357 @@ func (h *httpCtx) OnHttpRequestHeaders(n int, end bool) types.Action {
 func (h *httpCtx) OnHttpRequestBody(n int, end bool) types.Action {
 	return h.run(StageRequestBody, n, end, true)
 }
+func (h *httpCtx) OnHttpRequestTrailers(n int) types.Action {
+	return h.run(StageRequestTrailers, n, true, true)
+}
 func (h *httpCtx) OnHttpResponseHeaders(n int, end bool) types.Action {
 	return h.run(StageResponseHeaders, n, end, false)
 }
 func (h *httpCtx) OnHttpResponseBody(n int, end bool) types.Action {
 	return h.run(StageResponseBody, n, end, false)
 }
+func (h *httpCtx) OnHttpResponseTrailers(n int) types.Action {
+	return h.run(StageResponseTrailers, n, true, false)
+}
+
+func (h *httpCtx) run(stage HttpStage, n int, end bool, isReq bool) types.Action {
+	if h.startedAt.IsZero() {
+		h.startedAt = Now()
+		h.stripUpstreamOverride()
+		h.ensureRequestID()
+		h.tls = h.readTlsInfo()
+		if h.guardStart() {
+			h.lastStage, h.lastSize = stage, n
+			h.lastVerdict = h.verdict
+			return h.verdict.action()
+		}
+		h.countPath()
+	}
+	h.lastStage, h.lastSize = stage, n
+	if stage == StageResponseHeaders && h.responseStart.IsZero() {
+		h.responseStart = Now()
+	}
+	h.captureFlow(stage, n)
+	if h.guardStage(stage, n, end) {
+		h.lastVerdict = h.verdict
+		return h.verdict.action()
+	}
+	action := h.injectChaos(stage, n, h.trackPause(stage, end, h.dispatch(stage, n, end, isReq)))
+	h.advance(stage, n, action)
+	switch {
+	case h.finished:
+		h.lastVerdict = h.verdict
+	case action == types.ActionPause:
+		h.lastVerdict = VerdictPause
+	default:
+		h.lastVerdict = VerdictContinue
+	}
+	return action
+}
 
 // Every stage has the same flow:
 // 1) Short-circuit if possible
 // 2) Check if any interceptor matches
-// 3) Execute Do if matched
-func (h *httpCtx) run(stage HttpStage, n int, end bool, isReq bool) types.Action {
-	if h.skip != undefinedAction {
-		return h.skip
+// 3) Execute Do of matched interceptors
+func (h *httpCtx) dispatch(stage HttpStage, n int, end bool, isReq bool) types.Action {
+	if h.finished {
+		return h.verdict.action()
 	}
 
-runDo:
-	if h.doContext != nil {
-		doCtx := h.doContext
-		updateHttpDoCtx(doCtx, stage, n, end)
-		ignoreFurtherCalls := doCtx.interceptor.Do(doCtx)
-		if ignoreFurtherCalls {
-			h.doContext = nil
-			h.skip = doCtx.resultAction
-		}
-		return doCtx.resultAction
+	if httpMatchMode == MatchFirst && len(h.doContexts) > 0 {
+		return h.runDo(stage, n, end)
 	}
 
-	port, err := getIntProperty([]string{"destination", "port"})
+	port, err := h.properties.destinationPort()
 	if err != nil {
-		h.skip = types.ActionContinue
-		return types.ActionContinue
+		return h.finish(VerdictContinue)
 	}
 
-	ints := httpReg[port]
+	key := regKey{h.properties.direction(), port}
+	ints := httpReg[key]
 	if len(ints) == 0 {
-		h.skip = types.ActionContinue
-		return types.ActionContinue
+		return h.finish(VerdictContinue)
 	}
 
-	// Create WhenContext once for all interceptors
-	whenContexts := h.whenContexts
-	if whenContexts == nil {
-		whenContexts = make([]*HttpWhenContext, len(ints))
-		for i, it := range ints {
-			whenContexts[i] = h.makeWhenCtx(stage, port, n, end, isReq, &it)
+	// No When wants this stage: only matched interceptors run
+	if interest := httpStageInterest[key]; !interest.has(stage) {
+		if len(h.doContexts) > 0 {
+			return h.runDo(stage, n, end)
+		}
+		if !interest.after(stage) {
+			return h.finish(VerdictContinue)
 		}
-		h.whenContexts = whenContexts
+		return types.ActionContinue
+	}
+
+	if h.whenContexts == nil {
+		h.whenContexts = make([]*HttpWhenContext, len(ints))
 	}
 
 	anyPaused := false
+	allDone := true
 
-	for _, wc := range whenContexts {
-		updateHttpWhenCtx(wc, stage, n, end)
+	for i := range ints {
+		it := &ints[i]
+		wc := h.whenContexts[i]
+		// Contexts are built on first use
+		if wc == nil {
+			if it.When == nil {
+				continue
+			}
+			if !it.stages.has(stage) {
+				if it.stages.after(stage) {
+					allDone = false
+				}
+				continue
+			}
+			wc = h.makeWhenCtx(stage, n, end, it)
+			h.whenContexts[i] = wc
+		}
+		updateHttpWhenCtx(wc, stage, n, end, h.bodyOffset(stage))
+		if !wc.done && (it.Route != nil && !it.Route(h.getRoute()) || h.isExempt(it)) {
+			wc.done = true
+		}
 
-		it := wc.interceptor
-		if it == nil || it.When == nil {
+		if it.When == nil || wc.done {
 			continue
 		}
-		if it.When(wc) {
-			wc.LogInfo(fmt.Sprintf("when matched stage=%s", stage.String()))
+		if !it.stages.has(stage) {
+			if it.stages.after(stage) {
+				allDone = false
+			} else {
+				wc.done = true
+			}
+			continue
+		}
+		matched, panicked := safeCall("when", it.Name, it.When, wc)
+		if panicked {
+			return h.failStream()
+		}
+		if matched {
+			wc.done = true
+			if it.isException() {
+				h.exempt(wc)
+				continue
+			}
+			route := h.getRoute()
+			wc.LogInfo(fmt.Sprintf("when matched stage=%s route=%s cluster=%s request_id=%s", stage.String(), route.Name, route.Cluster, wc.RequestID))
+			if IsChecker(wc.SourceIP) {
+				wc.LogInfo("source " + wc.SourceIP + " is a checker, not acting")
+				continue
+			}
+			if it.fuse().tripped(port, it.Name) {
+				wc.LogInfo("fuse tripped, not acting")
+				continue
+			}
 			h.trace(isReq, it.Name)
-			h.doContext = makeHttpDoCtx(stage, port, n, end, it)
-			goto runDo
+			h.emitEvent("match", it.Name, port, VerdictContinue)
+			wc.doContext = h.makeDoCtx(stage, port, n, end, it)
+			wc.doContext.WhenData = wc.Data
+			h.doContexts = append(h.doContexts, wc.doContext)
+			if httpMatchMode == MatchFirst {
+				return h.runDo(stage, n, end)
+			}
+			continue
 		}
+		if wc.resultAction == types.ActionPause && bodyLimitExceeded(it, stage, n) {
+			wc.done = true
+			if fallback := h.applyBodyLimit(it, stage, n); fallback != VerdictContinue {
+				return h.finish(fallback)
+			}
+			continue
+		}
+		allDone = false
 		if wc.resultAction == types.ActionPause {
 			anyPaused = true
 		}
 	}
 
+	action := types.ActionContinue
+	if len(h.doContexts) > 0 {
+		action = h.runDo(stage, n, end)
+		if h.finished {
+			return action
+		}
+	} else if allDone {
+		h.finish(VerdictContinue)
+	}
+
 	if anyPaused {
 		return types.ActionPause
 	}
-	return types.ActionContinue
+	return action
 }
 
-func (h *httpCtx) makeWhenCtx(stage HttpStage, port int64, n int, end bool, isReq bool, interceptor *HttpInterceptor) *HttpWhenContext {
-	c := &HttpWhenContext{
-		Stage:        stage,
-		BodySize:     n,
-		End:          end,
-		interceptor:  interceptor,
-		resultAction: types.ActionContinue,
-	}
+// Runs Do of every matched interceptor. If any Do pauses, the stream is paused; final verdicts
+// combine by the port's VerdictPolicy.
+func (h *httpCtx) runDo(stage HttpStage, n int, end bool) types.Action {
+	action := types.ActionContinue
+	active := h.doContexts[:0]
+	policy := verdictPolicies[h.doContexts[0].Port]
+	// most severe verdict so far, for ModifyThenBlock
+	worst, worstBy := VerdictContinue, ""
 
-	c.GetRequestHeader = func(k string) string {
-		if !isReq {
-			return ""
+	for _, doCtx := range h.doContexts {
+		updateHttpDoCtx(doCtx, stage, n, end, h.bodyOffset(stage))
+		verdict, panicked := safeCall("do", doCtx.interceptor.Name, doCtx.interceptor.Do, doCtx)
+		if panicked {
+			return h.failStream()
 		}
-		v, _ := proxywasm.GetHttpRequestHeader(k)
-		return v
-	}
-	c.GetRequestBody = func(start, size int) ([]byte, error) {
-		if !isReq {
-			return nil, nil
+
+		if verdict == VerdictPause && bodyLimitExceeded(doCtx.interceptor, stage, n) {
+			fallback := h.applyBodyLimit(doCtx.interceptor, stage, n)
+			if fallback != VerdictContinue || httpMatchMode == MatchFirst {
+				return h.finish(fallback)
+			}
+			continue
 		}
-		body, err := proxywasm.GetHttpRequestBody(start, size)
-		return body, err
-	}
-	c.GetResponseHeader = func(k string) string {
-		if isReq {
-			return ""
+		if verdict.Final() {
+			h.acted(doCtx, verdict)
+			switch {
+			case httpMatchMode == MatchFirst:
+				return h.finish(verdict)
+			case policy == FirstWins, policy == BlockWins && verdict >= VerdictBlocked:
+				h.finishAggregated(stage, policy, doCtx.interceptor.Name, verdict)
+				return verdict.action()
+			case policy == ModifyThenBlock && verdict > worst:
+				worst, worstBy = verdict, doCtx.interceptor.Name
+			}
+			continue
 		}
-		v, _ := proxywasm.GetHttpResponseHeader(k)
-		return v
-	}
-	c.GetResponseBody = func(start, size int) ([]byte, error) {
-		if isReq {
-			return nil, nil
+
+		active = append(active, doCtx)
+		if verdict == VerdictPause {
+			action = types.ActionPause
 		}
-		body, err := proxywasm.GetHttpResponseBody(start, size)
-		return body, err
 	}
+
+	if worst >= VerdictBlocked {
+		h.finishAggregated(stage, policy, worstBy, worst)
+		return worst.action()
+	}
+	h.doContexts = active
+	if len(active) > 0 && !end {
+		h.releaseContentLength(stage)
+	}
+	return action
+}
+
+// Accounts for a final verdict of a Do, whether returned or applied later through Resume.
+func (h *httpCtx) acted(c *HttpDoContext, v Verdict) {
+	c.interceptor.fuse().count(c.Port, c.interceptor.Name, c.SourceIP)
+	if v >= VerdictBlocked {
+		h.emitEvent("block", c.interceptor.Name, c.Port, v)
+		h.recordExploit(c.interceptor, c.Port)
+	}
+}
+
+func (h *httpCtx) makeWhenCtx(stage HttpStage, n int, end bool, interceptor *HttpInterceptor) *HttpWhenContext {
+	if h.whenTemplate == nil {
+		h.whenTemplate = h.makeWhenTemplate()
+	}
+	c := new(HttpWhenContext)
+	*c = *h.whenTemplate
+	c.Stage = stage
+	c.BodySize = n
+	c.End = end
+	c.interceptor = interceptor
 	c.LogInfo = func(message string) {
-		if c.interceptor != nil && c.interceptor.Name != "" {
-			proxywasm.LogInfo(fmt.Sprintf("[%s (when)] %s", c.interceptor.Name, message))
+		if interceptor.Name != "" {
+			proxywasm.LogInfo(fmt.Sprintf("[%s (when)] %s", interceptor.Name, message))
 		} else {
 			proxywasm.LogInfo(message)
 		}
 	}
-
 	return c
 }
 
-func updateHttpWhenCtx(c *HttpWhenContext, stage HttpStage, n int, end bool) {
+// Fields and accessors shared by all When contexts of the stream, built once. Accessors
+// check the stage the stream is at, so they don't depend on a particular context.
+func (h *httpCtx) makeWhenTemplate() *HttpWhenContext {
+	isReq := func() bool { return h.lastStage < StageResponseHeaders }
+	return &HttpWhenContext{
+		ContextID:    h.contextID,
+		RequestID:    h.getRequestHeader(requestIDHeader),
+		ConnectionID: h.connectionID(),
+		SourceIP:     h.sourceIP(),
+		Direction:    h.properties.direction(),
+		TLS:          h.tls,
+		Conn:         &ConnState{properties: &h.properties},
+		resultAction: types.ActionContinue,
+
+		GetRequestHeader: h.getRequestHeader,
+		GetRequestBody: func(start, size int) ([]byte, error) {
+			if !isReq() {
+				return nil, nil
+			}
+			return proxywasm.GetHttpRequestBody(start, size)
+		},
+		GetRequestTrailer: func(k string) string {
+			if h.lastStage != StageRequestTrailers {
+				return ""
+			}
+			v, _ := proxywasm.GetHttpRequestTrailer(k)
+			return v
+		},
+		GetResponseHeader: func(k string) string {
+			if isReq() {
+				return ""
+			}
+			return h.getResponseHeader(k)
+		},
+		GetResponseBody: func(start, size int) ([]byte, error) {
+			if isReq() {
+				return nil, nil
+			}
+			return proxywasm.GetHttpResponseBody(start, size)
+		},
+		GetResponseTrailer: func(k string) string {
+			if h.lastStage != StageResponseTrailers {
+				return ""
+			}
+			v, _ := proxywasm.GetHttpResponseTrailer(k)
+			return v
+		},
+		GetRoute: h.getRoute,
+		Elapsed:  h.elapsed,
+	}
+}
+
+func updateHttpWhenCtx(c *HttpWhenContext, stage HttpStage, n int, end bool, offset int) {
 	c.Stage = stage
 	c.BodySize = n
+	c.BodyOffset = offset
 	c.End = end
 	c.resultAction = types.ActionContinue
 }
 
-func makeHttpDoCtx(stage HttpStage, port int64, n int, end bool, interceptor *HttpInterceptor) *HttpDoContext {
+func (h *httpCtx) makeDoCtx(stage HttpStage, port int64, n int, end bool, interceptor *HttpInterceptor) *HttpDoContext {
 	c := &HttpDoContext{
+		ContextID:    h.contextID,
+		RequestID:    h.getRequestHeader(requestIDHeader),
+		ConnectionID: h.connectionID(),
+		SourceIP:     h.sourceIP(),
+		Direction:    h.properties.direction(),
+		TLS:          h.tls,
 		Stage:        stage,
 		Port:         port,
 		BodySize:     n,
 		End:          end,
+		Conn:         &ConnState{properties: &h.properties},
 		interceptor:  interceptor,
-		resultAction: types.ActionContinue,
 	}
 
-	c.GetRequestHeader = func(k string) string {
-		if c.Stage != StageRequestHeaders {
-			c.LogWarn("GetRequestHeader called at wrong stage: " + c.Stage.String())
-			return ""
-		}
-		v, _ := proxywasm.GetHttpRequestHeader(k)
-		return v
-	}
+	c.GetRequestHeader = h.getRequestHeader
 	c.SetRequestHeader = func(k, v string) {
 		if c.Stage != StageRequestHeaders {
 			c.LogWarn("SetRequestHeader called at wrong stage: " + c.Stage.String())
 			return
 		}
 		proxywasm.ReplaceHttpRequestHeader(k, v)
+		h.requestHeaders.set(k, v)
+		// the route may change with the headers
+		h.clearRoute()
 	}
 	c.DelRequestHeader = func(k string) {
 		if c.Stage != StageRequestHeaders {
@@ -205,9 +436,11 @@ func makeHttpDoCtx(stage HttpStage, port int64, n int, end bool, interceptor *Ht
 			return
 		}
 		proxywasm.RemoveHttpRequestHeader(k)
+		h.requestHeaders.del(k)
+		h.clearRoute()
 	}
 	c.GetRequestBody = func(start, size int) ([]byte, error) {
-		if c.Stage != StageRequestBody {
+		if c.Stage != StageRequestBody && c.Stage != StageRequestTrailers {
 			c.LogWarn("GetRequestBody called at wrong stage: " + c.Stage.String())
 			return nil, nil
 		}
@@ -215,11 +448,37 @@ func makeHttpDoCtx(stage HttpStage, port int64, n int, end bool, interceptor *Ht
 		return body, err
 	}
 	c.ReplaceRequestBody = func(b []byte) error {
-		if c.Stage != StageRequestBody {
+		if c.Stage != StageRequestBody && c.Stage != StageRequestTrailers {
 			c.LogWarn("ReplaceRequestBody called at wrong stage: " + c.Stage.String())
 			return nil
 		}
-		return proxywasm.ReplaceHttpRequestBody(b)
+		if err := proxywasm.ReplaceHttpRequestBody(b); err != nil {
+			return err
+		}
+		h.fixFraming(true, c.End, len(b))
+		return nil
+	}
+	c.GetRequestTrailer = func(k string) string {
+		if c.Stage != StageRequestTrailers {
+			c.LogWarn("GetRequestTrailer called at wrong stage: " + c.Stage.String())
+			return ""
+		}
+		v, _ := proxywasm.GetHttpRequestTrailer(k)
+		return v
+	}
+	c.SetRequestTrailer = func(k, v string) {
+		if c.Stage != StageRequestTrailers {
+			c.LogWarn("SetRequestTrailer called at wrong stage: " + c.Stage.String())
+			return
+		}
+		proxywasm.ReplaceHttpRequestTrailer(k, v)
+	}
+	c.DelRequestTrailer = func(k string) {
+		if c.Stage != StageRequestTrailers {
+			c.LogWarn("DelRequestTrailer called at wrong stage: " + c.Stage.String())
+			return
+		}
+		proxywasm.RemoveHttpRequestTrailer(k)
 	}
 
 	c.GetResponseHeader = func(k string) string {
@@ -227,8 +486,7 @@ func makeHttpDoCtx(stage HttpStage, port int64, n int, end bool, interceptor *Ht
 			c.LogWarn("GetResponseHeader called at wrong stage: " + c.Stage.String())
 			return ""
 		}
-		v, _ := proxywasm.GetHttpResponseHeader(k)
-		return v
+		return h.getResponseHeader(k)
 	}
 	c.SetResponseHeader = func(k, v string) {
 		if c.Stage != StageResponseHeaders {
@@ -236,6 +494,7 @@ func makeHttpDoCtx(stage HttpStage, port int64, n int, end bool, interceptor *Ht
 			return
 		}
 		proxywasm.ReplaceHttpResponseHeader(k, v)
+		h.responseHeaders.set(k, v)
 	}
 	c.DelResponseHeader = func(k string) {
 		if c.Stage != StageResponseHeaders {
@@ -243,9 +502,10 @@ func makeHttpDoCtx(stage HttpStage, port int64, n int, end bool, interceptor *Ht
 			return
 		}
 		proxywasm.RemoveHttpResponseHeader(k)
+		h.responseHeaders.del(k)
 	}
 	c.GetResponseBody = func(start, size int) ([]byte, error) {
-		if c.Stage != StageResponseBody {
+		if c.Stage != StageResponseBody && c.Stage != StageResponseTrailers {
 			c.LogWarn("GetResponseBody called at wrong stage: " + c.Stage.String())
 			return nil, nil
 		}
@@ -253,13 +513,43 @@ func makeHttpDoCtx(stage HttpStage, port int64, n int, end bool, interceptor *Ht
 		return body, err
 	}
 	c.ReplaceResponseBody = func(b []byte) error {
-		if c.Stage != StageResponseBody {
+		if c.Stage != StageResponseBody && c.Stage != StageResponseTrailers {
 			c.LogWarn("ReplaceResponseBody called at wrong stage: " + c.Stage.String())
 			return nil
 		}
-		return proxywasm.ReplaceHttpResponseBody(b)
+		if err := proxywasm.ReplaceHttpResponseBody(b); err != nil {
+			return err
+		}
+		h.fixFraming(false, c.End, len(b))
+		return nil
+	}
+	c.GetResponseTrailer = func(k string) string {
+		if c.Stage != StageResponseTrailers {
+			c.LogWarn("GetResponseTrailer called at wrong stage: " + c.Stage.String())
+			return ""
+		}
+		v, _ := proxywasm.GetHttpResponseTrailer(k)
+		return v
+	}
+	c.SetResponseTrailer = func(k, v string) {
+		if c.Stage != StageResponseTrailers {
+			c.LogWarn("SetResponseTrailer called at wrong stage: " + c.Stage.String())
+			return
+		}
+		proxywasm.ReplaceHttpResponseTrailer(k, v)
+	}
+	c.DelResponseTrailer = func(k string) {
+		if c.Stage != StageResponseTrailers {
+			c.LogWarn("DelResponseTrailer called at wrong stage: " + c.Stage.String())
+			return
+		}
+		proxywasm.RemoveHttpResponseTrailer(k)
 	}
 
+	c.GetRoute = h.getRoute
+	c.Elapsed = h.elapsed
+	c.ResumeAfter = h.resumeAfter
+	c.Resume = func(v Verdict) { h.resume(c, v) }
 	c.LogInfo = func(message string) {
 		if c.interceptor != nil && c.interceptor.Name != "" {
 			proxywasm.LogInfo(fmt.Sprintf("[%s (do)] %s", c.interceptor.Name, message))
@@ -278,17 +568,120 @@ func makeHttpDoCtx(stage HttpStage, port int64, n int, end bool, interceptor *Ht
 	return c
 }
 
-func updateHttpDoCtx(c *HttpDoContext, stage HttpStage, n int, end bool) {
+// Applies a verdict reached outside a callback by a Do which paused the stream.
+func (h *httpCtx) resume(c *HttpDoContext, v Verdict) {
+	if h.finished || proxywasm.SetEffectiveContext(h.contextID) != nil {
+		return
+	}
+	// the stream is no longer held by the Do
+	h.clearPause()
+
+	if v.Final() {
+		h.acted(c, v)
+	}
+	switch {
+	case v >= VerdictBlocked || (v == VerdictModified && httpMatchMode == MatchFirst):
+		h.finish(v)
+	case v == VerdictModified:
+		active := h.doContexts[:0]
+		for _, d := range h.doContexts {
+			if d != c {
+				active = append(active, d)
+			}
+		}
+		h.doContexts = active
+	}
+	h.lastVerdict = v
+	if v >= VerdictBlocked || v == VerdictPause {
+		return
+	}
+
+	if err := h.resumeStream(); err != nil {
+		proxywasm.LogWarn("failed to resume stream: " + err.Error())
+	}
+}
+
+// Resumes a stream paused outside a callback. Envoy then forwards the data buffered at the last
+// stage, so its offset advances as if the callback had continued.
+func (h *httpCtx) resumeStream() error {
+	h.advance(h.lastStage, h.lastSize, types.ActionContinue)
+	if h.lastStage < StageResponseHeaders {
+		return proxywasm.ResumeHttpRequest()
+	}
+	return proxywasm.ResumeHttpResponse()
+}
+
+func updateHttpDoCtx(c *HttpDoContext, stage HttpStage, n int, end bool, offset int) {
 	c.Stage = stage
 	c.BodySize = n
+	c.BodyOffset = offset
 	c.End = end
-	c.resultAction = types.ActionContinue
+}
+
+// Stream offset of the first byte currently buffered for a body stage.
+func (h *httpCtx) bodyOffset(stage HttpStage) int {
+	switch stage {
+	case StageRequestBody:
+		return h.requestBodyOffset
+	case StageResponseBody:
+		return h.responseBodyOffset
+	default:
+		return 0
+	}
+}
+
+// Once a body chunk or headers are let through, Envoy forwards them; paused data stays buffered.
+func (h *httpCtx) advance(stage HttpStage, n int, action types.Action) {
+	switch stage {
+	case StageRequestBody:
+		h.requestBodyBytes = h.requestBodyOffset + n
+	case StageResponseBody:
+		h.responseBodyBytes = h.responseBodyOffset + n
+	}
+	if action != types.ActionContinue {
+		return
+	}
+	switch stage {
+	case StageRequestHeaders:
+		h.requestHeadersSent = true
+	case StageRequestBody:
+		h.requestBodyOffset += n
+	case StageResponseHeaders:
+		h.responseHeadersSent = true
+	case StageResponseBody:
+		h.responseBodyOffset += n
+	}
 }
 
 func (h *httpCtx) trace(isReq bool, name string) {
+	existing := h.responseHeaders["x-intercepted-by"]
+	if isReq {
+		existing = h.requestHeaders["x-intercepted-by"]
+	}
+	if existing != "" {
+		name = existing + ", " + name
+	}
 	if isReq {
 		proxywasm.ReplaceHttpRequestHeader("x-intercepted-by", name)
+		h.requestHeaders.set("x-intercepted-by", name)
 	} else {
 		proxywasm.ReplaceHttpResponseHeader("x-intercepted-by", name)
+		h.responseHeaders.set("x-intercepted-by", name)
 	}
 }
+
+// Downstream connection id, 0 if unavailable.
+func (h *httpCtx) connectionID() int64 {
+	id, _ := h.properties.connectionID()
+	return id
+}
+
+// Memoized request header lookup; Set/Del through the contexts keep the cache in sync.
+func (h *httpCtx) getRequestHeader(name string) string {
+	return h.requestHeaders.get(name, proxywasm.GetHttpRequestHeader)
+}
+
+// Memoized response header lookup; Set/Del through the contexts keep the cache in sync.
+func (h *httpCtx) getResponseHeader(name string) string {
+	return h.responseHeaders.get(name, proxywasm.GetHttpResponseHeader)
+}
@@ -5,72 +5,103 @@ import (
 
 	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
 	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
-	"google.golang.org/protobuf/proto"
 )
 
 const (
 	TcpStageDownstreamData TcpStage = iota
 	TcpStageUpstreamData
+	// The connection was accepted, no data was exchanged yet (only for interceptors registered
+	// WithConnectStage)
+	TcpStageConnect
 )
 
-// Interceptor registry port -> []TcpInterceptor
-var tcpReg = map[int64][]TcpInterceptor{}
+var tcpMatchMode = MatchFirst
 
-// Registers an interceptor for a service port
-func RegisterTcpInterceptor(port int64, name string, when func(*TcpWhenContext) bool, do func(*TcpDoContext) bool) {
+// Sets how matching TCP interceptors are executed.
+func SetTcpMatchMode(mode MatchMode) {
+	tcpMatchMode = mode
+}
+
+// Interceptor registry (direction, port) -> []TcpInterceptor
+var tcpReg = map[regKey][]TcpInterceptor{}
+
+// Registers an interceptor for a service port (inbound unless WithTcpDirection is given)
+func RegisterTcpInterceptor(port int64, name string, when func(*TcpWhenContext) bool, do func(*TcpDoContext) Verdict, opts ...TcpInterceptorOption) {
 	i := TcpInterceptor{
 		Name: name,
 		When: when,
 		Do:   do,
 	}
-	tcpReg[port] = append(tcpReg[port], i)
-	proxywasm.LogInfo(fmt.Sprintf("registered tcp interceptor name=%s port=%d", name, port))
+	for _, opt := range opts {
+		opt(&i)
+	}
+	key := regKey{i.Direction, port}
+	tcpReg[key] = append(tcpReg[key], i)
+	proxywasm.LogInfo(fmt.Sprintf("registered tcp interceptor name=%s port=%d direction=%s", name, port, i.Direction))
+}
+
+// Also calls When when the connection opens, so it can be rejected on connection properties
+// (source, SNI, ...) before any data is exchanged, e.g. with DoTcpBlock.
+func WithConnectStage() TcpInterceptorOption {
+	return func(i *TcpInterceptor) {
+		i.Connect = true
+	}
 }
 
 func (t *tcpCtx) OnNewConnection() types.Action {
-	return types.ActionContinue
+	t.startedAt = Now()
+	if t.allowedSource() || t.rejectBanned() || t.chaosDrop() {
+		return t.verdict.action()
+	}
+	return t.run(TcpStageConnect, 0, false)
 }
 func (t *tcpCtx) OnDownstreamData(n int, end bool) types.Action {
-	return t.run(TcpStageDownstreamData, n, end)
+	return t.passed(TcpStageDownstreamData, n, t.run(TcpStageDownstreamData, n, end))
 }
 func (t *tcpCtx) OnDownstreamClose(types.PeerType) {}
 func (t *tcpCtx) OnUpstreamData(n int, end bool) types.Action {
-	return t.run(TcpStageUpstreamData, n, end)
+	return t.passed(TcpStageUpstreamData, n, t.run(TcpStageUpstreamData, n, end))
 }
 func (t *tcpCtx) OnUpstreamClose(types.PeerType) {}
-func (t *tcpCtx) OnStreamDone()                  {}
+
+// Advances the stream offset once a segment is let through; paused data is seen again with the
+// next segment.
+func (t *tcpCtx) passed(stage TcpStage, n int, action types.Action) types.Action {
+	if action == types.ActionContinue {
+		t.offsets[stage] += int64(n)
+	}
+	return action
+}
+
+// Stream offset of the first buffered byte of the stage.
+func (t *tcpCtx) offset(stage TcpStage) int64 {
+	if stage == TcpStageConnect {
+		return 0
+	}
+	return t.offsets[stage]
+}
 
 // Every stage has the same flow:
 // 1) Short-circuit if possible
 // 2) Check if any interceptor matches
-// 3) Execute Do if matched
+// 3) Execute Do of matched interceptors
 func (ctx *tcpCtx) run(stage TcpStage, n int, end bool) types.Action {
-	if ctx.skip != undefinedAction {
-		return ctx.skip
-	}
-
-runDo:
-	if ctx.doContext != nil {
-		doCtx := ctx.doContext
-		updateTcpDoCtx(doCtx, stage, n, end)
-		ignoreFurtherCalls := doCtx.interceptor.Do(doCtx)
-		if ignoreFurtherCalls {
-			ctx.doContext = nil
-			ctx.skip = doCtx.resultAction
-		}
-		return doCtx.resultAction
+	if ctx.finished {
+		return ctx.verdict.action()
+	}
+
+	if tcpMatchMode == MatchFirst && len(ctx.doContexts) > 0 {
+		return ctx.runDo(stage, n, end)
 	}
 
-	port, err := getIntProperty([]string{"destination", "port"})
+	port, err := ctx.properties.destinationPort()
 	if err != nil {
-		ctx.skip = types.ActionContinue
-		return types.ActionContinue
+		return ctx.finish(VerdictContinue)
 	}
 
-	ints := tcpReg[port]
+	ints := tcpReg[regKey{ctx.properties.direction(), port}]
 	if len(ints) == 0 {
-		ctx.skip = types.ActionContinue
-		return types.ActionContinue
+		return ctx.finish(VerdictContinue)
 	}
 
 	// Create WhenContext once for all interceptors
@@ -84,38 +115,115 @@ runDo:
 	}
 
 	anyPaused := false
+	allDone := true
 
 	for _, wc := range whenContexts {
-		updateTcpWhenCtx(wc, stage, n, end)
+		updateTcpWhenCtx(wc, stage, n, end, ctx.offset(stage))
 
 		it := wc.interceptor
-		if it == nil || it.When == nil {
+		if it == nil || it.When == nil || wc.done {
+			continue
+		}
+		if stage == TcpStageConnect && !it.Connect {
+			allDone = false
 			continue
 		}
-		if it.When(wc) {
-			wc.LogInfo(fmt.Sprintf("when matched stage=%s", stage.String()))
+		matched, panicked := safeCall("when", it.Name, it.When, wc)
+		if panicked {
+			return ctx.failStream()
+		}
+		if matched {
+			wc.done = true
+			m := ctx.tcpMatch(wc)
+			if m != nil {
+				wc.LogInfo(fmt.Sprintf("when matched stage=%s connection=%d offset=%d length=%d\n%s", stage.String(), m.ConnectionID, m.Offset, m.Length, m.Hexdump))
+			} else {
+				wc.LogInfo(fmt.Sprintf("when matched stage=%s", stage.String()))
+			}
+			if IsChecker(wc.SourceIP) {
+				wc.LogInfo("source " + wc.SourceIP + " is a checker, not acting")
+				continue
+			}
+			if defaultFuse.tripped(port, it.Name) {
+				wc.LogInfo("fuse tripped, not acting")
+				continue
+			}
 			ctx.trace(it.Name)
-			ctx.doContext = makeTcpDoCtx(stage, port, n, end, it)
-			goto runDo
+			ctx.emitEvent("match", it.Name, port, VerdictContinue, m)
+			wc.doContext = ctx.makeDoCtx(stage, port, n, end, it)
+			ctx.doContexts = append(ctx.doContexts, wc.doContext)
+			if tcpMatchMode == MatchFirst {
+				return ctx.runDo(stage, n, end)
+			}
+			continue
 		}
+		allDone = false
 		if wc.resultAction == types.ActionPause {
 			anyPaused = true
 		}
 	}
 
+	action := types.ActionContinue
+	if len(ctx.doContexts) > 0 {
+		action = ctx.runDo(stage, n, end)
+		if ctx.finished {
+			return action
+		}
+	} else if allDone {
+		ctx.finish(VerdictContinue)
+	}
+
 	if anyPaused {
 		return types.ActionPause
 	}
-	return types.ActionContinue
+	return action
+}
+
+// Runs Do of every matched interceptor, aggregating verdicts the same way as for HTTP.
+func (ctx *tcpCtx) runDo(stage TcpStage, n int, end bool) types.Action {
+	action := types.ActionContinue
+	active := ctx.doContexts[:0]
+
+	for _, doCtx := range ctx.doContexts {
+		updateTcpDoCtx(doCtx, stage, n, end, ctx.offset(stage))
+		verdict, panicked := safeCall("do", doCtx.interceptor.Name, doCtx.interceptor.Do, doCtx)
+		if panicked {
+			return ctx.failStream()
+		}
+		if verdict.Final() {
+			defaultFuse.count(doCtx.Port, doCtx.interceptor.Name, doCtx.SourceIP)
+			if verdict >= VerdictBlocked {
+				ctx.emitEvent("block", doCtx.interceptor.Name, doCtx.Port, verdict, nil)
+			}
+			if verdict != VerdictModified || tcpMatchMode == MatchFirst {
+				return ctx.finish(verdict)
+			}
+			continue
+		}
+
+		active = append(active, doCtx)
+		if verdict == VerdictPause {
+			action = types.ActionPause
+		}
+	}
+
+	ctx.doContexts = active
+	return action
 }
 
 func (ctx *tcpCtx) makeWhenCtx(stage TcpStage, port int64, n int, end bool, interceptor *TcpInterceptor) *TcpWhenContext {
+	connectionID, _ := ctx.properties.connectionID()
 	c := &TcpWhenContext{
-		Stage:       stage,
-		Size:        n,
-		End:         end,
-		interceptor: interceptor,
+		Stage:        stage,
+		Size:         n,
+		End:          end,
+		ContextID:    ctx.contextID,
+		ConnectionID: connectionID,
+		SourceIP:     ctx.sourceIP(),
+		Direction:    ctx.properties.direction(),
+		interceptor:  interceptor,
 	}
+	c.SNI, _ = ctx.properties.getString(PropConnectionSni)
 
 	c.LogInfo = func(message string) {
 		proxywasm.LogInfo(fmt.Sprintf("tcp interceptor %s: %s", interceptor.Name, message))
@@ -125,45 +233,46 @@ func (ctx *tcpCtx) makeWhenCtx(stage TcpStage, port int64, n int, end bool, inte
 	return c
 }
 
-func updateTcpWhenCtx(c *TcpWhenContext, stage TcpStage, n int, end bool) {
+func updateTcpWhenCtx(c *TcpWhenContext, stage TcpStage, n int, end bool, offset int64) {
 	c.Stage = stage
 	c.Size = n
+	c.Offset = offset
 	c.End = end
+	c.matchPos = -1
 }
 
-func makeTcpDoCtx(stage TcpStage, port int64, n int, end bool, interceptor *TcpInterceptor) *TcpDoContext {
+func (ctx *tcpCtx) makeDoCtx(stage TcpStage, port int64, n int, end bool, interceptor *TcpInterceptor) *TcpDoContext {
+	connectionID, _ := ctx.properties.connectionID()
 	c := &TcpDoContext{
 		Stage:        stage,
+		Port:         port,
 		Size:         n,
+		Offset:       ctx.offset(stage),
 		End:          end,
+		ContextID:    ctx.contextID,
+		ConnectionID: connectionID,
+		SourceIP:     ctx.sourceIP(),
+		Direction:    ctx.properties.direction(),
 		interceptor:  interceptor,
-		resultAction: types.ActionContinue,
 	}
 
 	return c
 }
 
 func (c *TcpDoContext) MarkBlocked() error {
-	data, err := proto.Marshal(&SetEnvoyFilterStateArguments{
-		Path:  "envoy.string",
-		Value: "blocked",
-		Span:  LifeSpan_FilterChain,
-	})
-	if err != nil {
-		return fmt.Errorf("MarkBlocked proto.Marshal failed: %v", err)
-	}
-	_, err = proxywasm.CallForeignFunction("set_envoy_filter_state", data)
+	data := marshalFilterState("envoy.string", "blocked", LifeSpan_FilterChain)
+	_, err := proxywasm.CallForeignFunction("set_envoy_filter_state", data)
 	if err != nil {
 		return fmt.Errorf("OnNewConnection CallForeignFunction set_envoy_filter_state failed: %v", err)
 	}
 	return nil
 }
 
-func updateTcpDoCtx(c *TcpDoContext, stage TcpStage, n int, end bool) {
+func updateTcpDoCtx(c *TcpDoContext, stage TcpStage, n int, end bool, offset int64) {
 	c.Stage = stage
 	c.Size = n
+	c.Offset = offset
 	c.End = end
-	c.resultAction = types.ActionContinue
 }
 
 func (h *tcpCtx) trace(name string) {
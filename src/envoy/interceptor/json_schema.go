@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// JsonSchema is a compiled JSON Schema (draft-07 subset): type, enum, const, properties, required,
+// additionalProperties, items, min/maxLength, pattern, minimum/maximum, exclusiveMinimum/Maximum,
+// min/maxItems. Other keywords are ignored.
+type JsonSchema struct {
+	types                []string
+	enum                 []interface{}
+	constValue           interface{}
+	hasConst             bool
+	properties           map[string]*JsonSchema
+	required             []string
+	additionalProperties *JsonSchema
+	// additionalProperties: false
+	noAdditional     bool
+	items            *JsonSchema
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	minItems         *int
+	maxItems         *int
+}
+
+// Compiles a JSON Schema document.
+func CompileJsonSchema(schema string) (*JsonSchema, error) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(schema), &doc); err != nil {
+		return nil, err
+	}
+	return compileSchema(doc, "#")
+}
+
+func compileSchema(doc interface{}, at string) (*JsonSchema, error) {
+	if b, ok := doc.(bool); ok {
+		if b {
+			return &JsonSchema{}, nil
+		}
+		return nil, fmt.Errorf("%s: false schema is only supported for additionalProperties", at)
+	}
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object", at)
+	}
+	s := &JsonSchema{}
+	var err error
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: must be a string", at)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: must be a string or an array", at)
+	}
+	if e, ok := m["enum"].([]interface{}); ok {
+		s.enum = e
+	}
+	if c, ok := m["const"]; ok {
+		s.constValue, s.hasConst = c, true
+	}
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		s.properties = map[string]*JsonSchema{}
+		for name, p := range props {
+			if s.properties[name], err = compileSchema(p, at+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if req, ok := m["required"].([]interface{}); ok {
+		for _, r := range req {
+			if name, ok := r.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+	switch ap := m["additionalProperties"].(type) {
+	case nil:
+	case bool:
+		s.noAdditional = !ap
+	default:
+		if s.additionalProperties, err = compileSchema(ap, at+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if items, ok := m["items"]; ok {
+		if s.items, err = compileSchema(items, at+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	if p, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %v", at, err)
+		}
+	}
+	s.minLength = schemaInt(m, "minLength")
+	s.maxLength = schemaInt(m, "maxLength")
+	s.minItems = schemaInt(m, "minItems")
+	s.maxItems = schemaInt(m, "maxItems")
+	s.minimum = schemaNumber(m, "minimum")
+	s.maximum = schemaNumber(m, "maximum")
+	s.exclusiveMinimum = schemaNumber(m, "exclusiveMinimum")
+	s.exclusiveMaximum = schemaNumber(m, "exclusiveMaximum")
+	return s, nil
+}
+
+func schemaNumber(m map[string]interface{}, key string) *float64 {
+	if v, ok := m[key].(float64); ok {
+		return &v
+	}
+	return nil
+}
+
+func schemaInt(m map[string]interface{}, key string) *int {
+	if v, ok := m[key].(float64); ok {
+		i := int(v)
+		return &i
+	}
+	return nil
+}
+
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+// Validates a decoded JSON value (as produced by encoding/json into interface{}).
+func (s *JsonSchema) Validate(v interface{}) error {
+	return s.validate(v, "$")
+}
+
+func (s *JsonSchema) validate(v interface{}, at string) error {
+	if len(s.types) > 0 {
+		t := jsonType(v)
+		ok := false
+		for _, want := range s.types {
+			if want == t || (want == "number" && t == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: expected %s, got %s", at, strings.Join(s.types, " or "), t)
+		}
+	}
+	if s.hasConst && !jsonEqual(v, s.constValue) {
+		return fmt.Errorf("%s: must equal const", at)
+	}
+	if s.enum != nil {
+		ok := false
+		for _, e := range s.enum {
+			if jsonEqual(v, e) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: not in enum", at)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			return fmt.Errorf("%s: shorter than %d", at, *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return fmt.Errorf("%s: longer than %d", at, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s: does not match pattern", at)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return fmt.Errorf("%s: less than %v", at, *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return fmt.Errorf("%s: greater than %v", at, *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			return fmt.Errorf("%s: not greater than %v", at, *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			return fmt.Errorf("%s: not less than %v", at, *s.exclusiveMaximum)
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return fmt.Errorf("%s: fewer than %d items", at, *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return fmt.Errorf("%s: more than %d items", at, *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing %s", at, name)
+			}
+		}
+		// sorted for stable error messages
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, ok := s.properties[k]; ok {
+				if err := p.validate(v[k], at+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			if s.noAdditional {
+				return fmt.Errorf("%s: unexpected property %s", at, k)
+			}
+			if s.additionalProperties != nil {
+				if err := s.additionalProperties.validate(v[k], at+"."+k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+// Removes properties not allowed by additionalProperties: false, recursively. Reports whether anything was removed.
+func (s *JsonSchema) strip(v interface{}) bool {
+	stripped := false
+	switch v := v.(type) {
+	case []interface{}:
+		if s.items != nil {
+			for _, item := range v {
+				stripped = s.items.strip(item) || stripped
+			}
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			if p, ok := s.properties[k]; ok {
+				stripped = p.strip(item) || stripped
+			} else if s.noAdditional {
+				delete(v, k)
+				stripped = true
+			} else if s.additionalProperties != nil {
+				stripped = s.additionalProperties.strip(item) || stripped
+			}
+		}
+	}
+	return stripped
+}
+
+// SchemaAction is what happens to a request body not conforming to its schema.
+type SchemaAction int
+
+const (
+	// Reject the request with 400.
+	SchemaBlock SchemaAction = iota
+	// Remove unexpected properties and forward the request; other violations are still rejected.
+	SchemaStrip
+)
+
+type jsonSchemaState struct {
+	// set if the request is a JSON request to a validated path
+	checked  bool
+	bodySize int
+}
+
+// Validates JSON request bodies of paths matching path against schema. Requests are buffered
+// until the body is complete. An invalid schema fails the plugin start.
+func RegisterJsonSchema(port int64, path func(string) bool, schema string, action SchemaAction) {
+	s, err := CompileJsonSchema(schema)
+	if err != nil {
+		ruleFailed(fmt.Errorf("invalid json schema for port %d: %w", port, err))
+		return
+	}
+
+	RegisterHttpInterceptor(port, "json schema", func(ctx *HttpWhenContext) bool {
+		switch ctx.Stage {
+		case StageRequestHeaders:
+			st := State[jsonSchemaState](ctx)
+			st.checked = path(ctx.GetRequestHeader(":path")) && strings.Contains(ctx.GetRequestHeader("content-type"), "json")
+			return false
+		case StageRequestBody:
+			st := State[jsonSchemaState](ctx)
+			if !st.checked {
+				return false
+			}
+			if !ctx.End {
+				st.bodySize = ctx.BodySize
+				ctx.Pause()
+				return false
+			}
+			st.bodySize = ctx.BodySize
+			return jsonBodyInvalid(ctx, s, st.bodySize)
+		case StageRequestTrailers:
+			st := State[jsonSchemaState](ctx)
+			return st.checked && jsonBodyInvalid(ctx, s, st.bodySize)
+		}
+		return false
+	}, DoJsonSchema(s, action), WithStages(StageRequestHeaders, StageRequestBody, StageRequestTrailers))
+}
+
+func jsonBodyInvalid(ctx *HttpWhenContext, s *JsonSchema, size int) bool {
+	body, err := ctx.GetRequestBody(0, size)
+	if err != nil {
+		return false
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		ctx.LogInfo("invalid json body: " + err.Error())
+		return true
+	}
+	if key := duplicateJsonKey(body); key != "" {
+		ctx.LogInfo(fmt.Sprintf("duplicate json key %q", key))
+		return true
+	}
+	if err := s.Validate(v); err != nil {
+		ctx.LogInfo("json schema violation: " + err.Error())
+		return true
+	}
+	return false
+}
+
+// Rejects a non-conforming body, or strips unexpected properties when the action allows it. Bodies
+// with duplicate keys are always rejected, as backends disagree on which value wins.
+func DoJsonSchema(s *JsonSchema, action SchemaAction) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(true, false)
+		size := ctx.BodySize
+		if ctx.Stage == StageRequestTrailers {
+			size = 0
+			if st := WhenState[jsonSchemaState](ctx); st != nil {
+				size = st.bodySize
+			}
+		}
+		if action == SchemaStrip && size > 0 {
+			if body, err := ctx.GetRequestBody(0, size); err == nil && duplicateJsonKey(body) == "" {
+				var v interface{}
+				if json.Unmarshal(body, &v) == nil && s.strip(v) && s.Validate(v) == nil {
+					if b, err := json.Marshal(v); err == nil {
+						if err := ctx.ReplaceRequestBody(b); err == nil {
+							ctx.LogInfo("stripped unexpected json properties")
+							return VerdictModified
+						}
+					}
+				}
+			}
+		}
+		return DoBadRequest(ctx)
+	}
+}
+
+// Returns the first key found twice in a JSON object of body, "" if none.
+func duplicateJsonKey(body []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// keys of each open object, nil for arrays
+	var open []map[string]bool
+	// whether the next token of the innermost object is a key
+	expectKey := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		if d, ok := tok.(json.Delim); ok {
+			switch d {
+			case '{':
+				open = append(open, map[string]bool{})
+				expectKey = true
+			case '[':
+				open = append(open, nil)
+				expectKey = false
+			default:
+				open = open[:len(open)-1]
+				expectKey = len(open) > 0 && open[len(open)-1] != nil
+			}
+			continue
+		}
+		if !expectKey {
+			expectKey = len(open) > 0 && open[len(open)-1] != nil
+			continue
+		}
+		key := tok.(string)
+		if open[len(open)-1][key] {
+			return key
+		}
+		open[len(open)-1][key] = true
+		expectKey = false
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompileJsonSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		err    bool
+	}{
+		{name: "empty", schema: `{}`},
+		{name: "true", schema: `true`},
+		{name: "type list", schema: `{"type": ["string", "null"]}`},
+		{name: "nested", schema: `{"properties": {"a": {"items": {"type": "integer"}}}, "additionalProperties": {"type": "string"}}`},
+		{name: "not json", schema: `{`, err: true},
+		{name: "not an object", schema: `1`, err: true},
+		{name: "false", schema: `false`, err: true},
+		{name: "false property", schema: `{"properties": {"a": false}}`, err: true},
+		{name: "bad type", schema: `{"type": 1}`, err: true},
+		{name: "bad type in list", schema: `{"type": ["string", 1]}`, err: true},
+		{name: "bad pattern", schema: `{"pattern": "("}`, err: true},
+		{name: "bad items", schema: `{"items": "x"}`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileJsonSchema(tt.schema)
+			if (err != nil) != tt.err {
+				t.Errorf("err = %v, want error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestJsonSchemaValidate(t *testing.T) {
+	const order = `{
+		"type": "object",
+		"required": ["id", "items"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"note": {"type": ["string", "null"], "maxLength": 4},
+			"coupon": {"pattern": "^[A-Z]{3}$"},
+			"kind": {"enum": ["a", "b"]},
+			"v": {"const": 2},
+			"price": {"type": "number", "exclusiveMinimum": 0, "exclusiveMaximum": 100},
+			"items": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string", "minLength": 1}},
+			"meta": {"additionalProperties": {"type": "boolean"}}
+		}
+	}`
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{name: "valid", doc: `{"id": 1, "items": ["x"], "note": null, "kind": "a", "v": 2, "price": 9.5, "meta": {"a": true}}`},
+		{name: "integer as number", doc: `{"id": 1, "items": ["x"], "price": 5}`},
+		{name: "runes counted", doc: `{"id": 1, "items": ["x"], "note": "ääää"}`},
+		{name: "not an object", doc: `[]`, err: "$: expected object, got array"},
+		{name: "missing", doc: `{"id": 1}`, err: "$: missing items"},
+		{name: "unexpected", doc: `{"id": 1, "items": ["x"], "admin": true}`, err: "$: unexpected property admin"},
+		{name: "number for integer", doc: `{"id": 1.5, "items": ["x"]}`, err: "$.id: expected integer, got number"},
+		{name: "minimum", doc: `{"id": 0, "items": ["x"]}`, err: "$.id: less than 1"},
+		{name: "maxLength", doc: `{"id": 1, "items": ["x"], "note": "hello"}`, err: "$.note: longer than 4"},
+		{name: "pattern", doc: `{"id": 1, "items": ["x"], "coupon": "abc"}`, err: "$.coupon: does not match pattern"},
+		{name: "enum", doc: `{"id": 1, "items": ["x"], "kind": "c"}`, err: "$.kind: not in enum"},
+		{name: "const", doc: `{"id": 1, "items": ["x"], "v": 3}`, err: "$.v: must equal const"},
+		{name: "exclusiveMinimum", doc: `{"id": 1, "items": ["x"], "price": 0}`, err: "$.price: not greater than 0"},
+		{name: "exclusiveMaximum", doc: `{"id": 1, "items": ["x"], "price": 100}`, err: "$.price: not less than 100"},
+		{name: "minItems", doc: `{"id": 1, "items": []}`, err: "$.items: fewer than 1 items"},
+		{name: "maxItems", doc: `{"id": 1, "items": ["x", "y", "z"]}`, err: "$.items: more than 2 items"},
+		{name: "item", doc: `{"id": 1, "items": ["x", ""]}`, err: "$.items[1]: shorter than 1"},
+		{name: "additional schema", doc: `{"id": 1, "items": ["x"], "meta": {"a": "yes"}}`, err: "$.meta.a: expected boolean, got string"},
+		{name: "first error by key order", doc: `{"id": 0, "items": [], "kind": "c"}`, err: "$.id: less than 1"},
+	}
+	s, err := CompileJsonSchema(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			if err := json.Unmarshal([]byte(tt.doc), &v); err != nil {
+				t.Fatal(err)
+			}
+			err := s.Validate(v)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Errorf("err = %q, want %q", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestDuplicateJsonKey(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"a": 1, "b": 2}`, ""},
+		{`{"a": 1, "a": 2}`, "a"},
+		{`{"a": {"b": 1}, "b": 2}`, ""},
+		{`{"a": {"b": 1, "b": 2}}`, "b"},
+		{`[{"a": 1}, {"a": 2}]`, ""},
+		{`{"a": ["a", "a"], "c": 1, "c": 2}`, "c"},
+		{`{"a": [{"b": 1}], "a": 1}`, "a"},
+		{`not json`, ""},
+	}
+	for _, tt := range tests {
+		if got := duplicateJsonKey([]byte(tt.body)); got != tt.want {
+			t.Errorf("duplicateJsonKey(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
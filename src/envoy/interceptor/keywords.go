@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+)
+
+// KeywordSet finds any of a set of words in one pass over the data, however many words there are
+// (Aho-Corasick). Get one from Keywords or KeywordsFold.
+type KeywordSet struct {
+	fold  bool
+	words []string
+	nodes []keywordNode
+}
+
+type keywordNode struct {
+	next map[byte]int32
+	// state to continue from when next has no transition
+	fail int32
+	// word ending at this state, directly or through fail links (-1 if none)
+	word int32
+}
+
+func newKeywordSet(fold bool, words []string) (*KeywordSet, error) {
+	k := &KeywordSet{fold: fold, words: words, nodes: []keywordNode{{word: -1}}}
+	var err error
+	for i, w := range words {
+		if w == "" {
+			err = errors.New("empty word")
+			continue
+		}
+		s := int32(0)
+		for j := 0; j < len(w); j++ {
+			c := k.normalize(w[j])
+			next, ok := k.nodes[s].next[c]
+			if !ok {
+				next = int32(len(k.nodes))
+				k.nodes = append(k.nodes, keywordNode{word: -1})
+				if k.nodes[s].next == nil {
+					k.nodes[s].next = map[byte]int32{}
+				}
+				k.nodes[s].next[c] = next
+			}
+			s = next
+		}
+		if k.nodes[s].word < 0 {
+			k.nodes[s].word = int32(i)
+		}
+	}
+
+	// breadth-first, so fail states are complete before their dependents
+	queue := []int32{}
+	for _, child := range k.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for c, child := range k.nodes[s].next {
+			queue = append(queue, child)
+			f := k.nodes[s].fail
+			for f != 0 && !k.has(f, c) {
+				f = k.nodes[f].fail
+			}
+			if next, ok := k.nodes[f].next[c]; ok && s != 0 {
+				k.nodes[child].fail = next
+			}
+			if k.nodes[child].word < 0 {
+				k.nodes[child].word = k.nodes[k.nodes[child].fail].word
+			}
+		}
+	}
+	return k, err
+}
+
+func (k *KeywordSet) has(s int32, c byte) bool {
+	_, ok := k.nodes[s].next[c]
+	return ok
+}
+
+func (k *KeywordSet) normalize(c byte) byte {
+	if k.fold && 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// Returns the first word occurrence in data (the one ending first), as its word and position.
+func (k *KeywordSet) Find(data []byte) (word string, pos int, ok bool) {
+	s := int32(0)
+	for i := 0; i < len(data); i++ {
+		c := k.normalize(data[i])
+		for s != 0 && !k.has(s, c) {
+			s = k.nodes[s].fail
+		}
+		if next, found := k.nodes[s].next[c]; found {
+			s = next
+		}
+		if w := k.nodes[s].word; w >= 0 {
+			word = k.words[w]
+			return word, i + 1 - len(word), true
+		}
+	}
+	return "", 0, false
+}
+
+// Reports whether data contains any of the words.
+func (k *KeywordSet) Match(data []byte) bool {
+	_, _, ok := k.Find(data)
+	return ok
+}
+
+// Reports whether s contains any of the words.
+func (k *KeywordSet) MatchString(s string) bool {
+	return k.Match([]byte(s))
+}
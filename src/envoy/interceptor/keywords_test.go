@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestKeywordSetFind(t *testing.T) {
+	tests := []struct {
+		name  string
+		fold  bool
+		words []string
+		data  string
+		word  string
+		pos   int
+	}{
+		{name: "first ending wins", words: []string{"he", "she", "his", "hers"}, data: "ushers", word: "she", pos: 1},
+		{name: "suffix through fail link", words: []string{"abcd", "bc"}, data: "xabcd", word: "bc", pos: 2},
+		{name: "restart after mismatch", words: []string{"aab"}, data: "aaab", word: "aab", pos: 1},
+		{name: "at the start", words: []string{"union"}, data: "union select", word: "union", pos: 0},
+		{name: "case folded", fold: true, words: []string{"SELECT"}, data: "1 union sElEcT", word: "SELECT", pos: 8},
+		{name: "case kept", words: []string{"SELECT"}, data: "1 union select", pos: -1},
+		{name: "no match", words: []string{"flag", "passwd"}, data: "/etc/shadow", pos: -1},
+		{name: "no words", data: "anything", pos: -1},
+		{name: "duplicate words", words: []string{"ab", "ab"}, data: "cab", word: "ab", pos: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := newKeywordSet(tt.fold, tt.words)
+			if err != nil {
+				t.Fatal(err)
+			}
+			word, pos, ok := k.Find([]byte(tt.data))
+			if !ok {
+				pos = -1
+			}
+			if word != tt.word || pos != tt.pos {
+				t.Errorf("Find(%q) = %q at %d, want %q at %d", tt.data, word, pos, tt.word, tt.pos)
+			}
+			if k.MatchString(tt.data) != ok {
+				t.Errorf("MatchString(%q) disagrees with Find", tt.data)
+			}
+		})
+	}
+}
+
+func TestKeywordSetEmptyWord(t *testing.T) {
+	k, err := newKeywordSet(false, []string{"", "evil"})
+	if err == nil {
+		t.Error("no error for an empty word")
+	}
+	if !k.MatchString("so evil") || k.MatchString("fine") {
+		t.Error("other words not matched as usual")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// KV is a namespace of shared data, seen by every worker of the VM: key k is stored as
+// "<namespace>/<k>", or just k in the root namespace (""). Shared data can't be deleted, so keep
+// keys bounded and values small.
+type KV struct {
+	namespace string
+}
+
+func NewKV(namespace string) KV {
+	return KV{namespace: namespace}
+}
+
+// Un-namespaced keys of the built-in features.
+var rootKV = NewKV("")
+
+func (kv KV) key(key string) string {
+	if kv.namespace == "" {
+		return key
+	}
+	return kv.namespace + "/" + key
+}
+
+// Returns the value of key, nil if it is not set.
+func (kv KV) Get(key string) ([]byte, error) {
+	v, _, err := sharedGet(kv.key(key))
+	return v, err
+}
+
+func (kv KV) GetInt(key string) (int64, error) {
+	v, err := kv.Get(key)
+	return decodeInt64(v), err
+}
+
+// Sets key unconditionally.
+func (kv KV) Set(key string, value []byte) error {
+	return proxywasm.SetSharedData(kv.key(key), value, 0)
+}
+
+// Atomically replaces the value of key with update(old) and returns it. update may run several
+// times under contention.
+func (kv KV) Update(key string, update func(old []byte) []byte) ([]byte, error) {
+	return sharedUpdate(kv.key(key), update)
+}
+
+// Atomically adds delta to an int64 value and returns the new value.
+func (kv KV) Increment(key string, delta int64) (int64, error) {
+	return sharedIncr(kv.key(key), delta)
+}
+
+// Counts an event in a fixed window starting with the window's first event, and returns the count
+// of the current window.
+func (kv KV) IncrementWindow(key string, window time.Duration) (int64, error) {
+	var count int64
+	_, err := kv.Update(key, func(old []byte) []byte {
+		var v []byte
+		v, count = countWindow(old, window)
+		return v
+	})
+	return count, err
+}
+
+// Window start and count, encoded as two int64, after counting an event.
+func countWindow(old []byte, window time.Duration) ([]byte, int64) {
+	now := Now().UnixNano()
+	start, n := int64(0), int64(0)
+	if len(old) == 16 {
+		start = int64(binary.LittleEndian.Uint64(old))
+		n = int64(binary.LittleEndian.Uint64(old[8:]))
+	}
+	if now-start >= int64(window) {
+		start, n = now, 0
+	}
+	v := make([]byte, 16)
+	binary.LittleEndian.PutUint64(v, uint64(start))
+	binary.LittleEndian.PutUint64(v[8:], uint64(n+1))
+	return v, n + 1
+}
+
+// Sets key to new if its value is old (nil for unset) and reports whether it did. A missing key
+// can't be created atomically, so two workers may both succeed from nil.
+func (kv KV) CompareAndSwap(key string, old, new []byte) (bool, error) {
+	for i := 0; i < sharedCasRetries; i++ {
+		cur, cas, err := sharedGet(kv.key(key))
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(cur, old) {
+			return false, nil
+		}
+		err = proxywasm.SetSharedData(kv.key(key), new, cas)
+		if errors.Is(err, types.ErrorStatusCasMismatch) {
+			continue
+		}
+		return err == nil, err
+	}
+	return false, fmt.Errorf("shared data %s: too much contention", kv.key(key))
+}
+
+// Adds member to the set stored at key (a JSON array, as shared data can't be enumerated).
+func (kv KV) SetAdd(key, member string) error {
+	return sharedSetAdd(kv.key(key), member)
+}
+
+func (kv KV) SetRemove(key, member string) error {
+	return sharedSetRemove(kv.key(key), member)
+}
+
+func (kv KV) SetMembers(key string) ([]string, error) {
+	return sharedSetMembers(kv.key(key))
+}
+
+// BoundedKV keeps values of keys chosen by clients (nonces, tokens, header values...) in a fixed
+// number of slots, so clients can't grow shared data without limit. A key hashes to a slot holding
+// the last key stored there: a key is forgotten once another one takes its slot.
+type BoundedKV struct {
+	kv    KV
+	slots uint64
+}
+
+func NewBoundedKV(namespace string, slots int) BoundedKV {
+	if slots <= 0 {
+		slots = 1
+	}
+	return BoundedKV{kv: NewKV(namespace), slots: uint64(slots)}
+}
+
+// Slot of key, and the tag stored in front of the value to tell the keys of a slot apart.
+func (b BoundedKV) slot(key string) (string, []byte) {
+	sum := sha256.Sum256([]byte(key))
+	return strconv.FormatUint(binary.LittleEndian.Uint64(sum[:8])%b.slots, 10), sum[8:16]
+}
+
+// Returns the value of key, nil if it is not set or was evicted.
+func (b BoundedKV) Get(key string) ([]byte, error) {
+	slot, tag := b.slot(key)
+	v, err := b.kv.Get(slot)
+	if err != nil || !bytes.HasPrefix(v, tag) {
+		return nil, err
+	}
+	return v[len(tag):], nil
+}
+
+// Sets key, evicting the key its slot held.
+func (b BoundedKV) Set(key string, value []byte) error {
+	slot, tag := b.slot(key)
+	return b.kv.Set(slot, append(append([]byte{}, tag...), value...))
+}
+
+// Like KV.Update; old is nil if the slot holds another key, which a non-nil result evicts.
+func (b BoundedKV) Update(key string, update func(old []byte) []byte) ([]byte, error) {
+	slot, tag := b.slot(key)
+	var out []byte
+	_, err := b.kv.Update(slot, func(cur []byte) []byte {
+		var old []byte
+		if bytes.HasPrefix(cur, tag) {
+			old = cur[len(tag):]
+		}
+		out = update(old)
+		if out == nil && old == nil {
+			return cur
+		}
+		if out == nil {
+			return nil
+		}
+		return append(append([]byte{}, tag...), out...)
+	})
+	return out, err
+}
+
+// Atomically adds delta to an int64 value and returns the new value.
+func (b BoundedKV) Increment(key string, delta int64) (int64, error) {
+	v, err := b.Update(key, func(old []byte) []byte {
+		return encodeInt64(decodeInt64(old) + delta)
+	})
+	return decodeInt64(v), err
+}
+
+// Like KV.IncrementWindow.
+func (b BoundedKV) IncrementWindow(key string, window time.Duration) (int64, error) {
+	var count int64
+	_, err := b.Update(key, func(old []byte) []byte {
+		var v []byte
+		v, count = countWindow(old, window)
+		return v
+	})
+	return count, err
+}
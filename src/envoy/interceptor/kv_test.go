@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCountWindow(t *testing.T) {
+	clk := NewFakeClock(time.Unix(1700000000, 0))
+	SetClock(clk)
+	defer SetClock(nil)
+
+	steps := []struct {
+		advance time.Duration
+		want    int64
+	}{
+		{0, 1},
+		{0, 2},
+		{9 * time.Second, 3},
+		{999 * time.Millisecond, 4},
+		// the window starts with the first count, not the last
+		{time.Millisecond, 1},
+		{5 * time.Second, 2},
+		{time.Hour, 1},
+	}
+	var v []byte
+	for i, step := range steps {
+		clk.Advance(step.advance)
+		var n int64
+		v, n = countWindow(v, 10*time.Second)
+		if n != step.want {
+			t.Errorf("step %d: count = %d, want %d", i, n, step.want)
+		}
+	}
+	if _, n := countWindow([]byte("garbage"), 10*time.Second); n != 1 {
+		t.Errorf("count from a malformed value = %d, want 1", n)
+	}
+}
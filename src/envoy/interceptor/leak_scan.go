@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// What to do when a response contains sensitive material.
+type LeakAction int
+
+const (
+	// Log the leak and forward the response unchanged
+	LeakLog LeakAction = iota
+	// Replace every match with leakRedacted
+	LeakScrub
+	// Withhold the response and reply with 502
+	LeakBlock
+)
+
+type LeakPattern struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+var DefaultLeakPatterns = []LeakPattern{
+	{"flag", regexp.MustCompile(`[A-Z0-9]{31}=|(?i)\bflag\{[^}\s]{1,128}\}`)},
+	{"aws key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY-----`)},
+	{"connection string", regexp.MustCompile(`(?i)\b(?:postgres(?:ql)?|mysql|mariadb|mongodb(?:\+srv)?|redis|amqps?)://[^\s:/@]+:[^\s@/]+@`)},
+}
+
+var leakRedacted = []byte("[redacted]")
+
+type leakScanner struct {
+	patterns []LeakPattern
+	action   LeakAction
+	// leak_patterns from the plugin config, added on first use since it loads after registration
+	configured bool
+}
+
+func (s *leakScanner) allPatterns() []LeakPattern {
+	if !s.configured {
+		s.configured = true
+		names := make([]string, 0, len(config.LeakPatterns))
+		for name := range config.LeakPatterns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			// compiled by compileLeakPatterns
+			if re, err := compileRegexp(config.LeakPatterns[name]); err == nil {
+				s.patterns = append(s.patterns, LeakPattern{name, re})
+			}
+		}
+	}
+	return s.patterns
+}
+
+// Compiles the leak_patterns of the plugin config into the matcher registry.
+func (c pluginConfig) compileLeakPatterns() error {
+	var errs []error
+	for name, pattern := range c.LeakPatterns {
+		if _, err := compileRegexp(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid leak pattern %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the names of the patterns found in body and, when scrubbing, the body with every match
+// replaced.
+func (s *leakScanner) scan(body []byte) ([]string, []byte) {
+	var found []string
+	for _, p := range s.allPatterns() {
+		if !p.Pattern.Match(body) {
+			continue
+		}
+		found = append(found, p.Name)
+		if s.action == LeakScrub {
+			body = p.Pattern.ReplaceAllLiteral(body, leakRedacted)
+		}
+	}
+	return found, body
+}
+
+// Buffers the whole response body and checks it for leaks. Response headers are only held back
+// when blocking, as a local reply is impossible once they were sent.
+func (s *leakScanner) do(ctx *HttpDoContext) Verdict {
+	ctx.ReplacesBodies(false, true)
+	rc := trackResponseCoding(ctx)
+
+	switch {
+	case ctx.Stage == StageResponseHeaders:
+		if s.action == LeakBlock {
+			return VerdictPause
+		}
+		return VerdictContinue
+	case ctx.Stage == StageResponseBody && !ctx.End:
+		rc.bodySize = ctx.BodySize
+		return VerdictPause
+	}
+
+	bodySize := ctx.BodySize
+	if ctx.Stage == StageResponseTrailers {
+		bodySize = rc.bodySize
+	}
+	if bodySize == 0 {
+		return VerdictContinue
+	}
+	raw, err := ctx.GetResponseBody(0, bodySize)
+	if err != nil {
+		ctx.LogWarn("failed to read response body: " + err.Error())
+		return VerdictContinue
+	}
+	decoded, ok := rc.decode(ctx, raw)
+	if !ok {
+		return rc.withhold(ctx)
+	}
+	found, body := s.scan(decoded)
+	if len(found) == 0 {
+		return VerdictContinue
+	}
+
+	reportLeak(ctx, found)
+
+	switch s.action {
+	case LeakScrub:
+		if err := ctx.ReplaceResponseBody(rc.encode(ctx, body)); err != nil {
+			ctx.LogWarn("failed to replace response body: " + err.Error())
+		}
+		return VerdictModified
+	case LeakBlock:
+		reply(502, [][2]string{{"content-type", "text/plain"}}, []byte("response withheld\n"))
+		return VerdictBlocked
+	}
+	return VerdictContinue
+}
+
+// Scans every event of an event stream on its own, so streams are neither held back nor
+// buffered forever. Blocking ends the stream at the leaking event.
+func (s *leakScanner) scanEvent(ctx *HttpDoContext, ev *SseEvent) Verdict {
+	found, data := s.scan([]byte(ev.Data))
+	if len(found) == 0 {
+		return VerdictContinue
+	}
+	reportLeak(ctx, found)
+	switch s.action {
+	case LeakScrub:
+		ev.Data = string(data)
+		return VerdictModified
+	case LeakBlock:
+		return VerdictDropped
+	}
+	return VerdictContinue
+}
+
+// Collects the request fingerprint (recorded on leaks) before matching at the response headers.
+func fingerprinted(when func(*HttpWhenContext) bool) func(*HttpWhenContext) bool {
+	return func(ctx *HttpWhenContext) bool {
+		if ctx.Stage < StageResponseHeaders {
+			collectFingerprint(ctx)
+			return false
+		}
+		return when(ctx)
+	}
+}
+
+func reportLeak(ctx *HttpDoContext, found []string) {
+	incrCounter("interceptor_leaks_detected")
+	ctx.LogWarn(fmt.Sprintf("response leaks %v path=%s", found, ctx.GetRequestHeader(":path")))
+	recordFingerprint(ctx, found)
+}
+
+// Scans responses on port for sensitive material. Patterns named in the plugin config's
+// leak_patterns are added to the given ones; nil patterns means DefaultLeakPatterns. Responses are
+// buffered in full, so streamed responses are delayed until complete, except event streams which
+// are scanned per event.
+func RegisterLeakScanner(port int64, patterns []LeakPattern, action LeakAction) {
+	if patterns == nil {
+		patterns = DefaultLeakPatterns
+	}
+	s := &leakScanner{patterns: append([]LeakPattern(nil), patterns...), action: action}
+	stages := WithStages(StageRequestHeaders, StageRequestBody, StageResponseHeaders)
+	RegisterHttpInterceptor(port, "leak scanner", fingerprinted(func(ctx *HttpWhenContext) bool {
+		return ctx.GetRequestHeader(":method") != "HEAD" && !MatchSse(ctx)
+	}), s.do, stages)
+	RegisterHttpInterceptor(port, "leak scanner (sse)", fingerprinted(MatchSse), DoSseEvents(s.scanEvent), stages)
+}
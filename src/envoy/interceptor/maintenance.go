@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Maintenance page, "maintenance" in the plugin config. Ports are switched into maintenance at
+// runtime (see the admin API), e.g. while a service is patched and restarted: every request but
+// checkers' is then answered with 503 and the page.
+type maintenanceConfig struct {
+	// Page body ("service under maintenance" if unset)
+	Body string `json:"body"`
+	// Page content type (default text/plain)
+	ContentType string `json:"content_type"`
+	// Embedded asset served as the page instead of Body, e.g. "maintenance.html"
+	Asset string `json:"asset"`
+}
+
+const maintenancePortsKey = "maintenance-ports"
+
+func SetMaintenance(port int64, on bool) error {
+	p := strconv.FormatInt(port, 10)
+	if !on {
+		if err := rootKV.SetRemove(maintenancePortsKey, p); err != nil {
+			return err
+		}
+		proxywasm.LogInfo("port " + p + " out of maintenance")
+		return nil
+	}
+	if err := rootKV.SetAdd(maintenancePortsKey, p); err != nil {
+		return err
+	}
+	proxywasm.LogInfo("port " + p + " in maintenance")
+	return nil
+}
+
+// Ports in maintenance.
+func MaintenancePorts() ([]int64, error) {
+	members, err := rootKV.SetMembers(maintenancePortsKey)
+	if err != nil {
+		return nil, err
+	}
+	ports := []int64{}
+	for _, m := range members {
+		if port, err := strconv.ParseInt(m, 10, 64); err == nil {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+func IsUnderMaintenance(port int64) bool {
+	ports, err := MaintenancePorts()
+	if err != nil {
+		return false
+	}
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registerStartGuard(guardMaintenance, (*httpCtx).serveMaintenance)
+}
+
+// Answers with the maintenance page if the stream's port is in maintenance.
+func (h *httpCtx) serveMaintenance() bool {
+	port, err := h.properties.destinationPort()
+	if err != nil || !IsUnderMaintenance(port) || IsChecker(h.sourceIP()) {
+		return false
+	}
+	body, contentType := config.Maintenance.Body, config.Maintenance.ContentType
+	if config.Maintenance.Asset != "" {
+		b, ct, _ := Asset(config.Maintenance.Asset)
+		body, contentType = string(b), ct
+	}
+	if body == "" {
+		body = "service under maintenance"
+	}
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	headers := [][2]string{{"content-type", contentType}, {"retry-after", "60"}}
+	reply(503, headers, []byte(body))
+	h.finish(VerdictBlocked)
+	return true
+}
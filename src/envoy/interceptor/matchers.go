@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Registry of matchers compiled once and shared read-only by every stream of the VM: regexps,
+// keyword sets (Aho-Corasick) and network sets (CIDR tries). Rules get theirs from Regexp,
+// Keywords and Networks at registration, so a pattern used by many rules is compiled once and a
+// bad one fails the plugin start (see ruleFailed) instead of a rule silently never matching.
+var matchers = struct {
+	regexps  map[string]*regexp.Regexp
+	keywords map[string]*KeywordSet
+	networks map[string]*NetworkSet
+}{
+	regexps:  map[string]*regexp.Regexp{},
+	keywords: map[string]*KeywordSet{},
+	networks: map[string]*NetworkSet{},
+}
+
+// Stands in for a pattern that failed to compile
+var neverMatch = regexp.MustCompile(`[^\x00-\x{10FFFF}]`)
+
+// Returns the compiled pattern. An invalid one never matches and is reported at plugin start.
+func Regexp(pattern string) *regexp.Regexp {
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		ruleFailed(fmt.Errorf("regexp %q: %w", pattern, err))
+		re = neverMatch
+		matchers.regexps[pattern] = re
+	}
+	return re
+}
+
+// Compiles pattern or returns its cached compiled form; failures are not cached.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := matchers.regexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	matchers.regexps[pattern] = re
+	return re, nil
+}
+
+// Returns the set of words, matched case-sensitively. Empty words are reported at plugin start.
+func Keywords(words ...string) *KeywordSet {
+	return keywords(false, words)
+}
+
+// Returns the set of words, matched ignoring ASCII case.
+func KeywordsFold(words ...string) *KeywordSet {
+	return keywords(true, words)
+}
+
+func keywords(fold bool, words []string) *KeywordSet {
+	key := fmt.Sprint(fold, "\x00", strings.Join(words, "\x00"))
+	if k, ok := matchers.keywords[key]; ok {
+		return k
+	}
+	k, err := newKeywordSet(fold, words)
+	if err != nil {
+		ruleFailed(fmt.Errorf("keywords %q: %w", words, err))
+	}
+	matchers.keywords[key] = k
+	return k
+}
+
+// Returns the set of networks, given as CIDRs or single IPs. Invalid ones are left out and
+// reported at plugin start.
+func Networks(cidrs ...string) *NetworkSet {
+	key := strings.Join(cidrs, ",")
+	if s, ok := matchers.networks[key]; ok {
+		return s
+	}
+	s := &NetworkSet{}
+	for _, c := range cidrs {
+		if err := s.Add(c, ""); err != nil {
+			ruleFailed(fmt.Errorf("network %q: %w", c, err))
+		}
+	}
+	matchers.networks[key] = s
+	return s
+}
@@ -0,0 +1,26 @@
+package main
+
+import "strings"
+
+// Rejects requests on a port whose method is not in methods with 405, e.g. to stop TRACE or
+// PROPFIND fingerprinting of services only using GET and POST.
+func RegisterMethodAllowlist(port int64, methods ...string) {
+	allowed := map[string]bool{}
+	for i, m := range methods {
+		methods[i] = strings.ToUpper(m)
+		allowed[methods[i]] = true
+	}
+	allow := strings.Join(methods, ", ")
+
+	RegisterHttpInterceptor(port, "method allowlist", func(ctx *HttpWhenContext) bool {
+		method := ctx.GetRequestHeader(":method")
+		if allowed[method] {
+			return false
+		}
+		ctx.LogInfo("method not allowed: " + method)
+		return true
+	}, func(ctx *HttpDoContext) Verdict {
+		reply(405, [][2]string{{"allow", allow}}, []byte("method not allowed"))
+		return VerdictBlocked
+	}, WithStages(StageRequestHeaders))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Metrics endpoint, "metrics" in the plugin config: serves the filter's counters in the Prometheus
+// text format, like the admin API from the filter itself.
+type metricsConfig struct {
+	// Port whose requests for Path are answered with the metrics (disabled if 0)
+	Port int64 `json:"port"`
+	// Default /metrics
+	Path string `json:"path"`
+}
+
+// Envoy keeps counter values process wide, but each worker only knows the counters it defined, so
+// every counter is listed in shared data as "<name>\t<label>\t<value>...".
+const metricIndexKey = "metric-index"
+
+var counters = map[string]proxywasm.MetricCounter{}
+
+// Increments a counter; labels are name, value pairs.
+func incrCounter(name string, labels ...string) {
+	key := strings.Join(append([]string{name}, labels...), "\t")
+	c, ok := counters[key]
+	if !ok {
+		c = defineCounter(key)
+		if err := rootKV.SetAdd(metricIndexKey, key); err != nil {
+			proxywasm.LogWarn("failed to index counter " + name + ": " + err.Error())
+		}
+	}
+	c.Increment(1)
+}
+
+func defineCounter(key string) proxywasm.MetricCounter {
+	c, ok := counters[key]
+	if !ok {
+		// Envoy stat names are dot separated
+		parts := strings.Split(key, "\t")
+		for i := range parts[1:] {
+			parts[i+1] = strings.Map(func(r rune) rune {
+				if r == '.' || r == ' ' || r == '\t' {
+					return '_'
+				}
+				return r
+			}, parts[i+1])
+		}
+		c = proxywasm.DefineCounterMetric(strings.Join(parts, "."))
+		counters[key] = c
+	}
+	return c
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// Prometheus text exposition of every indexed counter.
+func metricsExposition() ([]byte, error) {
+	keys, err := rootKV.SetMembers(metricIndexKey)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	lastName := ""
+	for _, key := range keys {
+		parts := strings.Split(key, "\t")
+		name := parts[0]
+		if name != lastName {
+			fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+			lastName = name
+		}
+		b.WriteString(name)
+		if len(parts) > 1 {
+			labels := make([]string, 0, len(parts)/2)
+			for i := 1; i+1 < len(parts); i += 2 {
+				labels = append(labels, parts[i]+`="`+escapeLabelValue(parts[i+1])+`"`)
+			}
+			b.WriteString("{" + strings.Join(labels, ",") + "}")
+		}
+		fmt.Fprintf(&b, " %d\n", defineCounter(key).Value())
+	}
+	if err := counterExposition(&b); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+func init() {
+	registerStartGuard(guardMetrics, (*httpCtx).serveMetrics)
+}
+
+// Answers the stream if it is a metrics request. A metrics_token secret, if set, is required as
+// bearer token.
+func (h *httpCtx) serveMetrics() bool {
+	c := config.Metrics
+	if c.Port == 0 {
+		return false
+	}
+	if port, err := h.properties.destinationPort(); err != nil || port != c.Port {
+		return false
+	}
+	want := c.Path
+	if want == "" {
+		want = "/metrics"
+	}
+	if path, _, _ := strings.Cut(h.getRequestHeader(":path"), "?"); path != want {
+		return false
+	}
+
+	status, headers := uint32(200), [][2]string{{"content-type", "text/plain; version=0.0.4"}}
+	var body []byte
+	token := Secret(SecretMetricsToken)
+	got := strings.TrimPrefix(h.getRequestHeader("authorization"), "Bearer ")
+	if token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		status, body = 403, []byte("forbidden\n")
+	} else if exposition, err := metricsExposition(); err != nil {
+		status, body = 500, []byte(err.Error()+"\n")
+	} else {
+		body = exposition
+	}
+	reply(status, headers, body)
+	h.finish(VerdictBlocked)
+	return true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+)
+
+// NetworkSet maps networks to values, looked up by longest prefix in a binary trie. Get one from
+// Networks, or fill it with Add.
+type NetworkSet struct {
+	v4, v6 *networkNode
+}
+
+type networkNode struct {
+	child [2]*networkNode
+	value string
+	// set if a network ends here
+	set bool
+}
+
+// Adds a network, as a CIDR or a single IP (see parseNetwork), with a value for Lookup.
+func (s *NetworkSet) Add(cidr, value string) error {
+	n, err := parseNetwork(cidr)
+	if err != nil {
+		return err
+	}
+	ones, bits := n.Mask.Size()
+	root := &s.v6
+	if bits == 32 {
+		root = &s.v4
+	}
+	if *root == nil {
+		*root = &networkNode{}
+	}
+	node := *root
+	for i := 0; i < ones; i++ {
+		b := n.IP[i/8] >> (7 - i%8) & 1
+		if node.child[b] == nil {
+			node.child[b] = &networkNode{}
+		}
+		node = node.child[b]
+	}
+	node.value, node.set = value, true
+	return nil
+}
+
+// Returns the value of the most specific network containing ip.
+func (s *NetworkSet) Lookup(ip string) (string, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", false
+	}
+	node := s.v6
+	if v4 := parsed.To4(); v4 != nil {
+		parsed, node = v4, s.v4
+	}
+	value, found := "", false
+	for i := 0; node != nil; i++ {
+		if node.set {
+			value, found = node.value, true
+		}
+		if i == len(parsed)*8 {
+			break
+		}
+		node = node.child[parsed[i/8]>>(7-i%8)&1]
+	}
+	return value, found
+}
+
+// Reports whether ip belongs to one of the networks.
+func (s *NetworkSet) Contains(ip string) bool {
+	_, ok := s.Lookup(ip)
+	return ok
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Normalization selects transformations applied to input before matching, so that trivial
+// encoding tricks don't bypass string matchers.
+type Normalization int
+
+const (
+	// Compose common Latin combining sequences (NFC), map fullwidth forms and ligatures to ASCII,
+	// drop zero-width and soft-hyphen characters. The stdlib has no Unicode tables for full NFC/NFKC,
+	// so only the forms seen in bypass attempts are handled.
+	NormalizeUnicode Normalization = 1 << iota
+	// Fold case (lowercase).
+	NormalizeCase
+	// Decode UTF-16 (with BOM), strip UTF-8 BOM, decode overlong UTF-8 and read invalid bytes as Latin-1.
+	NormalizeCharset
+	// Resolve the request path like a backend would (see NormalizePath). Only applies to Matcher.Path,
+	// and isn't part of NormalizeAll: add it explicitly.
+	NormalizeURLPath
+
+	NormalizeAll = NormalizeUnicode | NormalizeCase | NormalizeCharset
+)
+
+// Applies the normalization to raw bytes.
+func Normalize(b []byte, n Normalization) []byte {
+	if n == 0 {
+		return b
+	}
+	return []byte(NormalizeString(string(b), n))
+}
+
+// Applies the normalization to a string.
+func NormalizeString(s string, n Normalization) string {
+	if n&NormalizeCharset != 0 {
+		s = decodeCharset(s)
+	}
+	if n&NormalizeUnicode != 0 {
+		s = foldUnicode(s)
+	}
+	if n&NormalizeCase != 0 {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// Wraps a string matcher so it sees normalized input.
+func MatchNormalized(n Normalization, match func(string) bool) func(string) bool {
+	return func(s string) bool {
+		return match(NormalizeString(s, n))
+	}
+}
+
+func decodeCharset(s string) string {
+	switch {
+	case strings.HasPrefix(s, "\xef\xbb\xbf"):
+		return decodeUtf8Quirks(s[3:])
+	case strings.HasPrefix(s, "\xff\xfe"):
+		return decodeUtf16(s[2:], false)
+	case strings.HasPrefix(s, "\xfe\xff"):
+		return decodeUtf16(s[2:], true)
+	default:
+		return decodeUtf8Quirks(s)
+	}
+}
+
+func decodeUtf16(s string, bigEndian bool) string {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		} else {
+			units = append(units, uint16(s[i+1])<<8|uint16(s[i]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// Decodes overlong UTF-8 sequences (e.g. C0 AF for '/') and reads other invalid bytes as Latin-1.
+func decodeUtf8Quirks(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r != utf8.RuneError || size > 1 {
+			sb.WriteRune(r)
+			i += size
+			continue
+		}
+		if r, size := decodeOverlong(s[i:]); size > 0 {
+			sb.WriteRune(r)
+			i += size
+			continue
+		}
+		sb.WriteRune(rune(s[i]))
+		i++
+	}
+	return sb.String()
+}
+
+func decodeOverlong(s string) (rune, int) {
+	isCont := func(b byte) bool { return b&0xC0 == 0x80 }
+	switch {
+	case len(s) >= 2 && s[0]&0xE0 == 0xC0 && isCont(s[1]):
+		return rune(s[0]&0x1F)<<6 | rune(s[1]&0x3F), 2
+	case len(s) >= 3 && s[0]&0xF0 == 0xE0 && isCont(s[1]) && isCont(s[2]):
+		return rune(s[0]&0x0F)<<12 | rune(s[1]&0x3F)<<6 | rune(s[2]&0x3F), 3
+	case len(s) >= 4 && s[0]&0xF8 == 0xF0 && isCont(s[1]) && isCont(s[2]) && isCont(s[3]):
+		return rune(s[0]&0x07)<<18 | rune(s[1]&0x3F)<<12 | rune(s[2]&0x3F)<<6 | rune(s[3]&0x3F), 4
+	default:
+		return 0, 0
+	}
+}
+
+var ligatures = map[rune]string{
+	'ﬀ': "ff", 'ﬁ': "fi", 'ﬂ': "fl", 'ﬃ': "ffi", 'ﬄ': "ffl", 'ﬅ': "st", 'ﬆ': "st",
+}
+
+// Base letter + combining mark -> precomposed letter.
+var compositions = func() map[[2]rune]rune {
+	table := []struct {
+		mark     rune
+		base     string
+		composed string
+	}{
+		{0x0300, "AEIOUaeiou", "ÀÈÌÒÙàèìòù"},
+		{0x0301, "AEIOUYaeiouy", "ÁÉÍÓÚÝáéíóúý"},
+		{0x0302, "AEIOUaeiou", "ÂÊÎÔÛâêîôû"},
+		{0x0303, "ANOano", "ÃÑÕãñõ"},
+		{0x0308, "AEIOUaeiouy", "ÄËÏÖÜäëïöüÿ"},
+		{0x030A, "Aa", "Åå"},
+		{0x0327, "Cc", "Çç"},
+	}
+	m := map[[2]rune]rune{}
+	for _, t := range table {
+		composed := []rune(t.composed)
+		for i, b := range []rune(t.base) {
+			m[[2]rune{b, t.mark}] = composed[i]
+		}
+	}
+	return m
+}()
+
+func isIgnorableRune(r rune) bool {
+	switch {
+	case r == 0x00AD, r == 0xFEFF:
+		return true
+	case r >= 0x200B && r <= 0x200F:
+		return true
+	case r >= 0x2060 && r <= 0x2064:
+		return true
+	default:
+		return false
+	}
+}
+
+func foldUnicode(s string) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch {
+		case isIgnorableRune(r):
+			continue
+		case r >= 0xFF01 && r <= 0xFF5E:
+			r -= 0xFEE0
+		case r == 0x3000:
+			r = ' '
+		}
+		if l, ok := ligatures[r]; ok {
+			out = append(out, []rune(l)...)
+			continue
+		}
+		if len(out) > 0 {
+			if c, ok := compositions[[2]rune{out[len(out)-1], r}]; ok {
+				out[len(out)-1] = c
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Normalizes the path part of a request target the way most backends resolve it: percent-decoding
+// (once), backslashes as slashes, overlong UTF-8 decoding, duplicate-slash collapsing and dot-segment
+// removal. The query is kept as is.
+func NormalizePath(target string) string {
+	p, query, hasQuery := strings.Cut(target, "?")
+	p = decodeUtf8Quirks(percentDecode(p))
+	p = strings.ReplaceAll(p, "\\", "/")
+	p = removeDotSegments(collapseSlashes(p))
+	if hasQuery {
+		return p + "?" + query
+	}
+	return p
+}
+
+func percentDecode(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	default:
+		return c - 'a' + 10
+	}
+}
+
+func collapseSlashes(p string) string {
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
+	return p
+}
+
+// RFC 3986 section 5.2.4; a trailing slash is kept.
+func removeDotSegments(p string) string {
+	if !strings.Contains(p, ".") {
+		return p
+	}
+	segments := strings.Split(p, "/")
+	out := make([]string, 0, len(segments))
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	res := strings.Join(out, "/")
+	if strings.HasPrefix(p, "/") && !strings.HasPrefix(res, "/") {
+		res = "/" + res
+	}
+	return res
+}
+
+// Returns why a raw request target looks like an encoding bypass attempt, "" if it doesn't:
+// double percent-encoding, overlong UTF-8, encoded NUL, or encoded path separators and dot segments.
+func SuspiciousPath(target string) string {
+	p, _, _ := strings.Cut(target, "?")
+	if !strings.Contains(p, "%") {
+		return ""
+	}
+	decoded := percentDecode(p)
+	switch {
+	case percentDecode(decoded) != decoded:
+		return "double percent-encoding"
+	case strings.Contains(decoded, "\x00"):
+		return "encoded NUL"
+	case hasOverlong(decoded):
+		return "overlong UTF-8"
+	}
+	if strings.Count(decoded, "/") != strings.Count(p, "/") || strings.Count(decoded, "\\") != strings.Count(p, "\\") {
+		return "encoded path separator"
+	}
+	if hasDotSegments(decoded) && !hasDotSegments(p) {
+		return "encoded dot segment"
+	}
+	return ""
+}
+
+func hasDotSegments(p string) bool {
+	p = collapseSlashes(p)
+	return removeDotSegments(p) != p
+}
+
+func hasOverlong(s string) bool {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size <= 1 {
+			if _, n := decodeOverlong(s[i:]); n > 0 {
+				return true
+			}
+		}
+		i += max(size, 1)
+	}
+	return false
+}
+
+// Matches requests whose path looks like an encoding bypass attempt.
+func WhenSuspiciousPath(ctx *HttpWhenContext) bool {
+	if ctx.Stage != StageRequestHeaders {
+		return false
+	}
+	if reason := SuspiciousPath(ctx.GetRequestHeader(":path")); reason != "" {
+		ctx.LogInfo("suspicious path: " + reason)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/a/b", "/a/b"},
+		{"/a/./b/../c", "/a/c"},
+		{"/a//b///c", "/a/b/c"},
+		{"/a/b/.", "/a/b/"},
+		{"/a/..", "/"},
+		{"/../../x", "/x"},
+		{"/%2e%2e/etc/passwd", "/etc/passwd"},
+		{"/%252e%252e/x", "/%2e%2e/x"},
+		{"/a\\..\\b", "/b"},
+		{"/a/%c0%af../b", "/b"},
+		{"/a/../b?x=../y//z", "/b?x=../y//z"},
+		{"/caf%C3%A9", "/café"},
+	}
+	for _, tt := range tests {
+		if got := NormalizePath(tt.target); got != tt.want {
+			t.Errorf("NormalizePath(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestSuspiciousPath(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/a/b", ""},
+		{"/a%20b", ""},
+		{"/../x%20", ""},
+		{"/a?x=%252e%00", ""},
+		{"/%252e%252e/", "double percent-encoding"},
+		{"/a%00.php", "encoded NUL"},
+		{"/%c0%afetc", "overlong UTF-8"},
+		{"/a%2fb", "encoded path separator"},
+		{"/a%5Cb", "encoded path separator"},
+		{"/%2e%2e/etc", "encoded dot segment"},
+		{"/a/%2e/b", "encoded dot segment"},
+	}
+	for _, tt := range tests {
+		if got := SuspiciousPath(tt.target); got != tt.want {
+			t.Errorf("SuspiciousPath(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Hot path reporting, "path_top" in the plugin config: requests are counted per port and path, and
+// the top paths of each port are logged, published to TopicPaths and kept for the admin API's
+// /paths once per interval.
+type pathTopConfig struct {
+	// Seconds between reports (disabled if 0)
+	Interval int `json:"interval"`
+	// Paths per report (default 10)
+	Top int `json:"top"`
+	// Distinct paths counted per port (default 1000); once reached, only the most requested are
+	// kept, so counts are approximate
+	MaxPaths int `json:"max_paths"`
+}
+
+// PathReport JSON of each report
+const TopicPaths = "paths"
+
+type PathCount struct {
+	Path  string `json:"path"`
+	Count int64  `json:"count"`
+}
+
+type PathReport struct {
+	Port  int64       `json:"port"`
+	Start time.Time   `json:"start"`
+	End   time.Time   `json:"end"`
+	Paths []PathCount `json:"paths"`
+}
+
+const (
+	// Counted instead of new paths beyond MaxPaths on a worker
+	otherPaths       = "(other)"
+	maxPathLength    = 200
+	pathTopFlush     = 5 * time.Second
+	pathTopPortsKey  = "ports"
+	pathTopClaimKey  = "reported-at"
+	pathTopCountsKey = "counts/"
+	pathTopLastKey   = "last/"
+)
+
+var pathTopKV = NewKV("path-top")
+
+// This worker's counts since its last flush, port -> path -> count
+var pathCounts = map[int64]map[string]int64{}
+
+func (c pathTopConfig) top() int {
+	if c.Top <= 0 {
+		return 10
+	}
+	return c.Top
+}
+
+func (c pathTopConfig) maxPaths() int {
+	if c.MaxPaths <= 0 {
+		return 1000
+	}
+	return c.MaxPaths
+}
+
+func registerPathTopTickers() {
+	c := config.PathTop
+	if c.Interval <= 0 {
+		return
+	}
+	registerBuiltinTicker(pathTopFlush, flushPathCounts)
+	registerBuiltinTicker(time.Second, reportPathTop)
+}
+
+func (h *httpCtx) countPath() {
+	if config.PathTop.Interval <= 0 {
+		return
+	}
+	port, err := h.properties.destinationPort()
+	if err != nil {
+		return
+	}
+	path, _, _ := strings.Cut(h.getRequestHeader(":path"), "?")
+	if len(path) > maxPathLength {
+		path = path[:maxPathLength]
+	}
+	counts := pathCounts[port]
+	if counts == nil {
+		counts = map[string]int64{}
+		pathCounts[port] = counts
+	}
+	if _, ok := counts[path]; !ok && len(counts) >= config.PathTop.maxPaths() {
+		path = otherPaths
+	}
+	counts[path]++
+}
+
+// Keeps the max most requested paths.
+func capPathCounts(counts map[string]int64, max int) {
+	if len(counts) <= max {
+		return
+	}
+	sorted := sortPathCounts(counts)
+	for _, pc := range sorted[max:] {
+		delete(counts, pc.Path)
+	}
+}
+
+func sortPathCounts(counts map[string]int64) []PathCount {
+	sorted := make([]PathCount, 0, len(counts))
+	for path, n := range counts {
+		sorted = append(sorted, PathCount{path, n})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+	return sorted
+}
+
+// Merges this worker's counts into shared data.
+func flushPathCounts() {
+	for port, counts := range pathCounts {
+		p := strconv.FormatInt(port, 10)
+		_, err := pathTopKV.Update(pathTopCountsKey+p, func(old []byte) []byte {
+			merged := map[string]int64{}
+			json.Unmarshal(old, &merged)
+			for path, n := range counts {
+				merged[path] += n
+			}
+			capPathCounts(merged, config.PathTop.maxPaths())
+			b, _ := json.Marshal(merged)
+			return b
+		})
+		if err == nil {
+			err = pathTopKV.SetAdd(pathTopPortsKey, p)
+		}
+		if err != nil {
+			proxywasm.LogWarn("failed to flush path counts: " + err.Error())
+			continue
+		}
+		delete(pathCounts, port)
+	}
+}
+
+// Reports the top paths of every port once the interval passed. Every worker ticks, the first to
+// claim the report in shared data does it.
+func reportPathTop() {
+	c := config.PathTop
+	last, err := pathTopKV.Get(pathTopClaimKey)
+	start := time.Unix(0, decodeInt64(last))
+	if err != nil || Since(start) < time.Duration(c.Interval)*time.Second {
+		return
+	}
+	now := Now()
+	if claimed, _ := pathTopKV.CompareAndSwap(pathTopClaimKey, last, encodeInt64(now.UnixNano())); !claimed || last == nil {
+		// the first claim only starts the first interval
+		return
+	}
+
+	ports, err := pathTopKV.SetMembers(pathTopPortsKey)
+	if err != nil {
+		proxywasm.LogWarn("failed to read path counts: " + err.Error())
+		return
+	}
+	for _, p := range ports {
+		port, _ := strconv.ParseInt(p, 10, 64)
+		counts := map[string]int64{}
+		_, err := pathTopKV.Update(pathTopCountsKey+p, func(old []byte) []byte {
+			counts = map[string]int64{}
+			json.Unmarshal(old, &counts)
+			return []byte("{}")
+		})
+		if err != nil || len(counts) == 0 {
+			continue
+		}
+		report := PathReport{Port: port, Start: start, End: now, Paths: sortPathCounts(counts)}
+		if len(report.Paths) > c.top() {
+			report.Paths = report.Paths[:c.top()]
+		}
+		top := make([]string, len(report.Paths))
+		for i, pc := range report.Paths {
+			top[i] = fmt.Sprintf("%s=%d", pc.Path, pc.Count)
+		}
+		proxywasm.LogInfo(fmt.Sprintf("hot paths port=%d: %s", port, strings.Join(top, " ")))
+		b, _ := json.Marshal(report)
+		if err := pathTopKV.Set(pathTopLastKey+p, b); err != nil {
+			proxywasm.LogWarn("failed to store path report: " + err.Error())
+		}
+		publishEvent(TopicPaths, report)
+	}
+}
+
+// Latest report of the top paths of a port.
+func LastPathReport(port int64) (PathReport, bool) {
+	var report PathReport
+	b, err := pathTopKV.Get(pathTopLastKey + strconv.FormatInt(port, 10))
+	if err != nil || b == nil || json.Unmarshal(b, &report) != nil {
+		return report, false
+	}
+	return report, true
+}
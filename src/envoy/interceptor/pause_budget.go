@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// PauseFallback is the verdict applied to a stream which stayed paused by a When/Do longer than allowed.
+type PauseFallback int
+
+const (
+	// Log, stop intercepting the stream and resume it.
+	PauseFallbackContinue PauseFallback = iota
+	// Log and reject the stream with 504.
+	PauseFallbackBlock
+)
+
+// PauseBudget limits how long a stream may be held by a When/Do which keeps pausing (e.g. while buffering).
+// A Do finishing with a pause is a deliberate verdict and is not limited.
+type PauseBudget struct {
+	// Max consecutive paused callbacks (0 means unlimited). Pauses buffering a body which isn't complete
+	// yet don't count: a large upload pauses once per chunk.
+	MaxPauses int
+	// Max time a stream may stay paused (0 means unlimited)
+	MaxDuration time.Duration
+	Fallback    PauseFallback
+}
+
+var pauseBudget = PauseBudget{MaxDuration: 30 * time.Second}
+
+// Sets the pause budget of HTTP streams. Must be called at registration time.
+func SetPauseBudget(budget PauseBudget) {
+	pauseBudget = budget
+}
+
+// Streams currently held by a When/Do, context id -> stream
+var pausedStreams = map[uint32]*httpCtx{}
+
+// Checked on every tick while MaxDuration is set.
+func pauseBudgetTickPeriod() time.Duration {
+	period := pauseBudget.MaxDuration / 4
+	if period < 100*time.Millisecond {
+		period = 100 * time.Millisecond
+	}
+	if period > time.Second {
+		period = time.Second
+	}
+	return period
+}
+
+// Tracks the stream pause state after each callback; returns the fallback action once the budget is exceeded.
+func (h *httpCtx) trackPause(stage HttpStage, end bool, action types.Action) types.Action {
+	if action != types.ActionPause || h.finished {
+		h.clearPause()
+		return action
+	}
+	if buffering := (stage == StageRequestBody || stage == StageResponseBody) && !end; !buffering {
+		h.pauses++
+	}
+	if h.pausedAt.IsZero() {
+		h.pausedAt = Now()
+		pausedStreams[h.contextID] = h
+	}
+	if pauseBudget.MaxPauses > 0 && h.pauses > pauseBudget.MaxPauses {
+		return h.applyPauseBudget(stage, fmt.Sprintf("paused %d times", h.pauses), false)
+	}
+	return action
+}
+
+// The stream is no longer held by a When/Do.
+func (h *httpCtx) clearPause() {
+	h.pauses = 0
+	h.pausedAt = time.Time{}
+	delete(pausedStreams, h.contextID)
+}
+
+// Called from the plugin tick: releases streams paused for longer than MaxDuration. Streams delayed
+// by ResumeAfter are resumed on time anyway.
+func checkPausedStreams() {
+	if pauseBudget.MaxDuration <= 0 {
+		return
+	}
+	for id, h := range pausedStreams {
+		if _, delayed := delayedStreams[id]; delayed {
+			continue
+		}
+		paused := Since(h.pausedAt)
+		if paused <= pauseBudget.MaxDuration {
+			continue
+		}
+		delete(pausedStreams, id)
+		if err := proxywasm.SetEffectiveContext(id); err != nil {
+			continue
+		}
+		h.applyPauseBudget(h.lastStage, fmt.Sprintf("paused for %s", paused.Round(time.Millisecond)), true)
+	}
+}
+
+func (h *httpCtx) applyPauseBudget(stage HttpStage, reason string, resume bool) types.Action {
+	h.clearPause()
+	delete(delayedStreams, h.contextID)
+
+	if pauseBudget.Fallback == PauseFallbackBlock {
+		proxywasm.LogWarn(fmt.Sprintf("pause budget exceeded stage=%s %s, blocking", stage.String(), reason))
+		reply(504, nil, []byte("stream stuck"))
+		return h.finish(VerdictBlocked)
+	}
+
+	proxywasm.LogWarn(fmt.Sprintf("pause budget exceeded stage=%s %s, passing through", stage.String(), reason))
+	action := h.finish(VerdictContinue)
+	if resume {
+		if err := h.resumeStream(); err != nil {
+			proxywasm.LogWarn("failed to resume stream: " + err.Error())
+		}
+	}
+	return action
+}
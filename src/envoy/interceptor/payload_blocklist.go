@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Payload blocklist: requests whose body hashes (hex SHA-256) to a listed hash are rejected with
+// 403 once the body is complete. Every worker has the whole list in its config, so each keeps its
+// own set; reloading the config rebuilds it, dropping hashes no longer listed.
+
+var (
+	// Hashes from BlockPayloadHashes, loaded at plugin start
+	blockedPayloadHashes []string
+	// Lower case hex hashes of the loaded blocklist
+	blockedPayloads = map[string]bool{}
+	// Set once a blocklist is configured
+	payloadBlocklistEnabled bool
+)
+
+// Adds hex SHA-256 hashes of request bodies to the blocklist. Must be called at registration time.
+func BlockPayloadHashes(hashes ...string) {
+	blockedPayloadHashes = append(blockedPayloadHashes, hashes...)
+	payloadBlocklistEnabled = true
+}
+
+// Loads the hashes of BlockPayloadHashes and "payload_blocklist" in the plugin config.
+func loadPayloadBlocklist() error {
+	hashes := append(append([]string{}, blockedPayloadHashes...), config.PayloadBlocklist...)
+	loaded := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if d, err := hex.DecodeString(h); err != nil || len(d) != 32 {
+			return fmt.Errorf("invalid payload hash %q", h)
+		}
+		loaded[h] = true
+	}
+	blockedPayloads = loaded
+	payloadBlocklistEnabled = len(loaded) > 0
+	if len(loaded) > 0 {
+		proxywasm.LogInfo(fmt.Sprintf("loaded %d blocklisted payload hashes", len(loaded)))
+	}
+	return nil
+}
+
+func isBlockedPayload(digest, ip string) bool {
+	if !blockedPayloads[digest] {
+		return false
+	}
+	proxywasm.LogInfo("rejected blocklisted payload " + digest + " from " + ip)
+	return true
+}
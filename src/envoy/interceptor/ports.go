@@ -0,0 +1,26 @@
+package main
+
+// Services often expose several ports behaving the same; these register one interceptor on each.
+
+// Ports from first to last, inclusive.
+func PortRange(first, last int64) []int64 {
+	var ports []int64
+	for p := first; p <= last; p++ {
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// Registers an interceptor on each of the ports, e.g. PortRange(3000, 3002).
+func RegisterHttpInterceptorPorts(ports []int64, name string, when func(*HttpWhenContext) bool, do func(*HttpDoContext) Verdict, opts ...HttpInterceptorOption) {
+	for _, port := range ports {
+		RegisterHttpInterceptor(port, name, when, do, opts...)
+	}
+}
+
+// Registers an interceptor on each of the ports.
+func RegisterTcpInterceptorPorts(ports []int64, name string, when func(*TcpWhenContext) bool, do func(*TcpDoContext) Verdict, opts ...TcpInterceptorOption) {
+	for _, port := range ports {
+		RegisterTcpInterceptor(port, name, when, do, opts...)
+	}
+}
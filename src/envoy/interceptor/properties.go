@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Commonly used Envoy attributes, see
+// https://www.envoyproxy.io/docs/envoy/latest/intro/arch_overview/advanced/attributes
+var (
+	// string, downstream "ip:port"
+	PropSourceAddress = []string{"source", "address"}
+	// int, downstream port
+	PropSourcePort = []string{"source", "port"}
+	// string, original destination "ip:port"
+	PropDestinationAddress = []string{"destination", "address"}
+	// int, original destination port (the service port interceptors are registered on)
+	PropDestinationPort = []string{"destination", "port"}
+	// int, downstream connection id, unique within the Envoy process
+	PropConnectionID = []string{"connection", "id"}
+	// bool, whether the client presented a certificate (mutual TLS); a plaintext or one-way TLS
+	// connection has no TLS version, see PropConnectionTlsVersion
+	PropConnectionMtls = []string{"connection", "mtls"}
+	// string, SNI requested by the client
+	PropConnectionSni = []string{"connection", "requested_server_name"}
+	// string, TLS version of the downstream connection (missing for plaintext)
+	PropConnectionTlsVersion = []string{"connection", "tls_version"}
+	// timestamp, time the first request byte was received
+	PropRequestTime = []string{"request", "time"}
+	// duration, total request time so far
+	PropRequestDuration = []string{"request", "duration"}
+	// string, x-request-id
+	PropRequestID = []string{"request", "id"}
+	// string, upstream cluster name
+	PropUpstreamCluster = []string{"xds", "cluster_name"}
+	// string, matched route name
+	PropRouteName = []string{"xds", "route_name"}
+	// string, upstream host "ip:port"
+	PropUpstreamAddress = []string{"upstream", "address"}
+	// int, response status code
+	PropResponseCode = []string{"response", "code"}
+	// int, traffic direction of the listener (0 unspecified, 1 inbound, 2 outbound)
+	PropListenerDirection = []string{"listener_direction"}
+	// string, vm_id of the plugin's VM
+	PropPluginVmId = []string{"plugin_vm_id"}
+)
+
+// Reads a property; a missing property is reported as an error.
+func getProperty(path []string) ([]byte, error) {
+	v, err := proxywasm.GetProperty(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get property %v: %w", path, err)
+	}
+	return v, nil
+}
+
+func getIntProperty(path []string) (int64, error) {
+	v, err := getProperty(path)
+	if err != nil {
+		return 0, err
+	}
+	return parseIntProperty(path, v)
+}
+
+func getStringProperty(path []string) (string, error) {
+	v, err := getProperty(path)
+	if err != nil {
+		return "", err
+	}
+	return parseStringProperty(path, v)
+}
+
+func getBoolProperty(path []string) (bool, error) {
+	v, err := getProperty(path)
+	if err != nil {
+		return false, err
+	}
+	return parseBoolProperty(path, v)
+}
+
+func getTimestampProperty(path []string) (time.Time, error) {
+	v, err := getProperty(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTimestampProperty(path, v)
+}
+
+func getDurationProperty(path []string) (time.Duration, error) {
+	v, err := getProperty(path)
+	if err != nil {
+		return 0, err
+	}
+	return parseDurationProperty(path, v)
+}
+
+// Ints are serialized as 8 byte little-endian.
+func parseIntProperty(path []string, v []byte) (int64, error) {
+	if v == nil {
+		return 0, fmt.Errorf("property %v not found", path)
+	}
+	if len(v) != 8 {
+		return 0, fmt.Errorf("unexpected property %v length: %d", path, len(v))
+	}
+	return int64(binary.LittleEndian.Uint64(v)), nil
+}
+
+func parseStringProperty(path []string, v []byte) (string, error) {
+	if v == nil {
+		return "", fmt.Errorf("property %v not found", path)
+	}
+	return string(v), nil
+}
+
+// Bools are serialized as a single byte.
+func parseBoolProperty(path []string, v []byte) (bool, error) {
+	if v == nil {
+		return false, fmt.Errorf("property %v not found", path)
+	}
+	if len(v) != 1 {
+		return false, fmt.Errorf("unexpected property %v length: %d", path, len(v))
+	}
+	return v[0] != 0, nil
+}
+
+// Timestamps are serialized as int nanoseconds since epoch.
+func parseTimestampProperty(path []string, v []byte) (time.Time, error) {
+	ns, err := parseIntProperty(path, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ns), nil
+}
+
+// Durations are serialized as int nanoseconds.
+func parseDurationProperty(path []string, v []byte) (time.Duration, error) {
+	ns, err := parseIntProperty(path, v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ns), nil
+}
+
+type cachedProperty struct {
+	value []byte
+	err   error
+}
+
+// Host properties fetched once per stream, keyed by the joined path.
+// Only use it for properties which don't change during the stream.
+type propertyCache map[string]cachedProperty
+
+func (c *propertyCache) get(path []string) ([]byte, error) {
+	key := strings.Join(path, ".")
+	if p, ok := (*c)[key]; ok {
+		return p.value, p.err
+	}
+	if *c == nil {
+		*c = propertyCache{}
+	}
+	v, err := getProperty(path)
+	(*c)[key] = cachedProperty{value: v, err: err}
+	return v, err
+}
+
+// Drops cached values, so the next get fetches them again.
+func (c propertyCache) forget(paths ...[]string) {
+	for _, path := range paths {
+		delete(c, strings.Join(path, "."))
+	}
+}
+
+func (c *propertyCache) getInt(path []string) (int64, error) {
+	v, err := c.get(path)
+	if err != nil {
+		return 0, err
+	}
+	return parseIntProperty(path, v)
+}
+
+func (c *propertyCache) getString(path []string) (string, error) {
+	v, err := c.get(path)
+	if err != nil {
+		return "", err
+	}
+	return parseStringProperty(path, v)
+}
+
+func (c *propertyCache) getBool(path []string) (bool, error) {
+	v, err := c.get(path)
+	if err != nil {
+		return false, err
+	}
+	return parseBoolProperty(path, v)
+}
+
+func (c *propertyCache) getTimestamp(path []string) (time.Time, error) {
+	v, err := c.get(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTimestampProperty(path, v)
+}
+
+func (c *propertyCache) destinationPort() (int64, error) {
+	return c.getInt(PropDestinationPort)
+}
+
+func (c *propertyCache) connectionID() (int64, error) {
+	return c.getInt(PropConnectionID)
+}
+
+func (c *propertyCache) sourceAddress() (string, error) {
+	return c.getString(PropSourceAddress)
+}
@@ -1,3 +1,5 @@
+//go:build !tinygo
+
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
 // 	protoc-gen-go v1.36.9
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// Publish/subscribe over Envoy shared queues, "pubsub" in the plugin config. A topic is a queue per
+// VM (vm_id), so messages reach the VMs listed in VmIds (this one if empty), e.g. both the HTTP and
+// the TCP interceptor, or a collector plugin. Within a VM each message is handled by one worker.
+type pubSubConfig struct {
+	// VMs to publish to
+	VmIds []string `json:"vm_ids"`
+	// Publish interceptor events (TopicEvents) and ban updates (TopicBans)
+	Events bool `json:"events"`
+}
+
+const (
+	// Event JSON of each match and block
+	TopicEvents = "events"
+	// BanUpdate JSON of each ban and unban
+	TopicBans = "bans"
+)
+
+type BanUpdate struct {
+	Source string `json:"source"`
+	// Zero when unbanned
+	Until time.Time `json:"until"`
+}
+
+var (
+	subscriptions = map[string][]func([]byte){}
+	// queue ID to topic, for queues registered by this VM
+	subscribedQueues = map[uint32]string{}
+	// queue IDs by "<vm_id>/<topic>"
+	resolvedQueues = map[string]uint32{}
+)
+
+func topicQueue(topic string) string { return "pubsub/" + topic }
+
+// Calls handler with each message published to topic. Must be called before plugin start, like
+// interceptor registration.
+func Subscribe(topic string, handler func(data []byte)) {
+	subscriptions[topic] = append(subscriptions[topic], handler)
+}
+
+// Registers the queues of the subscribed topics, at plugin start.
+func registerSubscriptions() error {
+	for topic := range subscriptions {
+		id, err := proxywasm.RegisterSharedQueue(topicQueue(topic))
+		if err != nil {
+			return errors.New("failed to register queue for topic " + topic + ": " + err.Error())
+		}
+		subscribedQueues[id] = topic
+	}
+	return nil
+}
+
+// Sends data to the subscribers of topic in every configured VM; VMs without subscribers are skipped.
+func Publish(topic string, data []byte) error {
+	if len(data) == 0 {
+		// the host can't enqueue empty messages
+		return errors.New("empty message")
+	}
+	vmIds := config.PubSub.VmIds
+	if len(vmIds) == 0 {
+		vmIds = []string{ownVmId()}
+	}
+	var errs []error
+	for _, vmId := range vmIds {
+		key := vmId + "/" + topic
+		id, ok := resolvedQueues[key]
+		if !ok {
+			var err error
+			id, err = proxywasm.ResolveSharedQueue(vmId, topicQueue(topic))
+			if errors.Is(err, types.ErrorStatusNotFound) {
+				continue
+			} else if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			resolvedQueues[key] = id
+		}
+		if err := proxywasm.EnqueueSharedQueue(id, data); err != nil {
+			delete(resolvedQueues, key)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Publishes v as JSON if interceptor events are to be published.
+func publishEvent(topic string, v interface{}) {
+	if !config.PubSub.Events {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = Publish(topic, data)
+	}
+	if err != nil {
+		proxywasm.LogWarn("failed to publish to " + topic + ": " + err.Error())
+	}
+}
+
+func ownVmId() string {
+	v, err := getProperty(PropPluginVmId)
+	if err != nil {
+		return ""
+	}
+	return string(v)
+}
+
+func (ctx *pluginContext) OnQueueReady(queueID uint32) {
+	topic, ok := subscribedQueues[queueID]
+	if !ok {
+		return
+	}
+	for {
+		data, err := proxywasm.DequeueSharedQueue(queueID)
+		if errors.Is(err, types.ErrorStatusEmpty) {
+			return
+		} else if err != nil {
+			proxywasm.LogWarn("failed to dequeue from " + topic + ": " + err.Error())
+			return
+		}
+		for _, handler := range subscriptions[topic] {
+			handler(data)
+		}
+	}
+}
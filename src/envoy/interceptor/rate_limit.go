@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyExtractor derives the rate-limit key of a request.
+type KeyExtractor struct {
+	// Whether the key is read from the request body (the request is buffered until the body is complete)
+	NeedsBody bool
+	// Returns the key ("" to not limit the request); body is nil unless NeedsBody
+	Extract func(ctx *HttpWhenContext, body []byte) string
+}
+
+// Keys requests by downstream IP (IPv6: by network, see sourceKey).
+var KeySourceIP = KeyExtractor{
+	Extract: func(ctx *HttpWhenContext, _ []byte) string { return sourceKey(ctx.SourceIP) },
+}
+
+// Keys requests by source team (see teamsConfig), or IP for sources outside team networks.
+var KeySourceTeam = KeyExtractor{
+	Extract: func(ctx *HttpWhenContext, _ []byte) string {
+		if team := ctx.SourceTeam(); team != "" {
+			return "team:" + team
+		}
+		return sourceKey(ctx.SourceIP)
+	},
+}
+
+// Keys requests by a header value (e.g. an API key).
+func KeyHeader(name string) KeyExtractor {
+	return KeyExtractor{
+		Extract: func(ctx *HttpWhenContext, _ []byte) string { return ctx.GetRequestHeader(name) },
+	}
+}
+
+// Keys requests by a cookie value.
+func KeyCookie(name string) KeyExtractor {
+	return KeyExtractor{
+		Extract: func(ctx *HttpWhenContext, _ []byte) string {
+			return cookieValue(ctx.GetRequestHeader("cookie"), name)
+		},
+	}
+}
+
+// Value of a cookie in a Cookie header, "" if absent.
+func cookieValue(header, name string) string {
+	for _, c := range strings.Split(header, ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(c), "=")
+		if k == name {
+			return v
+		}
+	}
+	return ""
+}
+
+// Keys requests by a field of a JSON body, path separated by dots (e.g. "user.name").
+func KeyJsonField(path string) KeyExtractor {
+	return KeyExtractor{
+		NeedsBody: true,
+		Extract: func(_ *HttpWhenContext, body []byte) string {
+			var v interface{}
+			if json.Unmarshal(body, &v) != nil {
+				return ""
+			}
+			for _, field := range strings.Split(path, ".") {
+				obj, ok := v.(map[string]interface{})
+				if !ok {
+					return ""
+				}
+				v = obj[field]
+			}
+			switch v := v.(type) {
+			case string:
+				return v
+			case float64:
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				return strconv.FormatBool(v)
+			default:
+				return ""
+			}
+		},
+	}
+}
+
+// Extracts the key of a request, reading the body if needed. ok is false while the body is still
+// arriving (the stream is then paused); bodySize keeps the buffered size for the trailers stage.
+func (k KeyExtractor) extract(ctx *HttpWhenContext, bodySize *int) (key string, ok bool) {
+	switch {
+	case !k.NeedsBody || (ctx.Stage == StageRequestHeaders && ctx.End):
+		return k.Extract(ctx, nil), true
+	case ctx.Stage == StageRequestHeaders:
+		return "", false
+	case ctx.Stage == StageRequestBody && !ctx.End:
+		*bodySize = ctx.BodySize
+		ctx.Pause()
+		return "", false
+	}
+	size := ctx.BodySize
+	if ctx.Stage == StageRequestTrailers {
+		size = *bodySize
+	}
+	body, err := ctx.GetRequestBody(0, size)
+	if err != nil {
+		return "", true
+	}
+	return k.Extract(ctx, body), true
+}
+
+// RateLimit allows Limit requests per Window for every key.
+type RateLimit struct {
+	// Unique name, used for shared data keys and per-key limits in the plugin config
+	Name   string
+	Key    KeyExtractor
+	Limit  int
+	Window time.Duration
+	// Restricts the limit to some requests (all if nil)
+	Match func(*HttpWhenContext) bool
+}
+
+type rateLimitState struct {
+	// buffered request body size, for extracting at the trailers stage
+	bodySize int
+	// set once the request was counted or skipped
+	done bool
+}
+
+// Registers a rate limit on a port; requests over the limit get 429. Per-key limits can be
+// overridden by "rate_limits": {"<name>": {"<key>": <limit>}} in the plugin config.
+func RegisterRateLimit(port int64, rl RateLimit) {
+	rateLimitNames[rl.Name] = true
+	RegisterHttpInterceptor(port, "rate limit "+rl.Name, func(ctx *HttpWhenContext) bool {
+		state := State[rateLimitState](ctx)
+		if state.done {
+			return false
+		}
+		if ctx.Stage == StageRequestHeaders && rl.Match != nil && !rl.Match(ctx) {
+			state.done = true
+			return false
+		}
+
+		key, ok := rl.Key.extract(ctx, &state.bodySize)
+		if !ok {
+			return false
+		}
+		state.done = true
+		return key != "" && rl.exceeded(key, ctx)
+	}, DoRateLimited, WithStages(StageRequestHeaders, StageRequestBody, StageRequestTrailers))
+}
+
+// Counters of keys beyond its size share slots, which resets the window of the evicted key.
+var rateLimitKV = NewBoundedKV("ratelimit", 65536)
+
+// Names of the registered rate limits, which "rate_limits" in the plugin config refers to
+var rateLimitNames = map[string]bool{}
+
+// Counts a request for key and reports whether it is over the limit.
+func (rl RateLimit) exceeded(key string, ctx *HttpWhenContext) bool {
+	limit := rl.Limit
+	if l, ok := config.RateLimits[rl.Name][key]; ok {
+		limit = l
+	}
+	count, err := rateLimitKV.IncrementWindow(rl.Name+"/"+key, rl.Window)
+	if err != nil {
+		ctx.LogInfo("failed to count request: " + err.Error())
+		return false
+	}
+	if count > int64(limit) {
+		ctx.LogInfo(fmt.Sprintf("rate limited key=%s count=%d limit=%d", key, count, limit))
+		return true
+	}
+	return false
+}
+
+// Rejects the stream with 429.
+func DoRateLimited(ctx *HttpDoContext) Verdict {
+	reply(429, nil, []byte("too many requests"))
+	return VerdictBlocked
+}
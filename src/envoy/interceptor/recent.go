@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// The last events (matches, blocks...) are kept in a ring in shared data, so operators can see
+// what just happened through GET /recent on the admin API instead of trawling Envoy logs. Event
+// number seq goes to slot seq % size, overwriting the oldest.
+var recentKV = NewKV("recent")
+
+const defaultRecentEvents = 100
+
+type recentEvent struct {
+	Seq   int64 `json:"seq"`
+	Event Event `json:"event"`
+}
+
+// Ring size, "recent_events" in the plugin config (negative disables the ring).
+func recentSize() int64 {
+	if config.RecentEvents == 0 {
+		return defaultRecentEvents
+	}
+	return int64(config.RecentEvents)
+}
+
+func recordRecent(e Event) {
+	size := recentSize()
+	if size <= 0 {
+		return
+	}
+	seq, err := recentKV.Increment("seq", 1)
+	if err != nil {
+		return
+	}
+	b, _ := json.Marshal(recentEvent{Seq: seq, Event: e})
+	recentKV.Set(strconv.FormatInt(seq%size, 10), b)
+}
+
+// Returns up to limit of the last events, newest first.
+func RecentEvents(limit int) ([]Event, error) {
+	size := recentSize()
+	seq, err := recentKV.GetInt("seq")
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	for s := seq; s > 0 && s > seq-size && len(events) < limit; s-- {
+		b, err := recentKV.Get(strconv.FormatInt(s%size, 10))
+		if err != nil {
+			return nil, err
+		}
+		var r recentEvent
+		// the slot may not be written yet, or already hold a newer event
+		if json.Unmarshal(b, &r) != nil || r.Seq != s {
+			continue
+		}
+		events = append(events, r.Event)
+	}
+	return events, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Body bytes kept per direction by DoRecordAndContinue
+const maxRecordedBody = 256 << 10
+
+type flightRecording struct {
+	shipped      bool
+	record       FlowRecord
+	requestBody  BodyReader
+	responseBody BodyReader
+}
+
+// Records the matched stream as a flow record with its full request (and the response if
+// withResponse), sent to the flow record collector like any other record but regardless of
+// flow_records.enabled. Traffic is not held back and bodies keep their framing: for evidence on a
+// suspected new exploit without disrupting it. Bodies are kept up to 256 KiB; bytes passed on
+// before the match are missing, so match at the request headers to get the whole body. Register
+// the interceptor WithDone(FlushRecording) to also ship streams that were reset or ended early.
+func DoRecordAndContinue(withResponse bool) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		s := State[flightRecording](ctx)
+		if s.shipped {
+			return VerdictContinue
+		}
+		r := &s.record
+		if r.Start.IsZero() {
+			r.Start = Now()
+			r.Port = ctx.Port
+			r.RequestID = ctx.RequestID
+			r.Source = ctx.SourceIP
+			r.Team = TeamOf(ctx.SourceIP)
+			if ctx.TLS != nil {
+				r.JA3 = ctx.TLS.JA3
+			}
+			r.Method = ctx.GetRequestHeader(":method")
+			r.Path = ctx.GetRequestHeader(":path")
+			r.RequestHeaders, _ = proxywasm.GetHttpRequestHeaders()
+			r.Matched = []string{ctx.interceptor.Name}
+			r.Recorded = true
+		}
+
+		done := false
+		switch ctx.Stage {
+		case StageRequestHeaders:
+			done = ctx.End && !withResponse
+		case StageRequestBody:
+			r.RequestBody = appendRecorded(r.RequestBody, &s.requestBody, ctx.BodyOffset, ctx.BodySize, ctx.GetRequestBody)
+			r.RequestBytes = ctx.BodyOffset + ctx.BodySize
+			done = ctx.End && !withResponse
+		case StageRequestTrailers:
+			done = !withResponse
+		case StageResponseHeaders:
+			r.ResponseHeaders, _ = proxywasm.GetHttpResponseHeaders()
+			r.Status = ctx.GetResponseHeader(":status")
+			done = ctx.End
+		case StageResponseBody:
+			r.ResponseBody = appendRecorded(r.ResponseBody, &s.responseBody, ctx.BodyOffset, ctx.BodySize, ctx.GetResponseBody)
+			r.ResponseBytes = ctx.BodyOffset + ctx.BodySize
+			done = ctx.End
+		case StageResponseTrailers:
+			done = true
+		}
+		if done {
+			s.ship(VerdictContinue, ctx.Stage)
+		}
+		// not VerdictModified, which ends the stream's interceptors under MatchFirst
+		return VerdictContinue
+	}
+}
+
+// Done hook shipping what DoRecordAndContinue recorded of a stream it didn't see to the end, e.g.
+// one the client reset or another interceptor blocked.
+func FlushRecording(ctx *HttpDoneContext) {
+	s, ok := ctx.DoData.(*flightRecording)
+	if !ok || s.record.Start.IsZero() {
+		return
+	}
+	s.ship(ctx.Verdict, ctx.LastStage)
+}
+
+func (s *flightRecording) ship(v Verdict, last HttpStage) {
+	if s.shipped {
+		return
+	}
+	r := &s.record
+	r.DurationMs = Since(r.Start).Milliseconds()
+	r.Verdict = v.String()
+	r.LastStage = last.String()
+	incrCounter("interceptor_flight_recordings")
+	queueFlow(*r)
+	s.shipped = true
+}
+
+func appendRecorded(body []byte, r *BodyReader, offset, size int, get func(int, int) ([]byte, error)) []byte {
+	chunk, err := r.Next(offset, size, get)
+	if err != nil {
+		return body
+	}
+	if room := maxRecordedBody - len(body); len(chunk) > room {
+		chunk = chunk[:max(room, 0)]
+	}
+	return append(body, chunk...)
+}
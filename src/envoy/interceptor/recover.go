@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// PanicPolicy decides what happens to a stream when an interceptor callback panics.
+type PanicPolicy int
+
+const (
+	// Stop intercepting the stream and let it through.
+	FailOpen PanicPolicy = iota
+	// Block the stream (403 for HTTP, closed connection for TCP).
+	FailClosed
+)
+
+var panicPolicy = FailOpen
+
+// Sets how streams are handled after an interceptor panic.
+func SetPanicPolicy(policy PanicPolicy) {
+	panicPolicy = policy
+}
+
+// Calls a When/Do function, converting a panic into a logged error.
+func safeCall[T, R any](kind, name string, f func(T) R, arg T) (result R, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			recordPanic(kind, name, r)
+			var zero R
+			result, panicked = zero, true
+		}
+	}()
+	return f(arg), false
+}
+
+func recordPanic(kind, name string, r interface{}) {
+	proxywasm.LogError(fmt.Sprintf("[%s (%s)] panic: %v", name, kind, r))
+	incrCounter("interceptor_panics")
+}
+
+// Stops intercepting the stream according to the panic policy.
+func (h *httpCtx) failStream() types.Action {
+	if panicPolicy == FailClosed {
+		reply(403, nil, []byte("blocked"))
+		return h.finish(VerdictBlocked)
+	}
+	return h.finish(VerdictContinue)
+}
+
+// Stops intercepting the connection according to the panic policy.
+func (ctx *tcpCtx) failStream() types.Action {
+	if panicPolicy == FailClosed {
+		proxywasm.CloseDownstream()
+		proxywasm.CloseUpstream()
+		return ctx.finish(VerdictDropped)
+	}
+	return ctx.finish(VerdictContinue)
+}
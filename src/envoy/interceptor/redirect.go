@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// Redirects of a source to one target within the window, beyond which it is a loop
+	maxRedirectHops     = 10
+	redirectLoopWindow  = 10 * time.Second
+	redirectLoopStatus  = 508
+	defaultRedirectPath = "/"
+)
+
+// Redirect counts per source and target; targets come from clients, so the counts are bounded.
+var redirectKV = NewBoundedKV("redirect", 65536)
+
+// Matches responses redirecting to a host other than the request's and those allowed, e.g.
+// open redirects used to phish with the service's domain. Allowed hosts may be "*.example.com"
+// for subdomains. Locations in other schemes (javascript:, data:) are never allowed.
+func WhenOpenRedirect(allowed ...string) func(*HttpWhenContext) bool {
+	return func(ctx *HttpWhenContext) bool {
+		if ctx.Stage != StageResponseHeaders {
+			return false
+		}
+		host, external := redirectHost(ctx.GetResponseHeader("location"))
+		if !external || redirectAllowed(host, hostOnly(ctx.GetRequestHeader(":authority")), allowed) {
+			return false
+		}
+		ctx.LogInfo("open redirect to " + host)
+		return true
+	}
+}
+
+// Host a Location leads to, parsed the lenient way browsers do (backslashes as slashes, missing
+// slashes after the scheme, tabs and newlines ignored). Reports false for relative locations.
+func redirectHost(location string) (string, bool) {
+	location = strings.Map(func(r rune) rune {
+		switch r {
+		case '\t', '\r', '\n':
+			return -1
+		case '\\':
+			return '/'
+		}
+		return r
+	}, strings.TrimSpace(location))
+
+	var rest string
+	if i := strings.IndexAny(location, ":/?#"); i > 0 && location[i] == ':' {
+		scheme := strings.ToLower(location[:i])
+		if scheme != "http" && scheme != "https" {
+			return scheme + ":", true
+		}
+		rest = strings.TrimLeft(location[i+1:], "/")
+	} else if strings.HasPrefix(location, "//") {
+		rest = strings.TrimLeft(location, "/")
+	} else {
+		return "", false
+	}
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = rest[i+1:]
+	}
+	return hostOnly(rest), true
+}
+
+// Host of a "host[:port]" authority, lower case.
+func hostOnly(authority string) string {
+	if host, _, err := net.SplitHostPort(authority); err == nil {
+		authority = host
+	}
+	return strings.ToLower(strings.Trim(authority, "[]."))
+}
+
+func redirectAllowed(host, own string, allowed []string) bool {
+	if host == own {
+		return true
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if host == a || strings.HasPrefix(a, "*.") && strings.HasSuffix(host, a[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether redirecting the request to target would loop: the target is the request
+// itself, or the source was sent there too often lately.
+func redirectLoops(ctx *HttpDoContext, target string) bool {
+	if target == ctx.GetRequestHeader(":path") {
+		return true
+	}
+	hops, err := redirectKV.IncrementWindow(sourceKey(ctx.SourceIP)+" "+target, redirectLoopWindow)
+	return err == nil && hops > maxRedirectHops
+}
+
+func replyRedirectLoop(ctx *HttpDoContext, target string) Verdict {
+	ctx.LogWarn("redirect loop to " + target + ", not redirecting")
+	incrCounter("interceptor_redirect_loops")
+	reply(redirectLoopStatus, nil, []byte("redirect loop"))
+	return VerdictBlocked
+}
+
+// Replaces the Location of a redirect matched by WhenOpenRedirect with to ("/" if empty).
+func DoRewriteRedirect(to string) func(*HttpDoContext) Verdict {
+	if to == "" {
+		to = defaultRedirectPath
+	}
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		if ctx.Stage != StageResponseHeaders {
+			return VerdictContinue
+		}
+		if redirectLoops(ctx, to) {
+			return replyRedirectLoop(ctx, to)
+		}
+		ctx.LogInfo("rewrote redirect to " + to)
+		ctx.SetResponseHeader("location", to)
+		return VerdictModified
+	}
+}
+
+// Redirects the request to location with a 302, unless that would loop (then 508).
+func DoRedirect(location string) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		if redirectLoops(ctx, location) {
+			return replyRedirectLoop(ctx, location)
+		}
+		reply(302, [][2]string{{"location", location}}, nil)
+		return VerdictBlocked
+	}
+}
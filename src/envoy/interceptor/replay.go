@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ReplayProtection describes how requests carry a client nonce.
+type ReplayProtection struct {
+	// Header carrying the client nonce
+	NonceHeader string
+	// Header carrying the request time in unix seconds or milliseconds ("" to check the nonce only)
+	TimestampHeader string
+	// Max age (and future skew) of the timestamp, 5 minutes if 0
+	MaxAge time.Duration
+	// Restricts the check to some requests (all requests carrying the nonce header if nil)
+	Match func(*HttpWhenContext) bool
+	// Nonces remembered, 16384 if 0; older ones are forgotten as new ones come in
+	MaxNonces int
+}
+
+const (
+	defaultReplayMaxAge    = 5 * time.Minute
+	defaultReplayMaxNonces = 16384
+)
+
+// Registers an interceptor rejecting requests with a reused nonce or a stale timestamp with 409.
+// Seen nonces are kept in a bounded table, so a nonce may be reused once MaxNonces newer ones were
+// seen: set TimestampHeader so such a replay is also stale.
+func RegisterReplayProtection(port int64, opts ReplayProtection) {
+	if opts.MaxAge == 0 {
+		opts.MaxAge = defaultReplayMaxAge
+	}
+	if opts.MaxNonces == 0 {
+		opts.MaxNonces = defaultReplayMaxNonces
+	}
+	nonces := NewBoundedKV(fmt.Sprintf("nonce/%d/%s", port, opts.NonceHeader), opts.MaxNonces)
+	RegisterHttpInterceptor(port, "replay "+opts.NonceHeader, func(ctx *HttpWhenContext) bool {
+		nonce := ctx.GetRequestHeader(opts.NonceHeader)
+		if nonce == "" || (opts.Match != nil && !opts.Match(ctx)) {
+			return false
+		}
+		if opts.TimestampHeader != "" {
+			if reason := staleTimestamp(ctx.GetRequestHeader(opts.TimestampHeader), opts.MaxAge); reason != "" {
+				ctx.LogInfo(reason)
+				return true
+			}
+		}
+		if reused, err := recordNonce(nonces, nonce); err != nil {
+			ctx.LogInfo("failed to record nonce: " + err.Error())
+		} else if reused {
+			ctx.LogInfo("nonce reused: " + nonce)
+			return true
+		}
+		return false
+	}, DoRejectReplay, WithStages(StageRequestHeaders))
+}
+
+// Rejects the stream with 409.
+func DoRejectReplay(ctx *HttpDoContext) Verdict {
+	reply(409, nil, []byte("replayed request"))
+	return VerdictBlocked
+}
+
+// Returns why a timestamp header value is rejected, "" if it is fresh.
+func staleTimestamp(value string, maxAge time.Duration) string {
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Sprintf("invalid timestamp %q", value)
+	}
+	var t time.Time
+	// anything past year 33658 in seconds is milliseconds
+	if ts > 1e12 {
+		t = time.UnixMilli(ts)
+	} else {
+		t = time.Unix(ts, 0)
+	}
+	if age := Since(t); age > maxAge || age < -maxAge {
+		return fmt.Sprintf("stale timestamp %s (age %s)", value, age.Round(time.Second))
+	}
+	return ""
+}
+
+// Stores the nonce and reports whether it was seen before. Concurrent first uses on different
+// workers may both pass, as a missing key can't be created atomically.
+func recordNonce(nonces BoundedKV, nonce string) (bool, error) {
+	reused := false
+	_, err := nonces.Update(nonce, func(old []byte) []byte {
+		reused = old != nil
+		return encodeInt64(Now().UnixNano())
+	})
+	return reused, err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStaleTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	SetClock(NewFakeClock(now))
+	defer SetClock(nil)
+
+	secs := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).Unix(), 10) }
+	millis := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).UnixMilli(), 10) }
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{secs(0), ""},
+		{secs(-30 * time.Second), ""},
+		{secs(30 * time.Second), ""},
+		{secs(-31 * time.Second), "stale timestamp " + secs(-31*time.Second) + " (age 31s)"},
+		{secs(31 * time.Second), "stale timestamp " + secs(31*time.Second) + " (age -31s)"},
+		{millis(-1500 * time.Millisecond), ""},
+		{millis(-40 * time.Second), "stale timestamp " + millis(-40*time.Second) + " (age 40s)"},
+		{"", `invalid timestamp ""`},
+		{"1700000000.5", `invalid timestamp "1700000000.5"`},
+	}
+	for _, tt := range tests {
+		if got := staleTimestamp(tt.value, 30*time.Second); got != tt.want {
+			t.Errorf("staleTimestamp(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+const requestIDHeader = "x-request-id"
+
+// Gives requests arriving without x-request-id one (a random UUID) before anything else sees
+// them, so logs, events, flow records and the upstream can all be correlated. Envoy normally
+// generates it (generate_request_id), this covers listeners which don't.
+func (h *httpCtx) ensureRequestID() {
+	if h.getRequestHeader(requestIDHeader) != "" {
+		return
+	}
+	id := newRequestID()
+	if err := proxywasm.ReplaceHttpRequestHeader(requestIDHeader, id); err != nil {
+		proxywasm.LogWarn("failed to set request id: " + err.Error())
+		return
+	}
+	h.requestHeaders.set(requestIDHeader, id)
+}
+
+// Random (version 4) UUID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
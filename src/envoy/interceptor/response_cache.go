@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// ResponseCache serves repeated identical GETs from shared data, so a scraping storm hits the
+// service once per TTL. Only complete 200 responses without set-cookie or trailers are cached.
+// Checkers are never served from the cache (their Do never runs), so they always see the service
+// itself.
+type ResponseCache struct {
+	// Time a response is served from the cache (2s if 0)
+	TTL time.Duration
+	// Largest body cached (64 KiB if 0)
+	MaxSize int
+	// Responses kept per port (256 if 0); the oldest are evicted
+	MaxEntries int
+	// Request headers telling requests apart besides method, authority and path (authorization,
+	// cookie, accept and accept-encoding if nil), so sessions never see each other's responses
+	Vary []string
+	// Requests always forwarded, e.g. those of a flag-placing user
+	Bypass func(ctx *HttpWhenContext) bool
+}
+
+type cachedResponse struct {
+	Status  uint32      `json:"status"`
+	Headers [][2]string `json:"headers"`
+	Body    []byte      `json:"body"`
+	Expires time.Time   `json:"expires"`
+}
+
+type responseCacheState struct {
+	key      string
+	response *cachedResponse
+	body     BodyReader
+}
+
+var responseCacheKV = NewKV("response-cache")
+
+// Response headers not replayed from the cache.
+var uncachedHeaders = map[string]bool{
+	"content-length":    true,
+	"transfer-encoding": true,
+	"connection":        true,
+	"keep-alive":        true,
+	"date":              true,
+	"age":               true,
+}
+
+func (c ResponseCache) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return 2 * time.Second
+	}
+	return c.TTL
+}
+
+func (c ResponseCache) maxSize() int {
+	if c.MaxSize <= 0 {
+		return 64 << 10
+	}
+	return c.MaxSize
+}
+
+func (c ResponseCache) maxEntries() int {
+	if c.MaxEntries <= 0 {
+		return 256
+	}
+	return c.MaxEntries
+}
+
+func (c ResponseCache) vary() []string {
+	if c.Vary == nil {
+		return []string{"authorization", "cookie", "accept", "accept-encoding"}
+	}
+	return c.Vary
+}
+
+func (c ResponseCache) key(ctx *HttpWhenContext, port int64) string {
+	h := sha256.New()
+	for _, name := range append([]string{":authority", ":path"}, c.vary()...) {
+		h.Write([]byte(ctx.GetRequestHeader(name)))
+		h.Write([]byte{0})
+	}
+	return strconv.FormatInt(port, 10) + "/" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Caches GET responses of matching paths on a port. Register it after the port's other
+// interceptors: with MatchFirst, they don't see the requests it matches.
+func RegisterResponseCache(port int64, path func(string) bool, c ResponseCache) {
+	RegisterHttpInterceptor(port, "response cache", func(ctx *HttpWhenContext) bool {
+		if ctx.GetRequestHeader(":method") != "GET" || !path(ctx.GetRequestHeader(":path")) {
+			return false
+		}
+		if c.Bypass != nil && c.Bypass(ctx) {
+			return false
+		}
+		*State[string](ctx) = c.key(ctx, port)
+		return true
+	}, DoResponseCache(c), WithStages(StageRequestHeaders), WithoutFuse())
+}
+
+// Serves the response cached under the key left by When in its Data, or caches the upstream one.
+func DoResponseCache(c ResponseCache) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		s := State[responseCacheState](ctx)
+		if s.key == "" {
+			key := WhenState[string](ctx)
+			if key == nil || *key == "" {
+				return VerdictModified
+			}
+			s.key = *key
+		}
+
+		switch ctx.Stage {
+		case StageRequestHeaders, StageRequestBody, StageRequestTrailers:
+			if ctx.Stage == StageRequestHeaders && c.serve(ctx, s.key) {
+				return VerdictBlocked
+			}
+			return VerdictContinue
+		case StageResponseHeaders:
+			if ctx.GetResponseHeader(":status") != "200" || ctx.GetResponseHeader("set-cookie") != "" {
+				return VerdictModified
+			}
+			headers, err := proxywasm.GetHttpResponseHeaders()
+			if err != nil {
+				ctx.LogWarn("failed to get response headers: " + err.Error())
+				return VerdictModified
+			}
+			s.response = &cachedResponse{Status: 200}
+			for _, h := range headers {
+				if !strings.HasPrefix(h[0], ":") && !uncachedHeaders[h[0]] {
+					s.response.Headers = append(s.response.Headers, h)
+				}
+			}
+			if ctx.End {
+				c.store(ctx, s)
+				return VerdictModified
+			}
+			return VerdictContinue
+		case StageResponseBody:
+			chunk, err := s.body.Next(ctx.BodyOffset, ctx.BodySize, ctx.GetResponseBody)
+			if err != nil {
+				ctx.LogWarn("failed to read response body: " + err.Error())
+				return VerdictModified
+			}
+			s.response.Body = append(s.response.Body, chunk...)
+			if len(s.response.Body) > c.maxSize() {
+				return VerdictModified
+			}
+			if ctx.End {
+				c.store(ctx, s)
+				return VerdictModified
+			}
+			return VerdictContinue
+		}
+		return VerdictModified
+	}
+}
+
+func (c ResponseCache) serve(ctx *HttpDoContext, key string) bool {
+	b, err := responseCacheKV.Get(key)
+	if err != nil || len(b) == 0 {
+		return false
+	}
+	var r cachedResponse
+	if json.Unmarshal(b, &r) != nil || !Now().Before(r.Expires) {
+		return false
+	}
+	headers := append(r.Headers, [2]string{"x-ctf-cache", "hit"})
+	if !reply(r.Status, headers, r.Body) {
+		return false
+	}
+	incrCounter("interceptor_cache_hits", "port", strconv.FormatInt(ctx.Port, 10))
+	return true
+}
+
+func (c ResponseCache) store(ctx *HttpDoContext, s *responseCacheState) {
+	s.response.Expires = Now().Add(c.ttl())
+	b, _ := json.Marshal(s.response)
+	if err := responseCacheKV.Set(s.key, b); err != nil {
+		ctx.LogWarn("failed to cache response: " + err.Error())
+		return
+	}
+	port := strconv.FormatInt(ctx.Port, 10)
+	var evicted []string
+	_, err := responseCacheKV.Update("index/"+port, func(old []byte) []byte {
+		evicted = nil
+		keys := decodeMembers(old)
+		if containsString(keys, s.key) {
+			return old
+		}
+		keys = append(keys, s.key)
+		if over := len(keys) - c.maxEntries(); over > 0 {
+			evicted = append(evicted, keys[:over]...)
+			keys = keys[over:]
+		}
+		b, _ := json.Marshal(keys)
+		return b
+	})
+	if err != nil {
+		ctx.LogWarn("failed to index cached response: " + err.Error())
+	}
+	for _, key := range evicted {
+		// shared data can't be deleted, but an empty value frees the memory
+		responseCacheKV.Set(key, nil)
+	}
+}
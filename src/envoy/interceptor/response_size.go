@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// What to do with a response body over the size cap.
+type SizeAction int
+
+const (
+	// Forward the first bytes up to the cap followed by responseTruncated, drop the rest
+	SizeTruncate SizeAction = iota
+	// Withhold the response and reply with 502
+	SizeBlock
+)
+
+var responseTruncated = []byte("\n[truncated]\n")
+
+// Caps response bodies at max bytes, limiting what a single "dump everything" request can pull.
+// Truncating streams the response through and cuts it at the cap; upstream is asked for an
+// unencoded response, and encoded ones, which cannot be cut, are blocked instead. Blocking holds
+// the response back until it is complete or over the cap, so at most max bytes are buffered.
+func DoLimitResponseSize(max int, action SizeAction) func(*HttpDoContext) Verdict {
+	type state struct {
+		block bool
+		cut   bool
+	}
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, true)
+		s := State[state](ctx)
+		switch ctx.Stage {
+		case StageRequestHeaders:
+			if action == SizeTruncate && ctx.GetRequestHeader("accept-encoding") != "" {
+				ctx.SetRequestHeader("accept-encoding", "identity")
+			}
+			return VerdictContinue
+		case StageRequestBody, StageRequestTrailers:
+			return VerdictContinue
+		case StageResponseHeaders:
+			if ctx.End {
+				return VerdictContinue
+			}
+			length, err := strconv.Atoi(ctx.GetResponseHeader("content-length"))
+			if err == nil && length <= max {
+				return VerdictContinue
+			}
+			s.block = action == SizeBlock || normalizeEncoding(ctx.GetResponseHeader("content-encoding")) != ""
+			if err == nil && s.block {
+				return blockLargeResponse(ctx, length, max)
+			}
+			if s.block {
+				return VerdictPause
+			}
+			// the length changes once cut
+			ctx.DelResponseHeader("content-length")
+			return VerdictContinue
+		case StageResponseTrailers:
+			if s.block {
+				return VerdictContinue
+			}
+			return VerdictModified
+		}
+
+		if s.block {
+			// paused, so the whole body so far is buffered
+			if ctx.BodySize > max {
+				return blockLargeResponse(ctx, ctx.BodySize, max)
+			}
+			if ctx.End {
+				return VerdictContinue
+			}
+			return VerdictPause
+		}
+		if s.cut {
+			ctx.ReplaceResponseBody(nil)
+		} else if size := ctx.BodyOffset + ctx.BodySize; size > max {
+			body, err := ctx.GetResponseBody(0, max-ctx.BodyOffset)
+			if err != nil {
+				ctx.LogWarn("failed to read response body: " + err.Error())
+			}
+			if err := ctx.ReplaceResponseBody(append(body, responseTruncated...)); err != nil {
+				ctx.LogWarn("failed to replace response body: " + err.Error())
+			}
+			s.cut = true
+			incrCounter("interceptor_large_responses", "action", "truncate")
+			ctx.LogWarn(fmt.Sprintf("response over %d bytes truncated path=%s", max, ctx.GetRequestHeader(":path")))
+		}
+		if !ctx.End {
+			return VerdictContinue
+		}
+		if s.cut {
+			return VerdictModified
+		}
+		return VerdictContinue
+	}
+}
+
+func blockLargeResponse(ctx *HttpDoContext, size, max int) Verdict {
+	incrCounter("interceptor_large_responses", "action", "block")
+	ctx.LogWarn(fmt.Sprintf("response of %d bytes over %d withheld path=%s", size, max, ctx.GetRequestHeader(":path")))
+	reply(502, [][2]string{{"content-type", "text/plain"}}, []byte("response withheld\n"))
+	return VerdictBlocked
+}
+
+// Caps responses to matching paths on a port, see DoLimitResponseSize.
+func RegisterResponseSizeLimit(port int64, path func(string) bool, max int, action SizeAction) {
+	RegisterHttpInterceptor(port, "response size limit", func(ctx *HttpWhenContext) bool {
+		return path(ctx.GetRequestHeader(":path"))
+	}, DoLimitResponseSize(max, action), WithStages(StageRequestHeaders))
+}
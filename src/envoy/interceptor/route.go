@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RouteInfo describes the route Envoy selected for the stream.
+type RouteInfo struct {
+	// Route name from the route configuration ("" if unnamed)
+	Name string
+	// Upstream cluster the request is sent to
+	Cluster string
+	// Route filter metadata flattened to "<filter>.<key>[.<key>...]"; only string, number and bool values are kept
+	Metadata map[string]string
+}
+
+// Route metadata value by filter name and key ("" if absent).
+func (r *RouteInfo) Meta(filter, key string) string {
+	return r.Metadata[filter+"."+key]
+}
+
+var propRouteMetadata = []string{"xds", "route_metadata"}
+
+// Route metadata key listing the rule sets of a route, comma-separated:
+//
+//	metadata: { filter_metadata: { interceptor: { rule_sets: "auth,uploads" } } }
+const routeRuleSetsKey = "rule_sets"
+
+// Restricts the interceptor to streams whose route matches.
+func WithRoute(match func(*RouteInfo) bool) HttpInterceptorOption {
+	return func(i *HttpInterceptor) {
+		i.Route = match
+	}
+}
+
+// Restricts the interceptor to routes listing the rule set in their interceptor.rule_sets
+// metadata. Routes without rule sets run every interceptor.
+func WithRuleSet(name string) HttpInterceptorOption {
+	return WithRoute(func(r *RouteInfo) bool {
+		sets, ok := r.Metadata["interceptor."+routeRuleSetsKey]
+		if !ok {
+			return true
+		}
+		for _, s := range strings.Split(sets, ",") {
+			if strings.TrimSpace(s) == name {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Route of the stream, resolved once; fields are empty if Envoy has no route (e.g. direct response).
+func (h *httpCtx) getRoute() *RouteInfo {
+	if h.route != nil {
+		return h.route
+	}
+	r := &RouteInfo{}
+	r.Name, _ = h.properties.getString(PropRouteName)
+	r.Cluster, _ = h.properties.getString(PropUpstreamCluster)
+	if v, err := h.properties.get(propRouteMetadata); err == nil {
+		r.Metadata, _ = parseRouteMetadata(v)
+	}
+	h.route = r
+	return r
+}
+
+// Drops the resolved route after a request header change, Envoy picks the route again.
+func (h *httpCtx) clearRoute() {
+	h.route = nil
+	h.properties.forget(PropRouteName, PropUpstreamCluster, propRouteMetadata)
+}
+
+var errMalformedMetadata = errors.New("malformed metadata")
+
+// Decodes envoy.config.core.v3.Metadata by hand (filter_metadata only), as protobuf reflection is not usable under TinyGo.
+func parseRouteMetadata(b []byte) (map[string]string, error) {
+	out := map[string]string{}
+	err := eachField(b, func(num protowire.Number, v []byte) error {
+		if num != 1 {
+			return nil
+		}
+		filter, st, err := parseMapEntry(v)
+		if err != nil {
+			return err
+		}
+		return flattenStruct(st, filter, out)
+	})
+	return out, err
+}
+
+// google.protobuf.Struct
+func flattenStruct(b []byte, prefix string, out map[string]string) error {
+	return eachField(b, func(num protowire.Number, v []byte) error {
+		if num != 1 {
+			return nil
+		}
+		key, value, err := parseMapEntry(v)
+		if err != nil {
+			return err
+		}
+		return flattenValue(value, prefix+"."+key, out)
+	})
+}
+
+// google.protobuf.Value; lists are skipped.
+func flattenValue(b []byte, key string, out map[string]string) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errMalformedMetadata
+		}
+		b = b[n:]
+		switch {
+		case num == 2 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return errMalformedMetadata
+			}
+			out[key] = strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+			b = b[n:]
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return errMalformedMetadata
+			}
+			out[key] = string(v)
+			b = b[n:]
+		case num == 4 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return errMalformedMetadata
+			}
+			out[key] = strconv.FormatBool(v != 0)
+			b = b[n:]
+		case num == 5 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return errMalformedMetadata
+			}
+			if err := flattenStruct(v, key, out); err != nil {
+				return err
+			}
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return errMalformedMetadata
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// Calls fn for every length-delimited field; other fields are skipped.
+func eachField(b []byte, fn func(num protowire.Number, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errMalformedMetadata
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return errMalformedMetadata
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return errMalformedMetadata
+		}
+		if err := fn(num, v); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// Map entries are messages with a string key (1) and a message value (2).
+func parseMapEntry(b []byte) (string, []byte, error) {
+	var key string
+	var value []byte
+	err := eachField(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			value = v
+		}
+		return nil
+	})
+	return key, value, err
+}
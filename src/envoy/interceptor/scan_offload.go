@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// BodyScanService offloads body scanning to an external service (an Envoy cluster), ICAP-style: the
+// whole body is POSTed to it and it answers 200 if clean or 403 if not, optionally with a reason in
+// x-scan-reason. Any other answer, a timeout, or a body over MaxSize is a failure, handled per
+// FailOpen.
+type BodyScanService struct {
+	Cluster   string
+	Authority string
+	Path      string
+	// Largest body sent for scanning (1 MiB if 0)
+	MaxSize int
+	// Time to wait for the answer (2s if 0)
+	Timeout time.Duration
+	// Let bodies through on failure instead of blocking them
+	FailOpen bool
+}
+
+type bodyScanState struct {
+	bodySize int
+	asked    bool
+}
+
+func (b BodyScanService) maxSize() int {
+	if b.MaxSize <= 0 {
+		return 1 << 20
+	}
+	return b.MaxSize
+}
+
+// Holds the request until its body was scanned; rejects it with 403 if the scanner flags it.
+func DoScanRequestBody(b BodyScanService) func(*HttpDoContext) Verdict {
+	return b.do(true)
+}
+
+// Holds the response (headers included) until its body was scanned; replaces it with 502 if the
+// scanner flags it.
+func DoScanResponseBody(b BodyScanService) func(*HttpDoContext) Verdict {
+	return b.do(false)
+}
+
+func (b BodyScanService) do(isReq bool) func(*HttpDoContext) Verdict {
+	bodyStage, trailersStage := StageRequestBody, StageRequestTrailers
+	get := func(ctx *HttpDoContext) func(int, int) ([]byte, error) { return ctx.GetRequestBody }
+	if !isReq {
+		bodyStage, trailersStage = StageResponseBody, StageResponseTrailers
+		get = func(ctx *HttpDoContext) func(int, int) ([]byte, error) { return ctx.GetResponseBody }
+	}
+
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		s := State[bodyScanState](ctx)
+		if s.asked {
+			return VerdictPause
+		}
+
+		size := ctx.BodySize
+		switch {
+		case ctx.Stage < bodyStage-1:
+			return VerdictContinue
+		case ctx.Stage == bodyStage-1:
+			// headers: nothing to scan without a body, response headers are held otherwise
+			if ctx.End {
+				return VerdictModified
+			}
+			if isReq {
+				return VerdictContinue
+			}
+			return VerdictPause
+		case ctx.Stage == bodyStage && !ctx.End:
+			s.bodySize = size
+			if size > b.maxSize() {
+				ctx.LogWarn("body too large to scan: " + strconv.Itoa(size))
+				return b.fail(ctx, isReq)
+			}
+			return VerdictPause
+		case ctx.Stage == trailersStage:
+			size = s.bodySize
+		case ctx.Stage != bodyStage:
+			return VerdictModified
+		}
+		if size > b.maxSize() {
+			ctx.LogWarn("body too large to scan: " + strconv.Itoa(size))
+			return b.fail(ctx, isReq)
+		}
+
+		body, err := get(ctx)(0, size)
+		if err != nil {
+			ctx.LogWarn("failed to read body: " + err.Error())
+			return b.fail(ctx, isReq)
+		}
+		headers := [][2]string{
+			{":method", "POST"},
+			{":path", b.Path},
+			{":authority", b.Authority},
+			{"content-type", "application/octet-stream"},
+			{"x-ctf-source", ctx.SourceIP},
+			{"x-ctf-request", ctx.GetRequestHeader(":method") + " " + ctx.GetRequestHeader(":path")},
+		}
+		timeout := b.Timeout
+		if timeout <= 0 {
+			timeout = 2 * time.Second
+		}
+		_, err = proxywasm.DispatchHttpCall(b.Cluster, headers, body, nil, uint32(timeout.Milliseconds()), func(int, int, int) {
+			if proxywasm.SetEffectiveContext(ctx.ContextID) != nil {
+				return
+			}
+			switch status := httpCallStatus(); status {
+			case "200":
+				ctx.Resume(VerdictModified)
+			case "403":
+				reason, _ := httpCallHeader("x-scan-reason")
+				ctx.LogWarn("body scanner flagged body: " + reason)
+				ctx.Resume(b.block(ctx, isReq))
+			default:
+				ctx.LogWarn("body scanner failed: " + errExternalStatus(status).Error())
+				ctx.Resume(b.fail(ctx, isReq))
+			}
+		})
+		if err != nil {
+			ctx.LogWarn("body scanner: " + err.Error())
+			return b.fail(ctx, isReq)
+		}
+		s.asked = true
+		return VerdictPause
+	}
+}
+
+func (b BodyScanService) fail(ctx *HttpDoContext, isReq bool) Verdict {
+	if b.FailOpen {
+		return VerdictModified
+	}
+	return b.block(ctx, isReq)
+}
+
+func (b BodyScanService) block(ctx *HttpDoContext, isReq bool) Verdict {
+	status, body := uint32(403), "forbidden"
+	if !isReq {
+		status, body = 502, "response withheld"
+	}
+	reply(status, nil, []byte(body))
+	return VerdictBlocked
+}
@@ -0,0 +1,36 @@
+package main
+
+// Secrets, "secrets" in the plugin config: name to value. Keeping them in their own section keeps
+// them out of code and makes the rest of the config safe to show.
+type secretsConfig map[string]string
+
+// Well-known secret names.
+const (
+	// Key of the admin bypass HMAC (bypass disabled if unset)
+	SecretAdminHmacKey = "admin_hmac_key"
+	// Secret the admin API requires (API disabled if unset)
+	SecretAdminApi = "admin_api_secret"
+	// Sent to the webhook as a bearer token
+	SecretWebhookToken = "webhook_token"
+	// Bearer token the metrics endpoint requires (open if unset)
+	SecretMetricsToken = "metrics_token"
+)
+
+// Returns the named secret, "" if it is not configured.
+func Secret(name string) string {
+	return config.Secrets[name]
+}
+
+// Moves the secrets still given at the top level of the config into the secrets section.
+func (c *pluginConfig) migrateSecrets() {
+	if c.Secrets == nil {
+		c.Secrets = secretsConfig{}
+	}
+	legacy := map[string]string{SecretAdminHmacKey: c.AdminHmacKey, SecretAdminApi: c.AdminApiSecret}
+	for name, v := range legacy {
+		if _, ok := c.Secrets[name]; !ok && v != "" {
+			c.Secrets[name] = v
+		}
+	}
+	c.AdminHmacKey, c.AdminApiSecret = "", ""
+}
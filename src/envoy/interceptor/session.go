@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Sessions kept per store
+const maxSessions = 65536
+
+// SessionKey derives the session id of a request from its headers ("" if it has none).
+type SessionKey func(getRequestHeader func(string) string) string
+
+// Sessions identified by a cookie.
+func SessionCookie(name string) SessionKey {
+	return func(get func(string) string) string {
+		return cookieValue(get("cookie"), name)
+	}
+}
+
+// Sessions identified by a header, a "Bearer " prefix being stripped (e.g. authorization).
+func SessionHeader(name string) SessionKey {
+	return func(get func(string) string) string {
+		v := get(name)
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+			v = v[7:]
+		}
+		return strings.TrimSpace(v)
+	}
+}
+
+// SessionStore keeps JSON values per session in shared data, so every worker sees the same
+// session. A session lives in one shared data entry expiring TTL after its last Set; at most
+// maxSessions are kept, a new session evicting the one sharing its slot.
+type SessionStore struct {
+	kv  BoundedKV
+	ttl time.Duration
+	key SessionKey
+}
+
+func NewSessionStore(namespace string, ttl time.Duration, key SessionKey) *SessionStore {
+	return &SessionStore{kv: NewBoundedKV("session/"+namespace, maxSessions), ttl: ttl, key: key}
+}
+
+// Session id of the request, "" if it has none.
+func (s *SessionStore) SessionID(getRequestHeader func(string) string) string {
+	return s.key(getRequestHeader)
+}
+
+// Stored as expiry (unix ns) followed by the JSON object of values.
+func decodeSession(b []byte) map[string]json.RawMessage {
+	values := map[string]json.RawMessage{}
+	if len(b) < 8 || int64(binary.LittleEndian.Uint64(b)) <= Now().UnixNano() {
+		return values
+	}
+	json.Unmarshal(b[8:], &values)
+	return values
+}
+
+func (s *SessionStore) encodeSession(values map[string]json.RawMessage) []byte {
+	if len(values) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(values)
+	b := encodeInt64(Now().Add(s.ttl).UnixNano())
+	return append(b, data...)
+}
+
+// Reads name of session id into v; reports whether it was set.
+func (s *SessionStore) Get(id, name string, v interface{}) (bool, error) {
+	if id == "" {
+		return false, nil
+	}
+	b, err := s.kv.Get(id)
+	if err != nil {
+		return false, err
+	}
+	raw, ok := decodeSession(b)[name]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Sets name of session id to v, extending the session's lifetime.
+func (s *SessionStore) Set(id, name string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.update(id, func(values map[string]json.RawMessage) {
+		values[name] = raw
+	})
+}
+
+func (s *SessionStore) Delete(id, name string) error {
+	return s.update(id, func(values map[string]json.RawMessage) {
+		delete(values, name)
+	})
+}
+
+// Removes every value of session id.
+func (s *SessionStore) Clear(id string) error {
+	return s.update(id, func(values map[string]json.RawMessage) {
+		for name := range values {
+			delete(values, name)
+		}
+	})
+}
+
+func (s *SessionStore) update(id string, change func(map[string]json.RawMessage)) error {
+	if id == "" {
+		return nil
+	}
+	_, err := s.kv.Update(id, func(old []byte) []byte {
+		values := decodeSession(old)
+		change(values)
+		return s.encodeSession(values)
+	})
+	return err
+}
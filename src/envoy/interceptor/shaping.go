@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// BurstShaper smooths bursts per key: Burst requests pass right away, the following ones are held
+// so that the key gets Rate requests per second (a leaky bucket queueing instead of rejecting).
+type BurstShaper struct {
+	// Unique name, used for shared data keys
+	Name string
+	Key  KeyExtractor
+	// Sustained requests per second
+	Rate float64
+	// Requests passed without delay
+	Burst int
+	// Longest hold (5s if 0); requests which would wait longer wait that long and don't queue up
+	MaxDelay time.Duration
+	// Restricts shaping to some requests (all if nil)
+	Match func(*HttpWhenContext) bool
+}
+
+type burstShaperState struct {
+	bodySize int
+	done     bool
+	delay    time.Duration
+}
+
+// Keys beyond its size share slots, which restarts the bucket of the evicted key.
+var burstShaperKV = NewBoundedKV("shaper", 65536)
+
+func (b BurstShaper) maxDelay() time.Duration {
+	if b.MaxDelay <= 0 {
+		return 5 * time.Second
+	}
+	return b.MaxDelay
+}
+
+// Registers burst shaping on a port; held requests are delayed, never rejected. Register it after
+// the port's other interceptors: with MatchFirst, they don't see the requests it holds.
+func RegisterBurstShaping(port int64, b BurstShaper) {
+	EnableDelays()
+	RegisterHttpInterceptor(port, "burst shaping "+b.Name, func(ctx *HttpWhenContext) bool {
+		state := State[burstShaperState](ctx)
+		if state.done {
+			return false
+		}
+		if ctx.Stage == StageRequestHeaders && b.Match != nil && !b.Match(ctx) {
+			state.done = true
+			return false
+		}
+
+		key, ok := b.Key.extract(ctx, &state.bodySize)
+		if !ok {
+			return false
+		}
+		state.done = true
+		if key == "" || b.Rate <= 0 {
+			return false
+		}
+		state.delay = b.delay(key, ctx)
+		return state.delay > 0
+	}, DoBurstDelay, WithStages(StageRequestHeaders, StageRequestBody, StageRequestTrailers))
+}
+
+// Schedules a request for key and returns how long it has to wait. The key's theoretical arrival
+// time (when its bucket is empty again) lives in shared data.
+func (b BurstShaper) delay(key string, ctx *HttpWhenContext) time.Duration {
+	interval := time.Duration(float64(time.Second) / b.Rate)
+	now := Now().UnixNano()
+	var delay time.Duration
+	_, err := burstShaperKV.Update(b.Name+"/"+key, func(old []byte) []byte {
+		tat := decodeInt64(old)
+		if tat < now {
+			tat = now
+		}
+		tat += int64(interval)
+		delay = time.Duration(tat-now) - time.Duration(b.Burst)*interval
+		if delay > b.maxDelay() {
+			// not queued, or the backlog would grow without bound
+			delay = b.maxDelay()
+			return old
+		}
+		return encodeInt64(tat)
+	})
+	if err != nil {
+		ctx.LogInfo("failed to schedule request: " + err.Error())
+		return 0
+	}
+	if delay > 0 {
+		ctx.LogInfo(fmt.Sprintf("holding request key=%s for %s", key, delay))
+	}
+	return delay
+}
+
+// Holds the request for the delay its When left in Data.
+func DoBurstDelay(ctx *HttpDoContext) Verdict {
+	state := WhenState[burstShaperState](ctx)
+	held := State[bool](ctx)
+	if state == nil || *held {
+		return VerdictModified
+	}
+	*held = true
+	ctx.ResumeAfter(state.delay)
+	return VerdictPause
+}
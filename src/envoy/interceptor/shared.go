@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// Attempts of a CAS update before giving up under contention.
+const sharedCasRetries = 16
+
+// Reads a shared data value; a missing key is not an error.
+func sharedGet(key string) ([]byte, uint32, error) {
+	v, cas, err := proxywasm.GetSharedData(key)
+	if errors.Is(err, types.ErrorStatusNotFound) {
+		return nil, cas, nil
+	}
+	return v, cas, err
+}
+
+// Atomically replaces a shared data value with update(old), retrying on CAS mismatch.
+func sharedUpdate(key string, update func(old []byte) []byte) ([]byte, error) {
+	for i := 0; i < sharedCasRetries; i++ {
+		old, cas, err := sharedGet(key)
+		if err != nil {
+			return nil, err
+		}
+		v := update(old)
+		err = proxywasm.SetSharedData(key, v, cas)
+		if errors.Is(err, types.ErrorStatusCasMismatch) {
+			continue
+		}
+		return v, err
+	}
+	return nil, fmt.Errorf("shared data %s: too much contention", key)
+}
+
+// Atomically adds delta to an int64 shared data value and returns the new value.
+func sharedIncr(key string, delta int64) (int64, error) {
+	v, err := sharedUpdate(key, func(old []byte) []byte {
+		return encodeInt64(decodeInt64(old) + delta)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return decodeInt64(v), nil
+}
+
+func encodeInt64(v int64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func decodeInt64(b []byte) int64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+// Shared data can't be enumerated, so listed entries keep an index: a JSON array of members.
+func sharedSetAdd(key, member string) error {
+	_, err := sharedUpdate(key, func(old []byte) []byte {
+		members := decodeMembers(old)
+		for _, m := range members {
+			if m == member {
+				return old
+			}
+		}
+		b, _ := json.Marshal(append(members, member))
+		return b
+	})
+	return err
+}
+
+func sharedSetRemove(key, member string) error {
+	_, err := sharedUpdate(key, func(old []byte) []byte {
+		members := decodeMembers(old)
+		kept := members[:0]
+		for _, m := range members {
+			if m != member {
+				kept = append(kept, m)
+			}
+		}
+		b, _ := json.Marshal(kept)
+		return b
+	})
+	return err
+}
+
+func sharedSetMembers(key string) ([]string, error) {
+	v, _, err := sharedGet(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMembers(v), nil
+}
+
+func decodeMembers(b []byte) []string {
+	var members []string
+	json.Unmarshal(b, &members)
+	return members
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// SlowRequestGuard rejects requests trickling in, which would otherwise tie up a worker of
+// thread-per-connection services. Envoy hands over headers only once complete, so slow headers
+// are detected when they finally arrive; slow bodies are also checked on every tick.
+type SlowRequestGuard struct {
+	// Max time from the first request byte until the headers are complete (0 disables)
+	HeaderTimeout time.Duration
+	// Max time from the first request byte until the body is complete (0 disables)
+	BodyTimeout time.Duration
+	// Min average body rate in bytes per second, enforced once MinRateGrace elapsed (0 disables)
+	MinBodyRate  int
+	MinRateGrace time.Duration
+}
+
+var slowRequestGuard SlowRequestGuard
+
+// Sets the slow request guard of HTTP streams (disabled by default). Must be called at registration time.
+func SetSlowRequestGuard(guard SlowRequestGuard) {
+	slowRequestGuard = guard
+}
+
+func (g SlowRequestGuard) bodyChecks() bool {
+	return g.BodyTimeout > 0 || g.MinBodyRate > 0
+}
+
+// Streams whose request body is still arriving, context id -> stream
+var pendingRequests = map[uint32]*httpCtx{}
+
+func slowRequestTickPeriod() time.Duration {
+	if !slowRequestGuard.bodyChecks() {
+		return 0
+	}
+	return time.Second
+}
+
+func init() {
+	registerStageGuard(guardSlowloris, (*httpCtx).guardSlowRequest)
+}
+
+// Called for every request callback before dispatching; returns true once the stream got rejected.
+func (h *httpCtx) guardSlowRequest(stage HttpStage, _ int, end bool) bool {
+	g := slowRequestGuard
+	// Applies to streams no interceptor cares about too, unless already rejected
+	if stage >= StageResponseHeaders || (h.finished && h.verdict >= VerdictBlocked) {
+		delete(pendingRequests, h.contextID)
+		return false
+	}
+	if stage == StageRequestHeaders {
+		if start, err := h.properties.getTimestamp(PropRequestTime); err == nil {
+			h.requestStart = start
+		} else {
+			h.requestStart = h.startedAt
+		}
+		if took := Since(h.requestStart); g.HeaderTimeout > 0 && took > g.HeaderTimeout {
+			h.rejectSlowRequest(fmt.Sprintf("headers took %s", took.Round(time.Millisecond)))
+			return true
+		}
+	}
+	if end {
+		delete(pendingRequests, h.contextID)
+		return false
+	}
+	if !g.bodyChecks() {
+		return false
+	}
+	if reason := h.slowBody(); reason != "" {
+		h.rejectSlowRequest(reason)
+		return true
+	}
+	pendingRequests[h.contextID] = h
+	return false
+}
+
+// Returns why the request body is too slow, "" if it isn't.
+func (h *httpCtx) slowBody() string {
+	g := slowRequestGuard
+	elapsed := Since(h.requestStart)
+	if g.BodyTimeout > 0 && elapsed > g.BodyTimeout {
+		return fmt.Sprintf("body incomplete after %s", elapsed.Round(time.Millisecond))
+	}
+	if g.MinBodyRate > 0 && elapsed > g.MinRateGrace {
+		if rate := float64(h.requestBodyBytes) / elapsed.Seconds(); rate < float64(g.MinBodyRate) {
+			return fmt.Sprintf("body at %.0f B/s", rate)
+		}
+	}
+	return ""
+}
+
+// Called from the plugin tick: rejects requests whose body stalled.
+func checkPendingRequests() {
+	for id, h := range pendingRequests {
+		reason := h.slowBody()
+		if reason == "" {
+			continue
+		}
+		delete(pendingRequests, id)
+		if err := proxywasm.SetEffectiveContext(id); err != nil {
+			continue
+		}
+		h.rejectSlowRequest(reason)
+	}
+}
+
+func (h *httpCtx) rejectSlowRequest(reason string) {
+	delete(pendingRequests, h.contextID)
+	proxywasm.LogWarn(fmt.Sprintf("slow request from %s: %s, rejecting", h.sourceIP(), reason))
+	reply(408, [][2]string{{"connection", "close"}}, []byte("request timeout"))
+	h.finish(VerdictBlocked)
+}
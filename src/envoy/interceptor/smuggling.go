@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Returns the first request smuggling indicator found in the request headers, "" if none:
+// duplicated or malformed Content-Length, or a body announced on GET/HEAD. Envoy's codec already
+// rejects conflicting Transfer-Encoding and folds Host into :authority, so those never reach here.
+func SmugglingIndicator(headers [][2]string) string {
+	var lengths []string
+	var method string
+	for _, h := range headers {
+		switch strings.ToLower(h[0]) {
+		case "content-length":
+			lengths = append(lengths, h[1])
+		case ":method":
+			method = h[1]
+		}
+	}
+
+	if len(lengths) > 1 {
+		return "multiple content-length headers"
+	}
+	if len(lengths) == 1 {
+		v := lengths[0]
+		if v == "" || strings.Trim(v, "0123456789") != "" {
+			return "malformed content-length"
+		}
+		if (method == "GET" || method == "HEAD") && strings.Trim(v, "0") != "" {
+			return "body on " + method
+		}
+	}
+	return ""
+}
+
+// Matches requests showing a smuggling indicator.
+func WhenSmuggling(ctx *HttpWhenContext) bool {
+	if ctx.Stage != StageRequestHeaders {
+		return false
+	}
+	headers, err := proxywasm.GetHttpRequestHeaders()
+	if err != nil {
+		return false
+	}
+	if reason := SmugglingIndicator(headers); reason != "" {
+		ctx.LogInfo("request smuggling indicator: " + reason)
+		return true
+	}
+	return false
+}
+
+// Rejects the stream with 400.
+func DoBadRequest(ctx *HttpDoContext) Verdict {
+	reply(400, nil, []byte("bad request"))
+	return VerdictBlocked
+}
+
+// Rejects requests showing a smuggling indicator on a port with 400.
+func RegisterSmugglingDetection(port int64) {
+	RegisterHttpInterceptor(port, "request smuggling", WhenSmuggling, DoBadRequest, WithStages(StageRequestHeaders), WithExploit())
+}
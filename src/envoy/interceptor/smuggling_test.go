@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestSmugglingIndicator(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers [][2]string
+		want    string
+	}{
+		{"no body", [][2]string{{":method", "GET"}, {":path", "/"}}, ""},
+		{"post with length", [][2]string{{":method", "POST"}, {"content-length", "12"}}, ""},
+		{"get with zero length", [][2]string{{":method", "GET"}, {"content-length", "00"}}, ""},
+		{"duplicated", [][2]string{{":method", "POST"}, {"content-length", "5"}, {"Content-Length", "5"}}, "multiple content-length headers"},
+		{"empty", [][2]string{{":method", "POST"}, {"content-length", ""}}, "malformed content-length"},
+		{"list", [][2]string{{":method", "POST"}, {"content-length", "5, 6"}}, "malformed content-length"},
+		{"signed", [][2]string{{":method", "POST"}, {"content-length", "+5"}}, "malformed content-length"},
+		{"body on get", [][2]string{{":method", "GET"}, {"content-length", "5"}}, "body on GET"},
+		{"body on head", [][2]string{{"content-length", "1"}, {":method", "HEAD"}}, "body on HEAD"},
+	}
+	for _, tt := range tests {
+		if got := SmugglingIndicator(tt.headers); got != tt.want {
+			t.Errorf("%s: SmugglingIndicator = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+)
+
+// An incomplete event larger than this is forwarded as is instead of being held back.
+const maxSsePending = 1 << 20
+
+// A Server-Sent Event. Do functions may change its fields; unchanged events are forwarded byte for byte.
+type SseEvent struct {
+	// Event type ("" for the default message type)
+	Event string
+	ID    string
+	Retry string
+	// Data lines joined with "\n"
+	Data string
+
+	raw  []byte
+	orig sseFields
+}
+
+// Field values as parsed, to tell whether an event was changed.
+type sseFields struct {
+	event, id, retry, data string
+}
+
+func isEventStream(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// Matches responses which are event streams.
+func MatchSse(ctx *HttpWhenContext) bool {
+	return ctx.Stage == StageResponseHeaders && isEventStream(ctx.GetResponseHeader("content-type"))
+}
+
+// Splits complete events (up to and including their terminating blank line) off b.
+func splitSseEvents(b []byte) (events [][]byte, rest []byte) {
+	start, lineStart := 0, 0
+	for i := 0; i < len(b); {
+		c := b[i]
+		if c != '\n' && c != '\r' {
+			i++
+			continue
+		}
+		eol := i
+		i++
+		if c == '\r' {
+			if i == len(b) {
+				// can't tell a lone CR from a CRLF split across chunks yet
+				break
+			}
+			if b[i] == '\n' {
+				i++
+			}
+		}
+		if eol == lineStart {
+			events = append(events, b[start:i])
+			start = i
+		}
+		lineStart = i
+	}
+	return events, b[start:]
+}
+
+func parseSseEvent(raw []byte) *SseEvent {
+	e := &SseEvent{raw: raw}
+	var data []string
+	hasData := false
+	lines := strings.FieldsFunc(string(raw), func(r rune) bool { return r == '\n' || r == '\r' })
+	for _, line := range lines {
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			e.Event = value
+		case "id":
+			e.ID = value
+		case "retry":
+			e.Retry = value
+		case "data":
+			data = append(data, value)
+			hasData = true
+		}
+	}
+	if hasData {
+		e.Data = strings.Join(data, "\n")
+	}
+	e.orig = sseFields{e.Event, e.ID, e.Retry, e.Data}
+	return e
+}
+
+func (e *SseEvent) bytes() []byte {
+	if (sseFields{e.Event, e.ID, e.Retry, e.Data}) == e.orig {
+		return e.raw
+	}
+	var b bytes.Buffer
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Retry != "" {
+		b.WriteString("retry: " + e.Retry + "\n")
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+type sseStream struct {
+	pending []byte
+	dropped bool
+}
+
+// Calls fn for every event of an event stream response as soon as it is complete, without holding
+// back the rest of the stream. VerdictBlocked drops the event, VerdictDropped also every later one;
+// other verdicts forward it, with the changes fn made. Compressed streams are passed through.
+func DoSseEvents(fn func(ctx *HttpDoContext, ev *SseEvent) Verdict) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, true)
+		s := State[sseStream](ctx)
+		switch ctx.Stage {
+		case StageResponseHeaders:
+			if enc := ctx.GetResponseHeader("content-encoding"); enc != "" && enc != "identity" {
+				ctx.LogWarn("cannot inspect event stream with content-encoding " + enc)
+				return VerdictModified
+			}
+			return VerdictContinue
+		case StageResponseBody:
+		default:
+			return VerdictContinue
+		}
+
+		var chunk []byte
+		if ctx.BodySize > 0 {
+			var err error
+			if chunk, err = ctx.GetResponseBody(0, ctx.BodySize); err != nil {
+				ctx.LogWarn("failed to read response body: " + err.Error())
+				return VerdictContinue
+			}
+		}
+		if s.dropped {
+			ctx.ReplaceResponseBody(nil)
+			return VerdictContinue
+		}
+
+		data := append(s.pending, chunk...)
+		events, rest := splitSseEvents(data)
+		if ctx.End && len(rest) > 0 {
+			events, rest = append(events, rest), nil
+		}
+		var out []byte
+		for _, raw := range events {
+			ev := parseSseEvent(raw)
+			v := fn(ctx, ev)
+			if v == VerdictDropped {
+				s.dropped = true
+				break
+			}
+			if v != VerdictBlocked {
+				out = append(out, ev.bytes()...)
+			}
+		}
+		if len(rest) > maxSsePending {
+			out = append(out, rest...)
+			rest = nil
+		}
+		s.pending = append([]byte(nil), rest...)
+		if err := ctx.ReplaceResponseBody(out); err != nil {
+			ctx.LogWarn("failed to replace response body: " + err.Error())
+		}
+		return VerdictContinue
+	}
+}
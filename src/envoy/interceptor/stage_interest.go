@@ -0,0 +1,59 @@
+package main
+
+// Set of HTTP stages, one bit per stage.
+type stageMask uint8
+
+const allStages stageMask = 1<<(StageResponseTrailers+1) - 1
+
+func (m stageMask) has(stage HttpStage) bool {
+	return m&(1<<stage) != 0
+}
+
+// Reports whether any stage after the given one is in the set.
+func (m stageMask) after(stage HttpStage) bool {
+	return m>>(stage+1) != 0
+}
+
+func makeStageMask(stages []HttpStage) stageMask {
+	if len(stages) == 0 {
+		return allStages
+	}
+	var m stageMask
+	for _, s := range stages {
+		m |= 1 << s
+	}
+	return m
+}
+
+// Restricts the stages at which When is called. Once When matched, Do still runs at every stage.
+func WithStages(stages ...HttpStage) HttpInterceptorOption {
+	return func(i *HttpInterceptor) {
+		i.Stages = stages
+	}
+}
+
+// Stages MatchHttpRequest(m) reads: the request headers, plus the body and trailers if it matches
+// the body.
+func (m Matcher) Stages() []HttpStage {
+	if m.Body == nil && m.BodyScan == nil {
+		return []HttpStage{StageRequestHeaders}
+	}
+	return []HttpStage{StageRequestHeaders, StageRequestBody, StageRequestTrailers}
+}
+
+// Registers an interceptor matching with MatchHttpRequest(m), its When called only at the stages of
+// m.Stages(). A WithStages option overrides them.
+func RegisterHttpMatcher(port int64, name string, m Matcher, do func(*HttpDoContext) Verdict, opts ...HttpInterceptorOption) {
+	RegisterHttpInterceptor(port, name, MatchHttpRequest(m), do, append([]HttpInterceptorOption{WithStages(m.Stages()...)}, opts...)...)
+}
+
+// Stages any interceptor of the port needs, (direction, port) -> mask. Kept up to date by RegisterHttpInterceptor.
+var httpStageInterest = map[regKey]stageMask{}
+
+func updateStageInterest(key regKey) {
+	var m stageMask
+	for _, it := range httpReg[key] {
+		m |= it.stages
+	}
+	httpStageInterest[key] = m
+}
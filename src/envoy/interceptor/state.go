@@ -0,0 +1,38 @@
+package main
+
+import "fmt"
+
+// Typed access to the Data of a context, so multi-stage rules don't need type assertions:
+//
+//	s := State[myState](ctx) // *myState, zero on the first call for the stream
+//
+// Data keeps one value per interceptor and stream; mixing types in it is a bug and panics.
+type stateHolder interface {
+	data() *interface{}
+}
+
+func (c *HttpWhenContext) data() *interface{} { return &c.Data }
+func (c *HttpDoContext) data() *interface{}   { return &c.Data }
+func (c *TcpWhenContext) data() *interface{}  { return &c.Data }
+func (c *TcpDoContext) data() *interface{}    { return &c.Data }
+
+// State of type T kept in the context's Data, created as the zero T on first use.
+func State[T any](ctx stateHolder) *T {
+	d := ctx.data()
+	if *d == nil {
+		s := new(T)
+		*d = s
+		return s
+	}
+	s, ok := (*d).(*T)
+	if !ok {
+		panic(fmt.Sprintf("context data is %T, not %T", *d, s))
+	}
+	return s
+}
+
+// State of type T left by the When function of a Do context, nil if it left none.
+func WhenState[T any](ctx *HttpDoContext) *T {
+	s, _ := ctx.WhenData.(*T)
+	return s
+}
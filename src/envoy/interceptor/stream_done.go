@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Sets a callback invoked once the stream is over, even if the client disconnected mid-stream or
+// the stream was answered before any interceptor ran (admin API, bans, known payloads...).
+func WithDone(done func(*HttpDoneContext)) HttpInterceptorOption {
+	return func(i *HttpInterceptor) {
+		i.Done = done
+	}
+}
+
+// Sets a callback invoked once the connection is closed, even if it was rejected before any
+// interceptor ran (bans, chaos...).
+func WithTcpDone(done func(*TcpDoneContext)) TcpInterceptorOption {
+	return func(i *TcpInterceptor) {
+		i.Done = done
+	}
+}
+
+func (h *httpCtx) OnHttpStreamDone() {
+	port, err := h.properties.destinationPort()
+	if err == nil {
+		h.runDone(port)
+	}
+	h.exportFlow(port)
+	h.whenContexts = nil
+	h.doContexts = nil
+	delete(pausedStreams, h.contextID)
+	delete(pendingRequests, h.contextID)
+	delete(delayedStreams, h.contextID)
+}
+
+// Calls Done of every interceptor of the port, whichever path handled the stream.
+func (h *httpCtx) runDone(port int64) {
+	ints := httpReg[regKey{h.properties.direction(), port}]
+	for i := range ints {
+		it := &ints[i]
+		if it.Done == nil {
+			continue
+		}
+		dc := &HttpDoneContext{
+			Port:              port,
+			LastStage:         h.lastStage,
+			RequestBodyBytes:  h.requestBodyBytes,
+			ResponseBodyBytes: h.responseBodyBytes,
+			Verdict:           h.lastVerdict,
+		}
+		if !h.startedAt.IsZero() {
+			dc.Duration = Since(h.startedAt)
+		}
+		// contexts only exist once dispatch reached the interceptor
+		if i < len(h.whenContexts) && h.whenContexts[i] != nil {
+			wc := h.whenContexts[i]
+			dc.WhenData = wc.Data
+			if wc.doContext != nil {
+				dc.Matched = true
+				dc.DoData = wc.doContext.Data
+			}
+		}
+		name := it.Name
+		dc.LogInfo = func(message string) {
+			proxywasm.LogInfo(fmt.Sprintf("[%s (done)] %s", name, message))
+		}
+		safeCall("done", name, func(c *HttpDoneContext) bool {
+			it.Done(c)
+			return true
+		}, dc)
+	}
+}
+
+func (t *tcpCtx) OnStreamDone() {
+	port, err := t.properties.destinationPort()
+	if err != nil {
+		return
+	}
+	ints := tcpReg[regKey{t.properties.direction(), port}]
+	for i := range ints {
+		it := &ints[i]
+		if it.Done == nil {
+			continue
+		}
+		dc := &TcpDoneContext{
+			Port:            port,
+			DownstreamBytes: t.offsets[TcpStageDownstreamData],
+			UpstreamBytes:   t.offsets[TcpStageUpstreamData],
+			Verdict:         VerdictContinue,
+		}
+		if t.finished {
+			dc.Verdict = t.verdict
+		}
+		if !t.startedAt.IsZero() {
+			dc.Duration = Since(t.startedAt)
+		}
+		if i < len(t.whenContexts) {
+			wc := t.whenContexts[i]
+			dc.WhenData = wc.Data
+			if wc.doContext != nil {
+				dc.Matched = true
+				dc.DoData = wc.doContext.Data
+			}
+		}
+		name := it.Name
+		dc.LogInfo = func(message string) {
+			proxywasm.LogInfo(fmt.Sprintf("tcp interceptor %s (done): %s", name, message))
+		}
+		safeCall("done", name, func(c *TcpDoneContext) bool {
+			it.Done(c)
+			return true
+		}, dc)
+	}
+	t.whenContexts = nil
+	t.doContexts = nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Where a TCP interceptor's When matched, logged and sent with the match event so the payload can
+// be found in packet captures of the same connection.
+type TcpMatch struct {
+	ConnectionID int64 `json:"connection_id"`
+	// "downstream" (client to service) or "upstream"
+	Direction string `json:"direction"`
+	// Offset of the match in the direction's byte stream, and its length (0 if unknown)
+	Offset int64 `json:"offset"`
+	Length int   `json:"length"`
+	// Bytes around the match, with stream offsets
+	Hexdump string `json:"hexdump,omitempty"`
+}
+
+const (
+	// Bytes dumped before and after the match
+	hexdumpContext = 32
+	maxHexdump     = 256
+)
+
+// Reads bytes [start, start+size) of the segment.
+func (c *TcpWhenContext) GetData(start, size int) ([]byte, error) {
+	if c.Stage == TcpStageUpstreamData {
+		return proxywasm.GetUpstreamData(start, size)
+	}
+	return proxywasm.GetDownstreamData(start, size)
+}
+
+// Tells where in the segment the When matched: n bytes from pos. Without it the match is reported
+// at the start of the segment.
+func (c *TcpWhenContext) MatchedAt(pos, n int) {
+	c.matchPos, c.matchLen = pos, n
+}
+
+// Matches segments of the stage containing re, reporting where. Matches spanning two segments are
+// missed.
+func WhenTcpPattern(stage TcpStage, re *regexp.Regexp) func(*TcpWhenContext) bool {
+	return func(ctx *TcpWhenContext) bool {
+		if ctx.Stage != stage || ctx.Size == 0 {
+			return false
+		}
+		data, err := ctx.GetData(0, ctx.Size)
+		if err != nil {
+			return false
+		}
+		loc := re.FindIndex(data)
+		if loc == nil {
+			return false
+		}
+		ctx.MatchedAt(loc[0], loc[1]-loc[0])
+		return true
+	}
+}
+
+// Locates the match of wc in its stream, nil at the connect stage.
+func (ctx *tcpCtx) tcpMatch(wc *TcpWhenContext) *TcpMatch {
+	if wc.Stage == TcpStageConnect {
+		return nil
+	}
+	pos, n := wc.matchPos, wc.matchLen
+	if pos < 0 || pos > wc.Size || n < 0 {
+		pos, n = 0, 0
+	}
+	m := &TcpMatch{ConnectionID: wc.ConnectionID, Direction: "downstream", Offset: wc.Offset + int64(pos), Length: n}
+	if wc.Stage == TcpStageUpstreamData {
+		m.Direction = "upstream"
+	}
+	start := max(pos-hexdumpContext, 0)
+	end := min(pos+n+hexdumpContext, wc.Size, start+maxHexdump)
+	if end > start {
+		if data, err := wc.GetData(start, end-start); err == nil {
+			m.Hexdump = hexdump(data, wc.Offset+int64(start))
+		}
+	}
+	return m
+}
+
+// Formats data like hexdump -C, offsets counted from base.
+func hexdump(data []byte, base int64) string {
+	var b strings.Builder
+	for i := 0; i < len(data); i += 16 {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		line := data[i:min(i+16, len(data))]
+		fmt.Fprintf(&b, "%08x  ", base+int64(i))
+		for j := 0; j < 16; j++ {
+			if j < len(line) {
+				fmt.Fprintf(&b, "%02x ", line[j])
+			} else {
+				b.WriteString("   ")
+			}
+			if j == 7 {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(" |")
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('|')
+	}
+	return b.String()
+}
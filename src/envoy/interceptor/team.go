@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Team networks, "teams" in the plugin config: team name to CIDRs (or single IPs), e.g.
+// {"team1": ["10.60.1.0/24"]}. Stats, rate limits and canaries use the team of a source rather than
+// its address when it has one.
+type teamsConfig map[string][]string
+
+var teamNetworks = &NetworkSet{}
+
+// Parses a CIDR, or a single IP as a /32 or /128. Mapped IPv4 networks (::ffff:10.0.0.0/104) are
+// turned into plain IPv4 ones, which is what they match.
+func parseNetwork(c string) (*net.IPNet, error) {
+	if ip := net.ParseIP(c); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(c)
+	if err != nil {
+		return nil, err
+	}
+	if ones, bits := n.Mask.Size(); bits == 128 && ones >= 96 && n.IP.To4() != nil {
+		n = &net.IPNet{IP: n.IP.To4(), Mask: net.CIDRMask(ones-96, 32)}
+	}
+	return n, nil
+}
+
+func (t teamsConfig) load() error {
+	teamNetworks = &NetworkSet{}
+	var errs []error
+	for team, cidrs := range t {
+		for _, c := range cidrs {
+			if err := teamNetworks.Add(c, team); err != nil {
+				errs = append(errs, fmt.Errorf("invalid network %q of team %s: %w", c, team, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Team of an IP, "" if it belongs to none. The most specific network wins.
+func TeamOf(ip string) string {
+	team, _ := teamNetworks.Lookup(ip)
+	return team
+}
+
+func (c *HttpWhenContext) SourceTeam() string { return TeamOf(c.SourceIP) }
+func (c *HttpDoContext) SourceTeam() string   { return TeamOf(c.SourceIP) }
+func (c *TcpWhenContext) SourceTeam() string  { return TeamOf(c.SourceIP) }
+func (c *TcpDoContext) SourceTeam() string    { return TeamOf(c.SourceIP) }
@@ -1,6 +1,8 @@
+import uuid
+
 import pytest
 import requests
-from common import request
+from common import get_base_url, request
 
 DEFAULT_HEADERS = frozenset({"server", "date", "content-type", "content-length"})
 MODIFIED_HEADERS = frozenset({"server", "date", "content-type", "transfer-encoding"})
@@ -47,3 +49,31 @@ def test_replaced():
     assert response.status_code == 200
     assert response.text == "new response body"
     assert_headers(response, MODIFIED_HEADERS)
+
+
+def test_scanned_body_blocked():
+    response = request("POST", "/scanned", data=b"a" * 100_000 + b"EVIL" + b"a" * 100_000, timeout=5)
+    assert response.status_code == 418
+
+
+def test_scanned_body_passthrough():
+    response = request("POST", "/scanned", data=b"a" * 200_000, timeout=5)
+    assert response.status_code == 200
+
+
+def test_panic_fails_open():
+    response = request("GET", "/panic", timeout=5)
+    assert response.status_code == 200
+    assert response.text == "Test backend response\n"
+
+
+def test_connection_state_blocks_third_request():
+    with requests.Session() as session:
+        codes = [session.get(f"{get_base_url()}/conn-limit", timeout=5).status_code for _ in range(3)]
+    assert codes == [200, 200, 418]
+
+
+def test_replayed_nonce_rejected():
+    headers = {"x-nonce": uuid.uuid4().hex}
+    assert request("GET", "/nonce", headers=headers, timeout=5).status_code == 200
+    assert request("GET", "/nonce", headers=headers, timeout=5).status_code == 409
@@ -0,0 +1,143 @@
+package integration
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// Bootstrap describes the Envoy config rendered for a test run.
+type Bootstrap struct {
+	HttpPort  int
+	TcpPort   int
+	AdminPort int
+	// More intercepted HTTP listeners, e.g. for admin_api_port
+	ExtraHttpPorts []int
+	// JSON plugin configuration of the interceptor ("" for none)
+	PluginConfig string
+	// Path to the wasm inside the container
+	WasmPath string
+	// host:port of the HTTP and TCP backends, as reachable from the container
+	HttpBackendHost string
+	HttpBackendPort int
+	TcpBackendHost  string
+	TcpBackendPort  int
+}
+
+var bootstrapTemplate = template.Must(template.New("envoy").Parse(`static_resources:
+  listeners:
+{{- range .HttpPorts}}
+  - name: http_in_{{.}}
+    address:
+      socket_address: { address: 0.0.0.0, port_value: {{.}} }
+    filter_chains:
+    - filters:
+      - name: envoy.filters.network.http_connection_manager
+        typed_config:
+          "@type": type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager
+          stat_prefix: hcm
+          generate_request_id: true
+          http_filters:
+          - name: envoy.filters.http.wasm
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.filters.http.wasm.v3.Wasm
+              config:
+                name: interceptor
+                {{- if $.PluginConfig}}
+                configuration:
+                  "@type": type.googleapis.com/google.protobuf.StringValue
+                  value: {{printf "%q" $.PluginConfig}}
+                {{- end}}
+                vm_config:
+                  vm_id: interceptor_http_vm
+                  runtime: envoy.wasm.runtime.v8
+                  environment_variables:
+                    key_values:
+                      CTF_PROXY_IS_HTTP: "1"
+                  code:
+                    local:
+                      filename: {{$.WasmPath}}
+          - name: envoy.filters.http.router
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.filters.http.router.v3.Router
+              suppress_envoy_headers: true
+          route_config:
+            name: all
+            virtual_hosts:
+            - name: http_backend
+              domains: ["*"]
+              routes:
+              - match: { prefix: "/" }
+                route:
+                  cluster: http_backend
+                  timeout: 30s
+{{- end}}
+
+  - name: tcp_in
+    address:
+      socket_address: { address: 0.0.0.0, port_value: {{.TcpPort}} }
+    filter_chains:
+    - filters:
+      - name: envoy.filters.network.wasm
+        typed_config:
+          "@type": type.googleapis.com/envoy.extensions.filters.network.wasm.v3.Wasm
+          config:
+            name: interceptor
+            {{- if .PluginConfig}}
+            configuration:
+              "@type": type.googleapis.com/google.protobuf.StringValue
+              value: {{printf "%q" .PluginConfig}}
+            {{- end}}
+            vm_config:
+              vm_id: interceptor_tcp_vm
+              runtime: envoy.wasm.runtime.v8
+              environment_variables:
+                key_values:
+                  CTF_PROXY_IS_TCP: "1"
+              code:
+                local:
+                  filename: {{.WasmPath}}
+      - name: envoy.filters.network.tcp_proxy
+        typed_config:
+          "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
+          stat_prefix: tcp
+          cluster: tcp_backend
+
+  clusters:
+  - name: http_backend
+    type: STRICT_DNS
+    load_assignment:
+      cluster_name: http_backend
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: { address: {{.HttpBackendHost}}, port_value: {{.HttpBackendPort}} }
+
+  - name: tcp_backend
+    type: STRICT_DNS
+    load_assignment:
+      cluster_name: tcp_backend
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: { address: {{.TcpBackendHost}}, port_value: {{.TcpBackendPort}} }
+
+admin:
+  address:
+    socket_address: { address: 0.0.0.0, port_value: {{.AdminPort}} }
+`))
+
+// Every intercepted HTTP listener port.
+func (b Bootstrap) HttpPorts() []int {
+	return append([]int{b.HttpPort}, b.ExtraHttpPorts...)
+}
+
+// Renders the Envoy bootstrap YAML.
+func (b Bootstrap) Render() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := bootstrapTemplate.Execute(&buf, b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
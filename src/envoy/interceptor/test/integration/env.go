@@ -0,0 +1,360 @@
+// Package integration runs the interceptor inside a real Envoy container and
+// exposes helpers to drive HTTP/TCP traffic through it from `go test`.
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const envoyImage = "envoyproxy/envoy:v1.38.3"
+
+// Host name under which the container reaches backends started by the test.
+const dockerHost = "host.docker.internal"
+
+// Options configure a test environment. Zero values fall back to defaults.
+type Options struct {
+	// Interceptor module directory (defaults to ../..)
+	InterceptorDir string
+	// File registering interceptors, used as main.go (defaults to ../test_interceptors.go)
+	Interceptors string
+	// Envoy listener ports; the HTTP/TCP ports are the ones interceptors are registered on
+	HttpPort  int
+	TcpPort   int
+	AdminPort int
+	// More intercepted HTTP listeners, e.g. for admin_api_port
+	ExtraHttpPorts []int
+	// JSON plugin configuration of the interceptor ("" for none)
+	PluginConfig string
+	// Backends; a default HTTP responder and TCP echo server are started when nil
+	HttpBackend http.Handler
+	TcpBackend  func(net.Conn)
+}
+
+// Env is a running Envoy with the interceptor loaded.
+type Env struct {
+	opts      Options
+	dir       string
+	container string
+	client    *http.Client
+}
+
+// Builds the wasm, starts the backends and the Envoy container. Everything is torn down on test cleanup.
+func Start(t testing.TB, opts Options) *Env {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not available")
+	}
+	opts = withDefaults(opts)
+
+	e := &Env{
+		opts:   opts,
+		dir:    t.TempDir(),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	wasm := filepath.Join(e.dir, "interceptor.wasm")
+	if err := BuildWasm(opts.InterceptorDir, opts.Interceptors, wasm); err != nil {
+		t.Fatalf("build wasm: %v", err)
+	}
+
+	httpPort := serveHttp(t, opts.HttpBackend)
+	tcpPort := serveTcp(t, opts.TcpBackend)
+
+	cfg, err := Bootstrap{
+		HttpPort:        opts.HttpPort,
+		TcpPort:         opts.TcpPort,
+		AdminPort:       opts.AdminPort,
+		ExtraHttpPorts:  opts.ExtraHttpPorts,
+		PluginConfig:    opts.PluginConfig,
+		WasmPath:        "/etc/envoy/wasm/interceptor.wasm",
+		HttpBackendHost: dockerHost,
+		HttpBackendPort: httpPort,
+		TcpBackendHost:  dockerHost,
+		TcpBackendPort:  tcpPort,
+	}.Render()
+	if err != nil {
+		t.Fatalf("render bootstrap: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(e.dir, "envoy.yaml"), cfg, 0o644); err != nil {
+		t.Fatalf("write bootstrap: %v", err)
+	}
+
+	e.container = fmt.Sprintf("interceptor-it-%d", time.Now().UnixNano())
+	args := []string{"run", "-d", "--rm", "--name", e.container,
+		"--add-host", dockerHost + ":host-gateway",
+		"-v", wasm + ":/etc/envoy/wasm/interceptor.wasm:ro",
+		"-v", filepath.Join(e.dir, "envoy.yaml") + ":/etc/envoy/envoy.yaml:ro",
+	}
+	for _, p := range append([]int{opts.HttpPort, opts.TcpPort, opts.AdminPort}, opts.ExtraHttpPorts...) {
+		args = append(args, "-p", fmt.Sprintf("127.0.0.1:%d:%d", p, p))
+	}
+	args = append(args, envoyImage, "envoy", "-c", "/etc/envoy/envoy.yaml", "-l", "info")
+	if out, err := exec.Command("docker", args...).CombinedOutput(); err != nil {
+		t.Fatalf("docker run: %v: %s", err, out)
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("envoy logs:\n%s", e.Logs())
+		}
+		exec.Command("docker", "rm", "-f", e.container).Run()
+	})
+
+	if err := e.waitReady(30 * time.Second); err != nil {
+		t.Fatalf("envoy not ready: %v\n%s", err, e.Logs())
+	}
+	return e
+}
+
+func withDefaults(o Options) Options {
+	if o.InterceptorDir == "" {
+		o.InterceptorDir = "../.."
+	}
+	if o.Interceptors == "" {
+		o.Interceptors = "../test_interceptors.go"
+	}
+	if o.HttpPort == 0 {
+		o.HttpPort = 15001
+	}
+	if o.TcpPort == 0 {
+		o.TcpPort = 15002
+	}
+	if o.AdminPort == 0 {
+		o.AdminPort = 15000
+	}
+	return o
+}
+
+// Compiles the interceptor module with the given file as main.go into out.
+func BuildWasm(interceptorDir, interceptors, out string) error {
+	src, err := os.MkdirTemp("", "interceptor-src-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(src)
+
+	files, err := filepath.Glob(filepath.Join(interceptorDir, "*.go"))
+	if err != nil {
+		return err
+	}
+	files = append(files, filepath.Join(interceptorDir, "go.mod"), filepath.Join(interceptorDir, "go.sum"))
+	for _, f := range files {
+		if err := copyFile(f, filepath.Join(src, filepath.Base(f))); err != nil {
+			return err
+		}
+	}
+	if err := copyFile(interceptors, filepath.Join(src, "main.go")); err != nil {
+		return err
+	}
+	if err := copyDir(filepath.Join(interceptorDir, "assets"), filepath.Join(src, "assets")); err != nil {
+		return err
+	}
+
+	abs, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", abs, ".")
+	cmd.Dir = src
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0o644)
+}
+
+func copyDir(from, to string) error {
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(to, rel), 0o755)
+		}
+		return copyFile(path, filepath.Join(to, rel))
+	})
+}
+
+func serveHttp(t testing.TB, h http.Handler) int {
+	if h == nil {
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "Test backend response\n")
+		})
+	}
+	l := listen(t)
+	srv := &http.Server{Handler: h}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func serveTcp(t testing.TB, handle func(net.Conn)) int {
+	if handle == nil {
+		handle = func(c net.Conn) { io.Copy(c, c) }
+	}
+	l := listen(t)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// Backends listen on all interfaces so the container can reach them through the docker gateway.
+func listen(t testing.TB) net.Listener {
+	l, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return l
+}
+
+func (e *Env) waitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		resp, err := e.client.Get(e.adminUrl("/ready"))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return errors.New("timed out waiting for /ready")
+}
+
+func (e *Env) adminUrl(path string) string {
+	return fmt.Sprintf("http://127.0.0.1:%d%s", e.opts.AdminPort, path)
+}
+
+// Base URL of the intercepted HTTP listener.
+func (e *Env) HttpUrl(path string) string {
+	return e.HttpPortUrl(e.opts.HttpPort, path)
+}
+
+// Base URL of an intercepted HTTP listener by port.
+func (e *Env) HttpPortUrl(port int, path string) string {
+	return fmt.Sprintf("http://127.0.0.1:%d%s", port, path)
+}
+
+// Sends an HTTP request through Envoy and returns the response with its body read.
+func (e *Env) Http(method, path string, body []byte, headers map[string]string) (*http.Response, []byte, error) {
+	return e.HttpPort(e.opts.HttpPort, method, path, body, headers)
+}
+
+// Sends an HTTP request to an intercepted listener by port, see Http.
+func (e *Env) HttpPort(port int, method, path string, body []byte, headers map[string]string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, e.HttpPortUrl(port, path), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+// Sends payload to the TCP listener and collects everything received until EOF or timeout.
+func (e *Env) TcpRoundtrip(payload []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", e.opts.TcpPort), 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(payload); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	var out []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		out = append(out, buf[:n]...)
+		if err != nil {
+			var ne net.Error
+			if errors.Is(err, io.EOF) || (errors.As(err, &ne) && ne.Timeout()) {
+				return out, nil
+			}
+			return out, err
+		}
+	}
+}
+
+// Returns all Envoy stats as name -> value.
+func (e *Env) Stats() (map[string]string, error) {
+	resp, err := e.client.Get(e.adminUrl("/stats"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	stats := map[string]string{}
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		name, value, ok := strings.Cut(sc.Text(), ": ")
+		if ok {
+			stats[name] = value
+		}
+	}
+	return stats, sc.Err()
+}
+
+// Returns a single integer stat (counter or gauge).
+func (e *Env) Stat(name string) (int64, error) {
+	stats, err := e.Stats()
+	if err != nil {
+		return 0, err
+	}
+	v, ok := stats[name]
+	if !ok {
+		return 0, fmt.Errorf("stat %s not found", name)
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// Envoy container logs.
+func (e *Env) Logs() string {
+	out, _ := exec.Command("docker", "logs", e.container).CombinedOutput()
+	return string(out)
+}
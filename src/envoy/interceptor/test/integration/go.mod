@@ -0,0 +1,3 @@
+module interceptor-integration
+
+go 1.24.5
@@ -0,0 +1,97 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInterceptors(t *testing.T) {
+	e := Start(t, Options{})
+
+	t.Run("bypass", func(t *testing.T) {
+		resp, body, err := e.Http(http.MethodGet, "/bypass", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != "Test backend response\n" {
+			t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		resp, body, err := e.Http(http.MethodGet, "/blocked", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusTeapot || string(body) != "hey you" {
+			t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		_, body, err := e.Http(http.MethodGet, "/modified", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != strings.ToUpper("Test backend response\n") {
+			t.Fatalf("unexpected body %q", body)
+		}
+	})
+
+	t.Run("cors wildcard without credentials", func(t *testing.T) {
+		preflight := map[string]string{"origin": "https://evil.example", "access-control-request-method": "POST"}
+		resp, _, err := e.Http(http.MethodOptions, "/", nil, preflight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNoContent || resp.Header.Get("access-control-allow-origin") != "*" || resp.Header.Get("access-control-allow-credentials") != "" {
+			t.Fatalf("unexpected preflight %d %v", resp.StatusCode, resp.Header)
+		}
+
+		resp, _, err = e.Http(http.MethodGet, "/", nil, map[string]string{"origin": "https://evil.example"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.Get("access-control-allow-origin") != "*" || resp.Header.Get("access-control-allow-credentials") != "" {
+			t.Fatalf("unexpected cors headers %v", resp.Header)
+		}
+
+		resp, _, err = e.Http(http.MethodGet, "/", nil, map[string]string{"origin": "https://trusted.example"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.Get("access-control-allow-origin") != "https://trusted.example" || resp.Header.Get("access-control-allow-credentials") != "true" {
+			t.Fatalf("unexpected cors headers %v", resp.Header)
+		}
+	})
+
+	t.Run("tcp passthrough", func(t *testing.T) {
+		out, err := e.TcpRoundtrip([]byte("hello tcp\n"), 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != "hello tcp\n" {
+			t.Fatalf("unexpected echo %q", out)
+		}
+	})
+
+	t.Run("tcp blocked", func(t *testing.T) {
+		out, err := e.TcpRoundtrip([]byte("BLOCK this\n"), 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != 0 {
+			t.Fatalf("expected closed connection, got %q", out)
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		if _, err := e.Stat("http.hcm.downstream_rq_total"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
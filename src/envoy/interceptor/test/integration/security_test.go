@@ -0,0 +1,169 @@
+//go:build integration
+
+package integration
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Listener served by the admin API, and its secret.
+const (
+	apiPort   = 15003
+	apiSecret = "integration-admin-secret"
+	// Key of the admin bypass signatures
+	hmacKey = "integration-hmac-key"
+)
+
+// Admin bypass header value for a path, as AdminSignature computes it.
+func adminSignature(path string) string {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(hmacKey))
+	mac.Write([]byte(path + "\n" + ts))
+	return ts + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSecurity(t *testing.T) {
+	config, err := json.Marshal(map[string]interface{}{
+		"admin_api_port": apiPort,
+		"secrets": map[string]string{
+			"admin_api_secret": apiSecret,
+			"admin_hmac_key":   hmacKey,
+		},
+		"exploit_dedup": map[string]bool{"enabled": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Start(t, Options{ExtraHttpPorts: []int{apiPort}, PluginConfig: string(config)})
+	api := func(method, path string) (*http.Response, []byte, error) {
+		return e.HttpPort(apiPort, method, path, nil, map[string]string{"x-ctf-admin-secret": apiSecret})
+	}
+
+	t.Run("admin api", func(t *testing.T) {
+		resp, _, err := e.HttpPort(apiPort, http.MethodGet, "/recent", nil, map[string]string{"x-ctf-admin-secret": "wrong"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected 403 without the secret, got %d", resp.StatusCode)
+		}
+
+		if _, _, err := e.Http(http.MethodGet, "/blocked", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		resp, body, err := api(http.MethodGet, "/recent?limit=5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var events []struct {
+			Kind        string `json:"kind"`
+			Interceptor string `json:"interceptor"`
+		}
+		if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &events) != nil {
+			t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+		}
+		for _, ev := range events {
+			if ev.Kind == "block" && ev.Interceptor == "/blocked path" {
+				return
+			}
+		}
+		t.Fatalf("block not among recent events %q", body)
+	})
+
+	t.Run("admin bypass", func(t *testing.T) {
+		resp, body, err := e.Http(http.MethodGet, "/blocked", nil, map[string]string{"x-ctf-admin": adminSignature("/blocked")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != "Test backend response\n" {
+			t.Fatalf("signed request not let through: %d %q", resp.StatusCode, body)
+		}
+
+		resp, _, err = e.Http(http.MethodGet, "/blocked", nil, map[string]string{"x-ctf-admin": adminSignature("/other")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusTeapot {
+			t.Fatalf("signature of another path accepted: %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			resp, _, err := e.Http(http.MethodGet, "/limited", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != want {
+				t.Fatalf("request %d: expected %d, got %d", i+1, want, resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("exploit dedup", func(t *testing.T) {
+		payload := []byte("EXPLOIT " + strconv.FormatInt(time.Now().UnixNano(), 10))
+		for i, want := range []int{http.StatusBadRequest, http.StatusForbidden} {
+			resp, _, err := e.Http(http.MethodPost, "/exploit", payload, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != want {
+				t.Fatalf("exploit %d: expected %d, got %d", i+1, want, resp.StatusCode)
+			}
+		}
+
+		// blocks of interceptors not marked WithExploit are not remembered
+		for i := 0; i < 2; i++ {
+			resp, _, err := e.Http(http.MethodGet, "/blocked", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusTeapot {
+				t.Fatalf("block %d: expected 418, got %d", i+1, resp.StatusCode)
+			}
+		}
+	})
+
+	// bans the test client, keep it last
+	t.Run("ban", func(t *testing.T) {
+		if _, _, err := e.Http(http.MethodGet, "/ban-me", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		resp, body, err := e.Http(http.MethodGet, "/", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden || string(body) != "banned" {
+			t.Fatalf("banned source not rejected: %d %q", resp.StatusCode, body)
+		}
+
+		_, body, err = api(http.MethodGet, "/bans")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bans map[string]string
+		if err := json.Unmarshal(body, &bans); err != nil || len(bans) != 1 {
+			t.Fatalf("unexpected bans %q", body)
+		}
+		for ip := range bans {
+			if resp, _, err := api(http.MethodDelete, "/bans?ip="+ip); err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("failed to lift ban of %s: %v", ip, err)
+			}
+		}
+
+		resp, _, err = e.Http(http.MethodGet, "/", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("source still rejected after the ban was lifted: %d", resp.StatusCode)
+		}
+	})
+}
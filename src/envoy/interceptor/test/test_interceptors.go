@@ -2,6 +2,7 @@ package main
 
 import (
 	"strings"
+	"time"
 
 	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
 )
@@ -28,6 +29,60 @@ func registerHttpInterceptors() {
 		MatchHttpRequest(Matcher{
 			Path: MatchPrefix("/replaced"),
 		}), DoReplaceHttpResponseBody([]byte("new response body")))
+
+	RegisterHttpInterceptor(15001, "scanned body",
+		MatchHttpRequest(Matcher{
+			Path: MatchPrefix("/scanned"),
+			BodyScan: func(window []byte) bool {
+				return strings.Contains(string(window), "EVIL")
+			},
+		}), DoHttpBlock)
+
+	RegisterHttpInterceptor(15001, "exploit",
+		MatchHttpRequest(Matcher{
+			Path: MatchPrefix("/exploit"),
+			Body: func(body []byte) bool {
+				return strings.Contains(string(body), "EXPLOIT")
+			},
+		}), DoBadRequest, WithExploit())
+
+	RegisterHttpInterceptor(15001, "ban",
+		MatchHttpRequest(Matcher{
+			Path: MatchPrefix("/ban-me"),
+		}), BanAfterBlock(time.Hour, DoBadRequest))
+
+	RegisterHttpInterceptor(15001, "3rd request on connection",
+		func(ctx *HttpWhenContext) bool {
+			if ctx.Stage != StageRequestHeaders || !strings.HasPrefix(ctx.GetRequestHeader(":path"), "/conn-limit") {
+				return false
+			}
+			return ctx.Conn.Incr("conn-limit", 1) >= 3
+		}, DoHttpBlock, WithStages(StageRequestHeaders))
+
+	RegisterHttpInterceptor(15001, "panicking do",
+		MatchHttpRequest(Matcher{
+			Path: MatchPrefix("/panic"),
+		}), func(ctx *HttpDoContext) Verdict {
+			panic("boom")
+		})
+
+	RegisterReplayProtection(15001, ReplayProtection{NonceHeader: "x-nonce"})
+
+	RegisterRateLimit(15001, RateLimit{
+		Name:   "limited",
+		Key:    KeySourceIP,
+		Limit:  2,
+		Window: time.Minute,
+		Match: func(ctx *HttpWhenContext) bool {
+			return strings.HasPrefix(ctx.GetRequestHeader(":path"), "/limited")
+		},
+	})
+
+	RegisterCorsPolicy(15001, CorsPolicy{
+		AllowedOrigins:   []string{"https://trusted.example", "*"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowCredentials: true,
+	})
 }
 
 func registerTcpInterceptors() {
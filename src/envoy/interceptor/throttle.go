@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+)
+
+// Paces the response body to about bytesPerSec, holding it back when upstream is faster, so a
+// dump-style exploit drains data slowly. Held bytes stay in Envoy's buffer: once upstream got more
+// than the buffer limit (1 MiB by default) ahead, Envoy resets the stream, so large responses from a
+// fast upstream are cut rather than slowed. Streams waiting for their pace are left alone by the
+// pause budget.
+func DoThrottleResponse(bytesPerSec int) func(*HttpDoContext) Verdict {
+	EnableDelays()
+	type state struct {
+		start time.Time
+		// body bytes released so far, including the buffered chunk
+		sent int
+		// bytes of the buffered chunk already counted in sent, and where it starts
+		held   int
+		heldAt int
+	}
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		if ctx.Stage == StageResponseHeaders {
+			if ctx.End {
+				return VerdictModified
+			}
+			State[state](ctx).start = Now()
+			return VerdictContinue
+		}
+		if ctx.Stage != StageResponseBody {
+			return VerdictContinue
+		}
+		s := State[state](ctx)
+		if s.start.IsZero() || bytesPerSec <= 0 {
+			return VerdictModified
+		}
+
+		// a chunk held by an earlier pause grows while more data arrives
+		if ctx.BodyOffset != s.heldAt {
+			s.held, s.heldAt = 0, ctx.BodyOffset
+		}
+		s.sent += ctx.BodySize - s.held
+		s.held = ctx.BodySize
+
+		// time at which everything buffered so far may have been delivered
+		due := s.start.Add(time.Duration(s.sent) * time.Second / time.Duration(bytesPerSec))
+		if wait := due.Sub(Now()); wait > 0 {
+			ctx.ResumeAfter(wait)
+			return VerdictPause
+		}
+		if ctx.End {
+			return VerdictModified
+		}
+		return VerdictContinue
+	}
+}
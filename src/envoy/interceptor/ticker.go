@@ -0,0 +1,96 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+type ticker struct {
+	interval time.Duration
+	fn       func()
+	last     time.Time
+	// registered from the configuration, replaced on every plugin start
+	builtin bool
+}
+
+var tickers []*ticker
+
+// Runs fn every interval from the plugin tick, on every worker. Must be called at registration
+// time. The plugin ticks at the shortest registered interval, so longer ones may run up to one
+// tick late.
+func RegisterTicker(interval time.Duration, fn func()) {
+	if interval <= 0 {
+		panic("ticker interval must be positive")
+	}
+	tickers = append(tickers, &ticker{interval: interval, fn: fn})
+}
+
+// Housekeeping of the built-in features, depending on the configuration. The plugin starts again
+// on every configuration change, so the tickers of the previous start are dropped first.
+func registerBuiltinTickers() {
+	tickers = slices.DeleteFunc(tickers, func(t *ticker) bool { return t.builtin })
+	if len(httpReg) > 0 && pauseBudget.MaxDuration > 0 {
+		registerBuiltinTicker(pauseBudgetTickPeriod(), checkPausedStreams)
+	}
+	if p := slowRequestTickPeriod(); p > 0 {
+		registerBuiltinTicker(p, checkPendingRequests)
+	}
+	if delaysEnabled {
+		registerBuiltinTicker(delayTickPeriod, checkDelayedStreams)
+	}
+	if p := flagFeedTickPeriod(); p > 0 {
+		registerBuiltinTicker(p, checkFlagFeed)
+	}
+	if p := webhookTickPeriod(); p > 0 {
+		registerBuiltinTicker(p, flushWebhook)
+	}
+	if p := flowTickPeriod(); p > 0 {
+		registerBuiltinTicker(p, flushFlows)
+	}
+	if p := counterTickPeriod(); p > 0 {
+		registerBuiltinTicker(p, syncCounters)
+	}
+	registerPathTopTickers()
+}
+
+func registerBuiltinTicker(interval time.Duration, fn func()) {
+	tickers = append(tickers, &ticker{interval: interval, fn: fn, builtin: true})
+}
+
+// Shortest ticker interval, 0 if there is none.
+func tickPeriod() time.Duration {
+	var period time.Duration
+	for _, t := range tickers {
+		if period == 0 || t.interval < period {
+			period = t.interval
+		}
+	}
+	return period
+}
+
+func startTickers() {
+	period := tickPeriod()
+	if period == 0 {
+		return
+	}
+	if err := proxywasm.SetTickPeriodMilliSeconds(uint32(period.Milliseconds())); err != nil {
+		proxywasm.LogWarn("failed to set tick period: " + err.Error())
+	}
+}
+
+func (ctx *pluginContext) OnTick() {
+	now := Now()
+	for _, t := range tickers {
+		// tolerate ticks arriving slightly early
+		if now.Sub(t.last) < t.interval-t.interval/10 {
+			continue
+		}
+		t.last = now
+		safeCall("ticker", "ticker", func(fn func()) struct{} {
+			fn()
+			return struct{}{}
+		}, t.fn)
+	}
+}
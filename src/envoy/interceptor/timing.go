@@ -0,0 +1,24 @@
+package main
+
+import "time"
+
+// Time from the first request byte to the first response byte once the response started (the
+// upstream's latency), or so far before.
+func (h *httpCtx) elapsed() time.Duration {
+	start := h.requestStart
+	if start.IsZero() {
+		start = h.startedAt
+	}
+	if h.responseStart.IsZero() {
+		return Since(start)
+	}
+	return h.responseStart.Sub(start)
+}
+
+// Matches responses which took longer than d to start, e.g. the answers to time-based SQL
+// injection probes. Use it with WithStages(StageResponseHeaders).
+func WhenSlowResponse(d time.Duration) func(*HttpWhenContext) bool {
+	return func(ctx *HttpWhenContext) bool {
+		return ctx.Stage >= StageResponseHeaders && ctx.Elapsed() > d
+	}
+}
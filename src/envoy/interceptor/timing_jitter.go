@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Holds responses until at least floor plus a random jitter in [0, jitter) elapsed since the
+// request, so response time no longer tells a right guess from a wrong one.
+func DoTimingJitter(floor, jitter time.Duration) func(*HttpDoContext) Verdict {
+	type state struct {
+		start   time.Time
+		delayed bool
+	}
+	return func(ctx *HttpDoContext) Verdict {
+		ctx.ReplacesBodies(false, false)
+		s := State[state](ctx)
+		if s.start.IsZero() {
+			s.start = Now()
+		}
+		if s.delayed {
+			return VerdictModified
+		}
+		if ctx.Stage != StageResponseHeaders {
+			return VerdictContinue
+		}
+
+		target := floor
+		if jitter > 0 {
+			target += time.Duration(rand.Int63n(int64(jitter)))
+		}
+		wait := target - Since(s.start)
+		if wait <= 0 {
+			return VerdictModified
+		}
+		s.delayed = true
+		ctx.ResumeAfter(wait)
+		return VerdictPause
+	}
+}
+
+// Pads the latency of requests to matching paths on a port, see DoTimingJitter.
+func RegisterTimingJitter(port int64, path func(string) bool, floor, jitter time.Duration) {
+	EnableDelays()
+	RegisterHttpInterceptor(port, "timing jitter", func(ctx *HttpWhenContext) bool {
+		return path(ctx.GetRequestHeader(":path"))
+	}, DoTimingJitter(floor, jitter), WithStages(StageRequestHeaders))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// TLS properties of the downstream connection, for telling exploit clients apart by their TLS
+// stack even when they spoof HTTP headers. Envoy only exposes the version as a property; the
+// cipher and JA3 fingerprint are passed in request headers set by the header_mutation filter in
+// front of the interceptor on the TLS filter chain (see envoy.template.yaml).
+type TlsInfo struct {
+	// e.g. "TLSv1.3"
+	Version string
+	// e.g. "TLS_AES_128_GCM_SHA256" ("" if unavailable)
+	Cipher string
+	// SNI requested by the client
+	SNI string
+	// MD5 of the JA3 string, lower case hex ("" if unavailable)
+	JA3 string
+}
+
+const (
+	tlsCipherHeader = "x-ctf-tls-cipher"
+	ja3Header       = "x-ctf-ja3"
+)
+
+// Reads the TLS properties of the stream and removes the headers carrying them, which clients on
+// the plaintext chain could otherwise forge. Returns nil for plaintext streams.
+func (h *httpCtx) readTlsInfo() *TlsInfo {
+	cipher, ja3 := h.takeRequestHeader(tlsCipherHeader), h.takeRequestHeader(ja3Header)
+	version, err := h.properties.getString(PropConnectionTlsVersion)
+	if err != nil || version == "" {
+		return nil
+	}
+	sni, _ := h.properties.getString(PropConnectionSni)
+	if ja3 == "-" {
+		// formatter placeholder for connections without a fingerprint
+		ja3 = ""
+	}
+	if cipher == "-" {
+		cipher = ""
+	}
+	return &TlsInfo{Version: version, Cipher: cipher, SNI: sni, JA3: strings.ToLower(ja3)}
+}
+
+// Returns a request header and removes it from the request.
+func (h *httpCtx) takeRequestHeader(name string) string {
+	v := h.getRequestHeader(name)
+	if v == "" {
+		return ""
+	}
+	if err := proxywasm.RemoveHttpRequestHeader(name); err != nil {
+		proxywasm.LogWarn("failed to remove request header: " + err.Error())
+	}
+	h.requestHeaders.del(name)
+	return v
+}
+
+// Matches streams whose client has one of the JA3 fingerprints (hex MD5).
+func WhenJA3(fingerprints ...string) func(*HttpWhenContext) bool {
+	set := map[string]bool{}
+	for _, f := range fingerprints {
+		set[strings.ToLower(f)] = true
+	}
+	return func(ctx *HttpWhenContext) bool {
+		return ctx.TLS != nil && set[ctx.TLS.JA3]
+	}
+}
+
+// Matches TLS streams with one of the versions (e.g. "TLSv1.2").
+func WhenTlsVersion(versions ...string) func(*HttpWhenContext) bool {
+	return func(ctx *HttpWhenContext) bool {
+		return ctx.TLS != nil && containsString(versions, ctx.TLS.Version)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !tinygo
+
+package main
+
+// Compiler used to build the module, reported at startup.
+const toolchain = "go"
@@ -0,0 +1,15 @@
+//go:build tinygo
+
+package main
+
+// Compiler used to build the module, reported at startup.
+const toolchain = "tinygo"
+
+// Mirrors the LifeSpan enum from proto.go, which is excluded under TinyGo.
+type LifeSpan int32
+
+const (
+	LifeSpan_FilterChain          LifeSpan = 0
+	LifeSpan_DownstreamRequest    LifeSpan = 1
+	LifeSpan_DownstreamConnection LifeSpan = 2
+)
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
 )
 
@@ -10,8 +12,6 @@ type HttpStage int
 // TcpStage represents the current TCP lifecycle stage.
 type TcpStage int
 
-const undefinedAction types.Action = 0xFFFFFFFF
-
 // An HttpInterceptor is a pair of When/Do functions.
 type HttpInterceptor struct {
 	// A unique name within a port, for tracing.
@@ -20,36 +20,104 @@ type HttpInterceptor struct {
 	// When is called at every stage of the HTTP lifecycle; once it returns true for a stream, it is no longer called for that stream.
 	When func(*HttpWhenContext) bool
 
-	// Do will be called once the When matched, at every subsequent stage (including the matching one), until Do returns true.
-	Do func(*HttpDoContext) bool
+	// Do will be called once the When matched, at every subsequent stage (including the matching one), until it returns a final verdict.
+	Do func(*HttpDoContext) Verdict
+
+	// Done is called once the stream finished or was aborted, whether When matched or not (optional).
+	Done func(*HttpDoneContext)
+
+	// Listeners the interceptor attaches to (Inbound by default).
+	Direction Direction
+
+	// Stages at which When is called (nil means all).
+	Stages []HttpStage
+	stages stageMask
+
+	// Routes the interceptor applies to (nil means all).
+	Route func(*RouteInfo) bool
+
+	// Max body bytes the interceptor may keep buffered (0 uses the global limit).
+	MaxBodySize int
+	// What to do once MaxBodySize is exceeded.
+	BodyLimitFallback BodyLimitFallback
+
+	// Cap on actions, see Fuse (the default fuse if unset).
+	Fuse Fuse
+
+	// Whether blocks are exploit blocks remembered by exploit_dedup, see WithExploit.
+	Exploit bool
+
+	// Groups exceptions can refer to the interceptor by, see WithGroup.
+	Groups []string
+	// Interceptors and groups the stream is exempted from once When matches (exceptions only, which have no Do).
+	Exempts []string
 }
 
+// HttpInterceptorOption customizes an interceptor at registration.
+type HttpInterceptorOption func(*HttpInterceptor)
+
+// BodyLimitFallback is the verdict applied when an interceptor pauses with more body buffered than allowed.
+type BodyLimitFallback int
+
 // HttpWhenContext provides read-only access for condition evaluation.
 type HttpWhenContext struct {
+	// proxy-wasm context id of the stream
+	ContextID uint32
+	// x-request-id of the stream ("" if not generated)
+	RequestID string
+	// Downstream connection id (0 if unavailable)
+	ConnectionID int64
+	// Client IP, behind trusted proxies the forwarded one ("" if unavailable)
+	SourceIP string
+	// Direction of the listener
+	Direction Direction
+	// TLS properties of the downstream connection (nil for plaintext)
+	TLS *TlsInfo
 	// Current stage
 	Stage HttpStage
 	// endOfStream (only meaningful on body stages)
 	End bool
 	// buffered size visible to the filter
 	BodySize int
+	// stream offset of the first buffered body byte (bytes before it were already passed on)
+	BodyOffset int
 	// Any data needed to persist between calls by the When function
 	Data interface{}
+	// State shared by all streams of the downstream connection
+	Conn *ConnState
 
 	// Interceptor being executed
 	interceptor *HttpInterceptor
+	// Set once the interceptor matched or gave up on this stream (e.g. body limit exceeded)
+	done bool
+	// Do context created when When matched
+	doContext *HttpDoContext
 
-	// Retrieves request header by name. Returns "" if not present or not in request stage.
+	// Retrieves request header by name. Returns "" if not present.
 	GetRequestHeader func(name string) string
 
 	// Retrieves request body bytes in the range [start, start+size). Returns nil if not in request stage.
 	GetRequestBody func(start, size int) ([]byte, error)
 
+	// Retrieves request trailer by name. Returns "" if not present or not in request trailers stage.
+	GetRequestTrailer func(name string) string
+
 	// Retrieves response header by name. Returns "" if not present or not in response stage.
 	GetResponseHeader func(name string) string
 
 	// Retrieves response body bytes in the range [start, start+size). Returns nil if not in response stage.
 	GetResponseBody func(start, size int) ([]byte, error)
 
+	// Retrieves response trailer by name. Returns "" if not present or not in response trailers stage.
+	GetResponseTrailer func(name string) string
+
+	// Retrieves the route and upstream cluster selected for the stream.
+	GetRoute func() *RouteInfo
+
+	// Time from the first request byte to the first response byte, or the time since the first
+	// request byte while the response hasn't started.
+	Elapsed func() time.Duration
+
 	// Logs info message to proxy logs with interceptor name prefix
 	LogInfo func(message string)
 
@@ -59,18 +127,39 @@ type HttpWhenContext struct {
 
 // HttpDoContext provides full access to modify requests and responses.
 type HttpDoContext struct {
+	// proxy-wasm context id of the stream
+	ContextID uint32
+	// x-request-id of the stream ("" if not generated)
+	RequestID string
+	// Downstream connection id (0 if unavailable)
+	ConnectionID int64
+	// Client IP, behind trusted proxies the forwarded one ("" if unavailable)
+	SourceIP string
+	// Direction of the listener
+	Direction Direction
+	// TLS properties of the downstream connection (nil for plaintext)
+	TLS *TlsInfo
+
 	Stage HttpStage
 	Port  int64
 	// endOfStream (only meaningful on body stages)
 	End bool
 	// buffered size visible to the filter
 	BodySize int
+	// stream offset of the first buffered body byte (bytes before it were already passed on)
+	BodyOffset int
 	// Any data needed to persist between calls by the When function
 	Data interface{}
+	// Data left by the When function
+	WhenData interface{}
+	// State shared by all streams of the downstream connection
+	Conn *ConnState
 
 	interceptor *HttpInterceptor
+	// Header stages of the directions whose body Do won't replace, see ReplacesBodies
+	keepsFraming stageMask
 
-	// Retrieves request header by name. Returns "" if not present or not in request stage.
+	// Retrieves request header by name, at any stage. Returns "" if not present.
 	GetRequestHeader func(name string) string
 
 	// Sets request header. Does nothing if not in request stage.
@@ -85,6 +174,15 @@ type HttpDoContext struct {
 	// Replaces entire request body. Does nothing if not in request stage.
 	ReplaceRequestBody func([]byte) error
 
+	// Retrieves request trailer by name. Returns "" if not present or not in request trailers stage.
+	GetRequestTrailer func(name string) string
+
+	// Sets request trailer. Does nothing if not in request trailers stage.
+	SetRequestTrailer func(name, value string)
+
+	// Deletes request trailer. Does nothing if not in request trailers stage.
+	DelRequestTrailer func(name string)
+
 	// Retrieves response header by name. Returns "" if not present or not in response stage.
 	GetResponseHeader func(name string) string
 
@@ -100,25 +198,112 @@ type HttpDoContext struct {
 	// Replaces entire response body. Does nothing if not in response stage.
 	ReplaceResponseBody func([]byte) error
 
+	// Retrieves response trailer by name. Returns "" if not present or not in response trailers stage.
+	GetResponseTrailer func(name string) string
+
+	// Sets response trailer. Does nothing if not in response trailers stage.
+	SetResponseTrailer func(name, value string)
+
+	// Deletes response trailer. Does nothing if not in response trailers stage.
+	DelResponseTrailer func(name string)
+
+	// Retrieves the route and upstream cluster selected for the stream.
+	GetRoute func() *RouteInfo
+
+	// Same as HttpWhenContext.Elapsed, measured when Do runs.
+	Elapsed func() time.Duration
+
+	// Resumes the stream after d; Do should then return VerdictPause. Requires EnableDelays.
+	ResumeAfter func(d time.Duration)
+
+	// Applies v as if Do returned it, from outside a callback (e.g. an HTTP call response), after
+	// Do paused the stream. A blocking Do must send its reply first.
+	Resume func(v Verdict)
+
 	// Logs info message to proxy logs with interceptor name prefix
 	LogInfo func(message string)
 
 	// Logs warning message to proxy logs with interceptor name prefix
 	LogWarn func(message string)
+}
 
-	// By default ActionContinue; set to ActionPause by Pause().
-	resultAction types.Action
+// HttpDoneContext is passed to Done once the stream is over, for final accounting.
+type HttpDoneContext struct {
+	Port int64
+	// Whether When matched on this stream
+	Matched bool
+	// Data left by the When and Do functions
+	WhenData interface{}
+	DoData   interface{}
+	// Last stage reached; earlier than StageResponseBody if the client disconnected mid-stream
+	LastStage HttpStage
+	// Time since the first stage
+	Duration time.Duration
+	// Body bytes seen by the filter
+	RequestBodyBytes  int
+	ResponseBodyBytes int
+	// Final verdict, or the last Continue/Pause if the stream was still being intercepted
+	Verdict Verdict
+
+	// Logs info message to proxy logs with interceptor name prefix
+	LogInfo func(message string)
 }
 
 // Context for a single HTTP stream.
 type httpCtx struct {
 	types.DefaultHttpContext
-	// Skip any further stream processing using this action (undefinedAction by default)
-	skip types.Action
-	// When contexts for all interceptors defined for this port (if any)
+	contextID uint32
+	// Set once the stream needs no further processing; verdict is then applied to every callback
+	finished bool
+	verdict  Verdict
+	// When contexts for all interceptors defined for this port, built on first use (nil entries until then)
 	whenContexts []*HttpWhenContext
-	// Do context, once When matched
-	doContext *HttpDoContext
+	// Shared part of When contexts
+	whenTemplate *HttpWhenContext
+	// Do contexts of matched interceptors (at most one unless MatchAll)
+	doContexts []*HttpDoContext
+	// Host properties fetched so far for this stream
+	properties propertyCache
+	// Route selected by Envoy, resolved on first use
+	route *RouteInfo
+	// Header values fetched so far for this stream
+	requestHeaders  headerCache
+	responseHeaders headerCache
+	// Body bytes already passed on per direction
+	requestBodyOffset  int
+	responseBodyOffset int
+	// Headers already passed on per direction (can no longer be modified)
+	requestHeadersSent  bool
+	responseHeadersSent bool
+	// Body bytes seen per direction
+	requestBodyBytes  int
+	responseBodyBytes int
+	// Time the first request byte was received (see SlowRequestGuard)
+	requestStart time.Time
+	// Time the response headers arrived
+	responseStart time.Time
+	// Stream accounting for Done callbacks
+	startedAt   time.Time
+	lastStage   HttpStage
+	lastVerdict Verdict
+	// Size of the last stage's data, forwarded once a paused stream is resumed
+	lastSize int
+	// Consecutive paused callbacks and when the stream got paused (see PauseBudget)
+	pauses   int
+	pausedAt time.Time
+	// Request payload hash (see exploitDedupConfig)
+	payload *payloadHash
+	// Fault injected into the stream (see chaosConfig)
+	chaos chaosFault
+	// Flow record being collected (see flowRecordConfig)
+	flow *flowCapture
+	// TLS properties, read at the first callback
+	tls *TlsInfo
+	// Interceptors and groups exempted by matched exceptions
+	exemptions []string
+	// Client IP, resolved on first use (see trustedProxies)
+	clientIP         string
+	clientIPResolved bool
 }
 
 // A TcpInterceptor is a pair of When/Do functions.
@@ -129,15 +314,39 @@ type TcpInterceptor struct {
 	// When is called at every stage of the TCP connection; once it returns true for a connection, it is no longer called for that connection.
 	When func(*TcpWhenContext) bool
 
-	// Do will be called once the When matched, at every subsequent stage (including the matching one), until Do returns true.
-	Do func(*TcpDoContext) bool
+	// Do will be called once the When matched, at every subsequent stage (including the matching one), until it returns a final verdict.
+	Do func(*TcpDoContext) Verdict
+
+	// Done is called once the connection is closed, whether When matched or not (optional).
+	Done func(*TcpDoneContext)
+
+	// Listeners the interceptor attaches to (Inbound by default).
+	Direction Direction
+
+	// Whether When is also called at TcpStageConnect, see WithConnectStage.
+	Connect bool
 }
 
+// TcpInterceptorOption customizes an interceptor at registration.
+type TcpInterceptorOption func(*TcpInterceptor)
+
 type TcpWhenContext struct {
+	// proxy-wasm context id of the connection
+	ContextID uint32
+	// Downstream connection id (0 if unavailable)
+	ConnectionID int64
+	// Downstream IP ("" if unavailable)
+	SourceIP string
+	// Server name requested in the client's TLS hello ("" if none)
+	SNI string
+	// Direction of the listener
+	Direction Direction
 	// Current stage
 	Stage TcpStage
 	// Size of the TCP segment
 	Size int
+	// Offset of the segment's first byte in the stage's byte stream
+	Offset int64
 	// endOfStream (only meaningful on body stages)
 	End bool
 
@@ -146,6 +355,12 @@ type TcpWhenContext struct {
 
 	// Interceptor being executed
 	interceptor *TcpInterceptor
+	// Set once the interceptor matched or gave up on this connection
+	done bool
+	// Match position in the segment reported by MatchedAt (-1 if none)
+	matchPos, matchLen int
+	// Do context created when When matched
+	doContext *TcpDoContext
 
 	// Logs info message to proxy logs with interceptor name prefix
 	LogInfo func(message string)
@@ -155,25 +370,63 @@ type TcpWhenContext struct {
 }
 
 type TcpDoContext struct {
+	// proxy-wasm context id of the connection
+	ContextID uint32
+	// Downstream connection id (0 if unavailable)
+	ConnectionID int64
+	// Downstream IP ("" if unavailable)
+	SourceIP string
+	// Direction of the listener
+	Direction Direction
+
 	Stage TcpStage
+	Port  int64
 	Size  int
+	// Offset of the segment's first byte in the stage's byte stream
+	Offset int64
 	// endOfStream (only meaningful on body stages)
 	End bool
 	// Any data needed to persist between calls by the When function
 	Data interface{}
 
 	interceptor *TcpInterceptor
-	// By default ActionContinue; set to ActionPause by Pause().
-	resultAction types.Action
 }
 
 // Context for a single TCP connection.
 type tcpCtx struct {
 	types.DefaultTcpContext
-	// Skip any further stream processing using this action (undefinedAction by default)
-	skip types.Action
+	contextID uint32
+	// Set once the connection needs no further processing; verdict is then applied to every callback
+	finished bool
+	verdict  Verdict
 	// When contexts for all interceptors defined for this port (if any)
 	whenContexts []*TcpWhenContext
-	// Do context, once When matched
-	doContext *TcpDoContext
+	// Do contexts of matched interceptors (at most one unless MatchAll)
+	doContexts []*TcpDoContext
+	// Host properties fetched so far for this connection
+	properties propertyCache
+	// Bytes passed on per direction, indexed by data stage
+	offsets [2]int64
+	// Time the connection was accepted, for Done callbacks
+	startedAt time.Time
+}
+
+// TcpDoneContext is passed to Done once the connection is closed, for final accounting.
+type TcpDoneContext struct {
+	Port int64
+	// Whether When matched on this connection
+	Matched bool
+	// Data left by the When and Do functions
+	WhenData interface{}
+	DoData   interface{}
+	// Time since the connection was accepted
+	Duration time.Duration
+	// Bytes passed on per direction
+	DownstreamBytes int64
+	UpstreamBytes   int64
+	// Final verdict, or Continue if the connection was still being intercepted
+	Verdict Verdict
+
+	// Logs info message to proxy logs with interceptor name prefix
+	LogInfo func(message string)
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+)
+
+// File types recognized by DetectFileTypes.
+const (
+	FileELF     = "elf"
+	FilePE      = "pe"
+	FileMachO   = "macho"
+	FileScript  = "script"
+	FilePHP     = "php"
+	FileZip     = "zip"
+	FileGzip    = "gzip"
+	FilePNG     = "png"
+	FileJPEG    = "jpeg"
+	FileGIF     = "gif"
+	FilePDF     = "pdf"
+	FileUnknown = ""
+)
+
+var fileMagic = []struct {
+	magic []byte
+	kind  string
+}{
+	{[]byte("\x7fELF"), FileELF},
+	{[]byte("MZ"), FilePE},
+	{[]byte("\xcf\xfa\xed\xfe"), FileMachO},
+	{[]byte("\xfe\xed\xfa\xcf"), FileMachO},
+	{[]byte("#!"), FileScript},
+	{[]byte("PK\x03\x04"), FileZip},
+	{[]byte("\x1f\x8b"), FileGzip},
+	{[]byte("\x89PNG"), FilePNG},
+	{[]byte("\xff\xd8\xff"), FileJPEG},
+	{[]byte("GIF8"), FileGIF},
+	{[]byte("%PDF"), FilePDF},
+}
+
+// Returns the types of a file's content: the one given by its leading magic bytes, plus FilePHP
+// if a PHP open tag appears anywhere (image/PHP polyglots).
+func DetectFileTypes(data []byte) []string {
+	var kinds []string
+	for _, m := range fileMagic {
+		if bytes.HasPrefix(data, m.magic) {
+			kinds = append(kinds, m.kind)
+			break
+		}
+	}
+	lower := bytes.ToLower(data)
+	if bytes.Contains(lower, []byte("<?php")) || bytes.Contains(lower, []byte("<?=")) {
+		kinds = append(kinds, FilePHP)
+	}
+	return kinds
+}
+
+// Splits a multipart body into part contents. The boundary is taken from the first line,
+// so the Content-Type header is not needed. Returns nil if the body is not multipart.
+func multipartContents(body []byte) [][]byte {
+	if !bytes.HasPrefix(body, []byte("--")) {
+		return nil
+	}
+	end := bytes.Index(body, []byte("\r\n"))
+	if end < 3 {
+		return nil
+	}
+	delim := append([]byte("\r\n"), body[:end]...)
+
+	var parts [][]byte
+	rest := body[end:]
+	for {
+		next := bytes.Index(rest[2:], delim)
+		part := rest[2:]
+		if next >= 0 {
+			part = rest[2 : next+2]
+		}
+		if sep := bytes.Index(part, []byte("\r\n\r\n")); sep >= 0 {
+			parts = append(parts, part[sep+4:])
+		}
+		if next < 0 {
+			return parts
+		}
+		rest = rest[next+2+len(delim):]
+		if bytes.HasPrefix(rest, []byte("--")) || len(rest) < 2 {
+			return parts
+		}
+	}
+}
+
+// Body matcher flagging uploads (raw or multipart) containing any of the given file types,
+// whatever their declared filename or content type.
+func MatchUploadTypes(disallowed ...string) func([]byte) bool {
+	return func(body []byte) bool {
+		contents := multipartContents(body)
+		if contents == nil {
+			contents = [][]byte{body}
+		}
+		for _, c := range contents {
+			for _, kind := range DetectFileTypes(c) {
+				for _, d := range disallowed {
+					if kind == d {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+}
+
+// Rejects uploads containing any of the given file types on a port with 400.
+func RegisterUploadFilter(port int64, disallowed ...string) {
+	RegisterHttpInterceptor(port, "upload filter", MatchHttpRequest(Matcher{
+		Method: func(m string) bool { return m == "POST" || m == "PUT" || m == "PATCH" },
+		Body:   MatchUploadTypes(disallowed...),
+	}), DoBadRequest, WithStages(StageRequestHeaders, StageRequestBody, StageRequestTrailers), WithExploit())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Request header selecting the upstream cluster: the HTTP routes in envoy.template.yaml send
+// requests carrying it to the named cluster (cluster_header) and strip it. Envoy recomputes the
+// route when a filter changes request headers.
+const upstreamHeader = "x-ctf-upstream"
+
+// Removes a client-supplied upstream override, only interceptors may choose the cluster. Runs at
+// the first callback of every stream, before anything can reply or pass the headers on, and removes
+// the header even if it looks absent (an empty value still selects the override route).
+func (h *httpCtx) stripUpstreamOverride() {
+	if h.getRequestHeader(upstreamHeader) != "" {
+		proxywasm.LogWarn("removed " + upstreamHeader + " sent by " + h.sourceIP())
+	}
+	if err := proxywasm.RemoveHttpRequestHeader(upstreamHeader); err != nil {
+		proxywasm.LogWarn("failed to remove request header: " + err.Error())
+	}
+	h.requestHeaders.del(upstreamHeader)
+}
+
+// Sends the request to another Envoy cluster, e.g. a sandboxed copy of the service for suspicious
+// traffic. The cluster must be defined in the Envoy config.
+func DoRouteToUpstream(cluster string) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		if ctx.Stage != StageRequestHeaders {
+			ctx.LogWarn("cannot change the upstream after the request headers")
+			return VerdictModified
+		}
+		ctx.SetRequestHeader(upstreamHeader, cluster)
+		ctx.LogInfo("routed to " + cluster)
+		return VerdictModified
+	}
+}
+
+// Rewrites the request's Host (:authority), e.g. for a virtual host of the sandboxed copy.
+func DoRewriteHost(host string) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		if ctx.Stage != StageRequestHeaders {
+			ctx.LogWarn("cannot rewrite the host after the request headers")
+			return VerdictModified
+		}
+		ctx.SetRequestHeader(":authority", host)
+		return VerdictModified
+	}
+}
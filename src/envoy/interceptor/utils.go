@@ -1,26 +1,9 @@
 package main
 
 import (
-	"encoding/binary"
-	"fmt"
-
-	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"strings"
 )
 
-func getIntProperty(path []string) (int64, error) {
-	v, err := proxywasm.GetProperty(path)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get property %v: %w", path, err)
-	}
-	if v == nil {
-		return 0, fmt.Errorf("property %v not found", path)
-	}
-	if len(v) != 8 {
-		return 0, fmt.Errorf("unexpected property %v length: %d", path, len(v))
-	}
-	return int64(binary.LittleEndian.Uint64(v)), nil
-}
-
 // Human-readable representation of the stage.
 func (s HttpStage) String() string {
 	switch s {
@@ -28,10 +11,14 @@ func (s HttpStage) String() string {
 		return "req:headers"
 	case StageRequestBody:
 		return "req:body"
+	case StageRequestTrailers:
+		return "req:trailers"
 	case StageResponseHeaders:
 		return "resp:headers"
 	case StageResponseBody:
 		return "resp:body"
+	case StageResponseTrailers:
+		return "resp:trailers"
 	default:
 		return "unknown"
 	}
@@ -43,7 +30,37 @@ func (s TcpStage) String() string {
 		return "down:data"
 	case TcpStageUpstreamData:
 		return "up:date"
+	case TcpStageConnect:
+		return "connect"
 	default:
 		return "unknown"
 	}
 }
+
+// Per-stream memoized header values, keyed by lowercased name. Missing headers are cached as "".
+type headerCache map[string]string
+
+func (c *headerCache) get(name string, fetch func(string) (string, error)) string {
+	key := strings.ToLower(name)
+	if v, ok := (*c)[key]; ok {
+		return v
+	}
+	if *c == nil {
+		*c = headerCache{}
+	}
+	v, _ := fetch(key)
+	(*c)[key] = v
+	return v
+}
+
+func (c headerCache) set(name, value string) {
+	if c != nil {
+		c[strings.ToLower(name)] = value
+	}
+}
+
+func (c headerCache) del(name string) {
+	if c != nil {
+		c[strings.ToLower(name)] = ""
+	}
+}
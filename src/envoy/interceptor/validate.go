@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Problems found while registering rules (bad patterns, schemas, assets...). Registration keeps
+// going so all of them are reported by the validation pass of OnPluginStart.
+var ruleErrors []error
+
+func ruleFailed(err error) {
+	ruleErrors = append(ruleErrors, err)
+}
+
+// Validation pass of OnPluginStart: loads the plugin config and checks it along with every
+// registered rule, reporting all problems at once before any traffic flows.
+func validatePlugin() error {
+	errs := append([]error(nil), ruleErrors...)
+	if err := loadPluginConfig(); err != nil {
+		errs = append(errs, fmt.Errorf("plugin configuration: %w", err))
+	}
+	errs = append(errs, validateHttpRules()...)
+	errs = append(errs, validateTcpRules()...)
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	rules, exceptions, ports := 0, 0, map[int64]bool{}
+	for key, ints := range httpReg {
+		ports[key.port] = true
+		for _, it := range ints {
+			rules++
+			if it.isException() {
+				exceptions++
+			}
+		}
+	}
+	for key, ints := range tcpReg {
+		ports[key.port] = true
+		rules += len(ints)
+	}
+	proxywasm.LogInfo(fmt.Sprintf("validated %d rules (%d exceptions) on %d ports, %d compiled regexps", rules, exceptions, len(ports), len(matchers.regexps)))
+	return nil
+}
+
+func validateHttpRules() []error {
+	var errs []error
+	for key, ints := range httpReg {
+		targets := map[string]bool{}
+		for _, it := range ints {
+			if it.isException() {
+				continue
+			}
+			targets[it.Name] = true
+			for _, g := range it.Groups {
+				targets[g] = true
+			}
+		}
+		for _, it := range ints {
+			switch {
+			case it.When == nil:
+				errs = append(errs, fmt.Errorf("http interceptor %s on port %d has no When", it.Name, key.port))
+			case it.Do == nil && !it.isException():
+				errs = append(errs, fmt.Errorf("http interceptor %s on port %d has no Do", it.Name, key.port))
+			}
+			for _, e := range it.Exempts {
+				if !targets[e] {
+					errs = append(errs, fmt.Errorf("exception %s on port %d exempts from unknown interceptor or group %q", it.Name, key.port, e))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func validateTcpRules() []error {
+	var errs []error
+	for key, ints := range tcpReg {
+		for _, it := range ints {
+			if it.When == nil || it.Do == nil {
+				errs = append(errs, fmt.Errorf("tcp interceptor %s on port %d lacks When or Do", it.Name, key.port))
+			}
+		}
+	}
+	return errs
+}
+
+// Checks that the names the config refers to exist.
+func (c pluginConfig) checkReferences() error {
+	var errs []error
+	if _, _, ok := Asset(c.Maintenance.Asset); c.Maintenance.Asset != "" && !ok {
+		errs = append(errs, fmt.Errorf("unknown maintenance asset %q", c.Maintenance.Asset))
+	}
+	// the TCP VM registers no HTTP rules but may get the same config
+	if len(httpReg) > 0 {
+		for name := range c.RateLimits {
+			if !rateLimitNames[name] {
+				errs = append(errs, fmt.Errorf("rate_limits: no rate limit named %q", name))
+			}
+		}
+	}
+	switch c.Webhook.Format {
+	case "", "json", "slack", "discord":
+	default:
+		errs = append(errs, fmt.Errorf("unknown webhook format %q", c.Webhook.Format))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// Verdict is what a Do decided for the stream at the current stage.
+type Verdict int
+
+const (
+	// Let the data through; Do is called again at the next stage.
+	VerdictContinue Verdict = iota
+	// Hold the data (e.g. to buffer the body); Do is called again with more data or at the next stage.
+	VerdictPause
+	// Do is finished; the stream, possibly modified, goes on without further interception.
+	VerdictModified
+	// Do is finished and replied locally (or holds the stream for good); nothing more is forwarded.
+	VerdictBlocked
+	// Do is finished and closed the connection; nothing more is forwarded.
+	VerdictDropped
+)
+
+// Reports whether Do is finished with the stream.
+func (v Verdict) Final() bool {
+	return v >= VerdictModified
+}
+
+// SDK action returned to the proxy for the verdict.
+func (v Verdict) action() types.Action {
+	if v == VerdictContinue || v == VerdictModified {
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
+
+func (v Verdict) String() string {
+	switch v {
+	case VerdictContinue:
+		return "continue"
+	case VerdictPause:
+		return "pause"
+	case VerdictModified:
+		return "modified"
+	case VerdictBlocked:
+		return "blocked"
+	case VerdictDropped:
+		return "dropped"
+	default:
+		return "unknown"
+	}
+}
+
+// Ends processing of the stream: every further callback returns the verdict's action.
+func (h *httpCtx) finish(v Verdict) types.Action {
+	h.finished = true
+	h.verdict = v
+	h.doContexts = nil
+	return v.action()
+}
+
+// Ends processing of the connection: every further callback returns the verdict's action.
+func (ctx *tcpCtx) finish(v Verdict) types.Action {
+	ctx.finished = true
+	ctx.verdict = v
+	ctx.doContexts = nil
+	return v.action()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Webhook notified of events (blocks, fuses... and optionally matches), "webhook" in the plugin
+// config. Events are queued per worker and posted in batches from the tick.
+type webhookConfig struct {
+	// Envoy cluster of the webhook (disabled if empty)
+	Cluster   string `json:"cluster"`
+	Authority string `json:"authority"`
+	Path      string `json:"path"`
+	// Body format: "json" (default), "slack" or "discord"
+	Format string `json:"format"`
+	// Seconds between batches (default 5)
+	Interval int `json:"interval"`
+	// Also report When matches, not just blocks
+	Matches bool `json:"matches"`
+}
+
+const (
+	// Events beyond this are counted but not kept until the next batch
+	maxWebhookQueue  = 200
+	webhookTimeoutMs = 5000
+	// Discord rejects longer messages
+	maxDiscordContent = 2000
+)
+
+var (
+	webhookQueue     []Event
+	webhookDropped   int
+	webhookLastFlush time.Time
+)
+
+func (c webhookConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.Interval) * time.Second
+}
+
+func webhookTickPeriod() time.Duration {
+	if config.Webhook.Cluster == "" {
+		return 0
+	}
+	return time.Second
+}
+
+// Queues an event for the next batch; does nothing if no webhook is configured.
+func queueWebhook(e Event) {
+	if config.Webhook.Cluster == "" {
+		return
+	}
+	if e.Kind == "match" && !config.Webhook.Matches {
+		return
+	}
+	if len(webhookQueue) >= maxWebhookQueue {
+		webhookDropped++
+		return
+	}
+	webhookQueue = append(webhookQueue, e)
+}
+
+func webhookBody(format string, events []Event, dropped int) []byte {
+	var b []byte
+	switch format {
+	case "slack", "discord":
+		lines := make([]string, 0, len(events)+1)
+		for _, e := range events {
+			lines = append(lines, e.String())
+		}
+		if dropped > 0 {
+			lines = append(lines, fmt.Sprintf("(%d more events)", dropped))
+		}
+		text := strings.Join(lines, "\n")
+		if format == "slack" {
+			b, _ = json.Marshal(map[string]string{"text": text})
+		} else {
+			if len(text) > maxDiscordContent {
+				text = text[:maxDiscordContent-3] + "..."
+			}
+			b, _ = json.Marshal(map[string]string{"content": text})
+		}
+	default:
+		b, _ = json.Marshal(map[string]interface{}{"events": events, "dropped": dropped})
+	}
+	return b
+}
+
+// Called from the plugin tick: posts the queued events once the interval passed.
+func flushWebhook() {
+	c := config.Webhook
+	if c.Cluster == "" || (len(webhookQueue) == 0 && webhookDropped == 0) || Since(webhookLastFlush) < c.interval() {
+		return
+	}
+	webhookLastFlush = Now()
+	body := webhookBody(c.Format, webhookQueue, webhookDropped)
+	count := len(webhookQueue) + webhookDropped
+	webhookQueue, webhookDropped = nil, 0
+
+	headers := [][2]string{
+		{":method", "POST"},
+		{":path", c.Path},
+		{":authority", c.Authority},
+		{"content-type", "application/json"},
+	}
+	if token := Secret(SecretWebhookToken); token != "" {
+		headers = append(headers, [2]string{"authorization", "Bearer " + token})
+	}
+	_, err := proxywasm.DispatchHttpCall(c.Cluster, headers, body, nil, webhookTimeoutMs, func(int, int, int) {
+		if status := httpCallStatus(); !strings.HasPrefix(status, "2") {
+			proxywasm.LogWarn(fmt.Sprintf("webhook: status %s, %d events lost", status, count))
+		}
+	})
+	if err != nil {
+		proxywasm.LogWarn(fmt.Sprintf("webhook: %s, %d events lost", err.Error(), count))
+	}
+}
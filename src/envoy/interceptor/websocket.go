@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// WebSocket frame opcodes.
+type WsOpcode byte
+
+const (
+	WsContinuation WsOpcode = 0x0
+	WsText         WsOpcode = 0x1
+	WsBinary       WsOpcode = 0x2
+	WsClose        WsOpcode = 0x8
+	WsPing         WsOpcode = 0x9
+	WsPong         WsOpcode = 0xa
+)
+
+// Close status sent when a frame is blocked.
+const wsPolicyViolation = 1008
+
+type WsFrame struct {
+	Fin    bool
+	Rsv    byte
+	Opcode WsOpcode
+	// Masking key, nil for unmasked (server to client) frames; Payload is always unmasked
+	Mask    []byte
+	Payload []byte
+}
+
+// Parses a complete frame from b; n is 0 if b doesn't hold one yet.
+func parseWsFrame(b []byte) (f WsFrame, n int) {
+	if len(b) < 2 {
+		return f, 0
+	}
+	f.Fin = b[0]&0x80 != 0
+	f.Rsv = b[0] >> 4 & 0x7
+	f.Opcode = WsOpcode(b[0] & 0xf)
+	masked := b[1]&0x80 != 0
+	size := uint64(b[1] & 0x7f)
+	n = 2
+	switch size {
+	case 126:
+		if len(b) < n+2 {
+			return f, 0
+		}
+		size = uint64(binary.BigEndian.Uint16(b[n:]))
+		n += 2
+	case 127:
+		if len(b) < n+8 {
+			return f, 0
+		}
+		size = binary.BigEndian.Uint64(b[n:])
+		n += 8
+	}
+	if masked {
+		if len(b) < n+4 {
+			return f, 0
+		}
+		f.Mask = b[n : n+4]
+		n += 4
+	}
+	if size > uint64(len(b)-n) {
+		return f, 0
+	}
+	f.Payload = make([]byte, size)
+	copy(f.Payload, b[n:])
+	for i := range f.Payload {
+		if f.Mask != nil {
+			f.Payload[i] ^= f.Mask[i%4]
+		}
+	}
+	return f, n + int(size)
+}
+
+func appendWsFrame(b []byte, f WsFrame) []byte {
+	head := f.Rsv<<4 | byte(f.Opcode)
+	if f.Fin {
+		head |= 0x80
+	}
+	b = append(b, head)
+
+	var maskBit byte
+	if f.Mask != nil {
+		maskBit = 0x80
+	}
+	size := len(f.Payload)
+	switch {
+	case size < 126:
+		b = append(b, maskBit|byte(size))
+	case size <= 0xffff:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(size))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(size))
+	}
+	if f.Mask == nil {
+		return append(b, f.Payload...)
+	}
+	b = append(b, f.Mask...)
+	for i, c := range f.Payload {
+		b = append(b, c^f.Mask[i%4])
+	}
+	return b
+}
+
+// Close frame with a policy violation status; frames towards the server must be masked.
+func wsCloseFrame(fromClient bool) WsFrame {
+	f := WsFrame{Fin: true, Opcode: WsClose, Payload: binary.BigEndian.AppendUint16(nil, wsPolicyViolation)}
+	f.Payload = append(f.Payload, "blocked"...)
+	if fromClient {
+		f.Mask = []byte{0, 0, 0, 0}
+	}
+	return f
+}
+
+// WsFrameContext is passed to the When and Do of WebSocket interceptors for every frame.
+type WsFrameContext struct {
+	// proxy-wasm context id of the upgraded stream
+	ContextID uint32
+	// Downstream IP ("" if unavailable)
+	SourceIP string
+	// Whether the frame goes from the client to the service
+	FromClient bool
+	// The frame; Do may change its payload
+	Frame *WsFrame
+	// Any data needed to persist between frames of the connection
+	Data interface{}
+
+	LogInfo func(message string)
+	LogWarn func(message string)
+}
+
+type wsStream struct {
+	data    interface{}
+	matched bool
+	done    bool
+	// Directions which were closed after a blocked frame, client first
+	closed [2]bool
+}
+
+func isWsUpgrade(ctx *HttpWhenContext) bool {
+	return strings.EqualFold(ctx.GetRequestHeader("upgrade"), "websocket")
+}
+
+// Registers frame-level interceptors on WebSocket connections of a port. When is called for every
+// frame until it matches; Do is then called for that and every later frame, until it returns a final
+// verdict. VerdictModified forwards the frame as changed by Do, VerdictBlocked and VerdictDropped
+// replace it with a close frame and drop everything after it in that direction. Compression
+// extensions are not offered to the service, so payloads are always plain.
+func RegisterWsInterceptor(port int64, name string, when func(*WsFrameContext) bool, do func(*WsFrameContext) Verdict) {
+	RegisterHttpInterceptor(port, name, isWsUpgrade, doWsFrames(when, do), WithStages(StageRequestHeaders))
+}
+
+func doWsFrames(when func(*WsFrameContext) bool, do func(*WsFrameContext) Verdict) func(*HttpDoContext) Verdict {
+	return func(ctx *HttpDoContext) Verdict {
+		s := State[wsStream](ctx)
+
+		var get func(start, size int) ([]byte, error)
+		var replace func([]byte) error
+		fromClient := false
+		switch ctx.Stage {
+		case StageRequestHeaders:
+			ctx.DelRequestHeader("sec-websocket-extensions")
+			return VerdictContinue
+		case StageResponseHeaders:
+			if ctx.GetResponseHeader(":status") != "101" {
+				return VerdictModified
+			}
+			return VerdictContinue
+		case StageRequestBody:
+			get, replace, fromClient = ctx.GetRequestBody, ctx.ReplaceRequestBody, true
+		case StageResponseBody:
+			get, replace = ctx.GetResponseBody, ctx.ReplaceResponseBody
+		default:
+			return VerdictContinue
+		}
+
+		dir := 1
+		if fromClient {
+			dir = 0
+		}
+		if s.closed[dir] {
+			replace(nil)
+			return VerdictContinue
+		}
+		if ctx.BodySize == 0 {
+			return VerdictContinue
+		}
+		body, err := get(0, ctx.BodySize)
+		if err != nil {
+			return VerdictContinue
+		}
+
+		var frames []WsFrame
+		rest := body
+		for len(rest) > 0 {
+			f, n := parseWsFrame(rest)
+			if n == 0 {
+				break
+			}
+			frames = append(frames, f)
+			rest = rest[n:]
+		}
+		if len(rest) > 0 && !ctx.End {
+			// Incomplete frame: hold everything until it arrived
+			return VerdictPause
+		}
+
+		var out []byte
+		for i := range frames {
+			f := &frames[i]
+			fc := &WsFrameContext{
+				ContextID:  ctx.ContextID,
+				SourceIP:   ctx.SourceIP,
+				FromClient: fromClient,
+				Frame:      f,
+				Data:       s.data,
+				LogInfo:    ctx.LogInfo,
+				LogWarn:    ctx.LogWarn,
+			}
+			if !s.matched && when(fc) {
+				s.matched = true
+			}
+			if s.matched && !s.done {
+				v := do(fc)
+				if v >= VerdictBlocked {
+					ctx.LogInfo("blocked websocket frame, closing")
+					s.closed[dir] = true
+					replace(appendWsFrame(out, wsCloseFrame(fromClient)))
+					return VerdictContinue
+				}
+				s.done = v == VerdictModified
+			}
+			s.data = fc.Data
+			out = appendWsFrame(out, *f)
+		}
+		if err := replace(append(out, rest...)); err != nil {
+			ctx.LogWarn("failed to replace websocket frames: " + err.Error())
+		}
+		return VerdictContinue
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// Entities expanding to more than this are considered an expansion attack.
+const maxEntityExpansion = 64 << 10
+
+var (
+	entityDecl  = regexp.MustCompile(`(?is)<!ENTITY\s+(%\s*)?([\w.:-]+)\s+(?:(SYSTEM|PUBLIC)\b|"([^"]*)"|'([^']*)')`)
+	externalDtd = regexp.MustCompile(`(?is)<!DOCTYPE\s+[\w.:-]+\s+(SYSTEM|PUBLIC)\b`)
+	entityRef   = regexp.MustCompile(`[&%]([\w.:-]+);`)
+)
+
+// Returns why an XML document looks like an XXE attempt, "" if it doesn't: external DTDs or
+// entities, parameter entities, XInclude, entities expanding beyond maxEntityExpansion, or any
+// other entity declaration. UTF-16 documents are decoded first.
+func XmlThreat(body []byte) string {
+	doc := Normalize(body, NormalizeCharset)
+	if !bytes.Contains(doc, []byte("<!")) && !bytes.Contains(doc, []byte("include")) {
+		return ""
+	}
+	if externalDtd.Match(doc) {
+		return "external DTD"
+	}
+	if bytes.Contains(doc, []byte("http://www.w3.org/2001/XInclude")) {
+		return "XInclude"
+	}
+
+	values := map[string]string{}
+	for _, m := range entityDecl.FindAllSubmatch(doc, -1) {
+		switch {
+		case len(m[3]) > 0:
+			return "external entity " + string(m[2])
+		case len(m[1]) > 0:
+			return "parameter entity " + string(m[2])
+		}
+		values[string(m[2])] = string(m[4]) + string(m[5])
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	sizes := map[string]int{}
+	for name := range values {
+		if entitySize(name, values, sizes, 0) > maxEntityExpansion {
+			return "entity expansion " + name
+		}
+	}
+	return "entity declaration"
+}
+
+// Expanded size of an entity, capped just above maxEntityExpansion; cycles count as oversized.
+func entitySize(name string, values map[string]string, sizes map[string]int, depth int) int {
+	if size, ok := sizes[name]; ok {
+		return size
+	}
+	value, ok := values[name]
+	if !ok {
+		return len(name) + 2
+	}
+	if depth > 32 {
+		return maxEntityExpansion + 1
+	}
+	// marks the entity as being expanded, so a cycle resolves to oversized
+	sizes[name] = maxEntityExpansion + 1
+	size := len(entityRef.ReplaceAllString(value, ""))
+	for _, ref := range entityRef.FindAllStringSubmatch(value, -1) {
+		size += entitySize(ref[1], values, sizes, depth+1)
+		if size > maxEntityExpansion {
+			break
+		}
+	}
+	sizes[name] = size
+	return size
+}
+
+// Body matcher flagging XXE attempts, for Matcher.Body.
+func MatchXxe(body []byte) bool {
+	return XmlThreat(body) != ""
+}
+
+// Matches requests with an XML content type (XXE checks only make sense for those).
+func isXmlContentType(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	return strings.Contains(ct, "xml") || strings.Contains(ct, "soap")
+}
+
+// Rejects XML request bodies (by content type) carrying XXE attempts on a port with 400.
+func RegisterXxeProtection(port int64) {
+	match := MatchHttpRequest(Matcher{Body: MatchXxe})
+	RegisterHttpInterceptor(port, "xxe", func(ctx *HttpWhenContext) bool {
+		if !isXmlContentType(ctx.GetRequestHeader("content-type")) {
+			return false
+		}
+		return match(ctx)
+	}, DoBadRequest, WithStages(StageRequestHeaders, StageRequestBody, StageRequestTrailers), WithExploit())
+}